@@ -0,0 +1,15 @@
+package assets
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed rules
+var embedded embed.FS
+
+// The predefined ruleset with the fixtures of its rules, built into the binary: it is used when no rules are given
+var Rules, _ = fs.Sub(embedded, "rules")
+
+// Where the embedded rules are in the repository, to report them
+const RulesRoot = "assets/rules"
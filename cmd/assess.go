@@ -5,16 +5,28 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/primait/nuvola/assets"
 	"github.com/primait/nuvola/pkg/connector"
+	"github.com/primait/nuvola/pkg/connector/services/graph"
 	"github.com/primait/nuvola/pkg/io/logging"
-	"github.com/primait/nuvola/tools/filesystem/files"
+	"github.com/primait/nuvola/pkg/report"
 	unzip "github.com/primait/nuvola/tools/filesystem/zip"
 	"github.com/primait/nuvola/tools/yamler"
 	"github.com/spf13/cobra"
 )
 
+// Exit code of assess with --fail-on-findings when the enabled rules have findings, to use it as a gate: errors exit
+// with 1
+const exitFindings = 2
+
 var assessCmd = &cobra.Command{
 	Use:   "assess",
 	Short: "Execute assessment queries against data loaded in Neo4J",
@@ -29,22 +41,57 @@ func runAssessCmd(cmd *cobra.Command, args []string) {
 		logger.SetDebugLevel()
 	}
 
-	storageConnector := connector.NewStorageConnector()
+	format := strings.ToLower(assessFormat)
+	if !slices.Contains(report.Formats, format) {
+		logger.Error("Unknown output format", "format", assessFormat)
+	}
+	checkRuleFilters()
+
+	results := assess(openBackend(), ruleFiles(rulePaths), false)
+	expired := applySuppressions(results)
+	if format == report.FormatText {
+		printAssessment(results, expired)
+	} else {
+		writeAssessment(results, expired, format, assessOutput)
+	}
+	if rules := report.NotEvaluated(results); len(rules) > 0 {
+		logger.Error("Some rules were not evaluated", "rules", rules)
+	}
+	if failOnFindings && report.HasFindings(results) {
+		os.Exit(exitFindings)
+	}
+}
+
+// The storage selected with --backend, with the ZIP file of --import loaded
+func openBackend() *connector.StorageConnector {
+	var storageConnector *connector.StorageConnector
+	switch strings.ToLower(backend) {
+	case "neo4j":
+		storageConnector = connector.NewStorageConnector()
+	case "memory":
+		if importFile == "" {
+			logger.Error("The memory backend requires a ZIP file to import", "flag", flagImportFile)
+		}
+		storageConnector = connector.NewMemoryStorageConnector()
+	default:
+		logger.Error("Unknown backend", "backend", backend)
+	}
+
 	if importFile != "" {
 		logger.Debug(fmt.Sprintf("Importing %s", importFile))
 		importZipFile(storageConnector, importFile)
 		logger.Debug(fmt.Sprintf("Imported %s", importFile))
 	}
+	return storageConnector
+}
 
-	assess(storageConnector, "./assets/rules/")
+// The order is important: effective permissions need the Organization and all the IAM entities
+var importOrdering = []string{
+	"Whoami", "Catalog", "Organization", "Groups", "Users", "Roles", "Buckets", "EC2s", "VPCs", "Lambdas", "RDS", "DynamoDBs", "RedshiftDBs",
 }
 
 func importZipFile(connector *connector.StorageConnector, zipfile string) {
-	connector.FlushAll()
-	ordering := []string{
-		"Groups", "Users", "Roles", "Buckets", "EC2s", "VPCs", "Lambdas", "RDS", "DynamoDBs", "RedshiftDBs",
-	}
-	orderedFiles := make([]*zip.File, len(ordering))
+	connector.BeginImport(flush)
 
 	r := unzip.UnzipInMemory(zipfile)
 	defer func() {
@@ -53,23 +100,35 @@ func importZipFile(connector *connector.StorageConnector, zipfile string) {
 		}
 	}()
 
+	// Archives created with --org-role have a folder for each account
+	accounts := make(map[string][]*zip.File)
 	for _, f := range r.File {
-		for ord := range ordering {
-			if strings.HasPrefix(f.Name, ordering[ord]) {
-				orderedFiles[ord] = f
-				break
-			}
-		}
+		accounts[path.Dir(f.Name)] = append(accounts[path.Dir(f.Name)], f)
 	}
 
-	for _, f := range orderedFiles {
-		if f == nil {
-			continue
+	for _, account := range slices.Sorted(maps.Keys(accounts)) {
+		orderedFiles := make([]*zip.File, len(importOrdering))
+		for _, f := range accounts[account] {
+			for ord := range importOrdering {
+				if strings.HasPrefix(path.Base(f.Name), importOrdering[ord]) {
+					orderedFiles[ord] = f
+					break
+				}
+			}
 		}
-		if err := processZipFile(connector, f); err != nil {
-			logger.Error("Processing ZIP file", "err", err)
+
+		for _, f := range orderedFiles {
+			if f == nil {
+				continue
+			}
+			if err := processZipFile(connector, f); err != nil {
+				logger.Error("Processing ZIP file", "err", err)
+			}
 		}
+		connector.TagAccount()
 	}
+	connector.AddEffectivePermissions()
+	connector.AddPrivilegeEscalations()
 }
 
 func processZipFile(connector *connector.StorageConnector, f *zip.File) error {
@@ -89,47 +148,242 @@ func processZipFile(connector *connector.StorageConnector, f *zip.File) error {
 		return fmt.Errorf("copying buffer from ZIP: %w", err)
 	}
 
-	connector.ImportResults(f.Name, buf.Bytes())
+	connector.ImportResults(path.Base(f.Name), buf.Bytes())
 	return nil
 }
 
-func assess(connector *connector.StorageConnector, rulesPath string) {
-	// perform checks based on pre-defined static rules
-	logger := logging.GetLogManager()
-	for _, rule := range files.GetFiles(rulesPath, ".ya?ml") {
-		c := yamler.GetConf(rule)
-		if !c.Enabled {
+// Evaluate the selected rules: each of them has a result, with or without findings. The paths of the privilege
+// escalation rules are only queried if requested
+func assess(connector *connector.StorageConnector, rules []yamler.RuleFile, withPaths bool) []report.Result {
+	results := []report.Result{}
+	for _, rule := range rules {
+		c, err := rule.Conf()
+		if err != nil {
+			// The filters are applied to a malformed rule only if its name was decoded
+			if c == nil || c.Name == "" || selected(c) {
+				results = append(results, malformedResult(rule, c, err))
+			}
+			continue
+		}
+		if !selected(c) {
 			continue
 		}
 
 		query, args := yamler.PrepareQuery(c)
-		results := connector.Query(query, args)
+		result := report.Result{
+			Rule:        c.Name,
+			File:        relativePath(rule.Path),
+			Description: c.Description,
+			Severity:    c.Severity,
+			Category:    c.Category,
+			Tags:        c.Tags,
+			References:  c.References,
+			Remediation: c.Remediation,
+			Compliance:  c.Compliance,
+			Query:       query,
+			Arguments:   args,
+			Matches:     []report.Match{},
+		}
+		records, err := connector.QueryRule(c, query, args)
+		if err != nil {
+			logger.Warn("Rule not evaluated", "rule", c.Name, "err", err)
+			result.NotEvaluated = []string{err.Error()}
+		}
+		for _, properties := range records {
+			result.Matches = append(result.Matches, newMatch(c, properties))
+		}
+		if withPaths && result.HasFindings() {
+			if result.Paths, err = connector.QueryRulePaths(c); err != nil {
+				logger.Warn("Unable to query the paths of the rule", "rule", c.Name, "err", err)
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
 
-		logger.PrintRed("Running rule: " + rule)
-		logger.PrintGreen("Name: " + c.Name)
+// A malformed rule is skipped: it is reported as not evaluated, with its problems
+func malformedResult(rule yamler.RuleFile, c *yamler.Conf, err error) report.Result {
+	result := report.Result{
+		Rule:         strings.TrimSuffix(filepath.Base(rule.Path), filepath.Ext(rule.Path)),
+		File:         relativePath(rule.Path),
+		Severity:     yamler.DefaultSeverity,
+		Matches:      []report.Match{},
+		NotEvaluated: ruleProblems(rule, err),
+	}
+	if c != nil && c.Name != "" {
+		result.Rule, result.Description = c.Name, c.Description
+	}
+	if c != nil && yamler.SeverityLevel(c.Severity) >= 0 {
+		result.Severity = c.Severity
+	}
+	logger.Warn("Malformed rule not evaluated: check it with `nuvola rules validate`", "rule", result.File, "err", err)
+	return result
+}
+
+// The values of a match are selected by the Return keys of the rule: the records of a services rule are
+// already projected, the columns of a cypher rule are all its values when the rule has no Return
+func newMatch(c *yamler.Conf, record map[string]interface{}) report.Match {
+	projections, _ := yamler.ParseReturn(c.Return)
+	switch {
+	case c.Cypher != "":
+		values := record
+		if len(c.Return) > 0 {
+			values = yamler.Project(projections, record)
+		}
+		return report.NewRecordMatch(record, c.Identifiers, values)
+	case len(c.Services) > 0:
+		values := yamler.RecordValues(record)
+		properties := maps.Clone(values)
+		properties[yamler.IdentifierColumn] = record[yamler.IdentifierColumn]
+		return report.NewMatch(properties, values)
+	case len(c.Find.Target) > 0:
+		principal, _ := record[yamler.PrincipalColumn].(map[string]interface{})
+		path, _ := record[yamler.PathColumn].(graph.Path)
+		return report.NewPathMatch(principal, yamler.Project(projections, principal), path)
+	}
+	return report.NewMatch(record, yamler.Project(projections, record))
+}
+
+// The rules of --rules, or the ruleset embedded in the binary when there are none
+func ruleFiles(paths []string) []yamler.RuleFile {
+	var rules []yamler.RuleFile
+	var err error
+	if len(paths) == 0 {
+		rules, err = yamler.EmbeddedRuleFiles(assets.Rules, assets.RulesRoot)
+	} else {
+		rules, err = yamler.RuleFiles(paths)
+	}
+	if err != nil {
+		logger.Error("Error on reading the rules", "err", err)
+	}
+	return rules
+}
+
+func checkRuleFilters() {
+	if minSeverity != "" && yamler.SeverityLevel(minSeverity) < 0 {
+		logger.Error("Unknown severity", "severity", minSeverity, "severities", yamler.Severities)
+	}
+	for _, pattern := range slices.Concat(ruleNames, excludedRules) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			logger.Error("Malformed rule name pattern", "pattern", pattern, "err", err)
+		}
+	}
+}
+
+// Whether the rule passes the filters of --rule-name, --exclude, --severity and --tag. Only the enabled rules are
+// selected, unless they are named by --rule-name
+func selected(c *yamler.Conf) bool {
+	if matchesName(c.Name, excludedRules) {
+		return false
+	}
+	if len(ruleNames) > 0 && !matchesName(c.Name, ruleNames) || len(ruleNames) == 0 && !c.Enabled {
+		return false
+	}
+	if minSeverity != "" && yamler.SeverityLevel(c.Severity) < yamler.SeverityLevel(minSeverity) {
+		return false
+	}
+	return len(ruleTags) == 0 || c.HasTag(ruleTags...)
+}
+
+// Names are matched case insensitive, with the wildcards of path.Match (e.g. "ec2-*")
+func matchesName(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Move the findings accepted in the file of --suppressions apart: the expired suppressions are returned to flag them
+func applySuppressions(results []report.Result) []report.Suppression {
+	if suppressionFile == "" {
+		return nil
+	}
+	suppressions, err := report.LoadSuppressions(suppressionFile)
+	if err != nil {
+		logger.Error("Malformed suppressions file", "err", err)
+	}
+	return report.Suppress(results, suppressions, time.Now())
+}
+
+// Rule files are reported relative to the working directory when they are inside it
+func relativePath(file string) string {
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, file); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return file
+}
+
+func printAssessment(results []report.Result, expired []report.Suppression) {
+	logger := logging.GetLogManager()
+	for i := range results {
+		result := &results[i]
+		logger.PrintRed("Running rule: " + result.File)
+		logger.PrintGreen("Name: " + result.Rule)
 		logger.PrintGreen("Arguments:")
-		logger.PrintDarkGreen(yamler.ArgsToQueryNeo4jBrowser(args))
+		logger.PrintDarkGreen(yamler.ArgsToQueryNeo4jBrowser(result.Arguments))
 		logger.PrintGreen("Query:")
-		logger.PrintDarkGreen(query)
-		logger.PrintGreen("Description: " + c.Description)
+		logger.PrintDarkGreen(result.Query)
+		logger.PrintGreen("Description: " + result.Description)
+		logger.PrintGreen("Severity: " + result.Severity)
+		if result.Category != "" {
+			logger.PrintGreen("Category: " + result.Category)
+		}
+		if len(result.Tags) > 0 {
+			logger.PrintGreen("Tags: " + strings.Join(result.Tags, ", "))
+		}
+		for _, framework := range slices.Sorted(maps.Keys(result.Compliance)) {
+			logger.PrintGreen(fmt.Sprintf("Compliance: %s %s", framework, strings.Join(result.Compliance[framework], ", ")))
+		}
+		if result.Remediation != "" && result.HasFindings() {
+			logger.PrintGreen("Remediation: " + strings.TrimSpace(result.Remediation))
+		}
+		for _, reference := range result.References {
+			logger.PrintGreen("Reference: " + reference)
+		}
 
-		for _, resultMap := range results {
-			for key, value := range resultMap {
-				printResults(c.Return, key, value)
+		for _, reason := range result.NotEvaluated {
+			logger.PrintRed("Not evaluated: " + reason)
+		}
+		for _, match := range result.Matches {
+			for _, key := range slices.Sorted(maps.Keys(match.Values)) {
+				fmt.Printf("%s: %v\n", key, match.Values[key])
+			}
+			if steps := match.Steps(); steps != "" {
+				fmt.Printf("Path: %s\n", steps)
 			}
 		}
+		for _, match := range result.Suppressed {
+			logger.PrintDarkGreen(fmt.Sprintf("Suppressed %s: %s", match.String(), match.Suppression.String()))
+		}
 		fmt.Print("\n")
 	}
+	for i := range expired {
+		logger.PrintRed("Expired suppression: " + expired[i].String())
+	}
 }
 
-func printResults(returnKeys []string, key string, value interface{}) {
-	for _, retValue := range returnKeys {
-		if strings.HasSuffix(retValue, "*") {
-			retValue = strings.TrimRight(retValue[:len(retValue)-1], "_")
-		}
-		if strings.HasPrefix(key, retValue) {
-			fmt.Printf("%s: %v\n", key, value)
+// Write the results to the output file, or to stdout
+func writeAssessment(results []report.Result, expired []report.Suppression, format string, output string) {
+	w := os.Stdout
+	if output != "" {
+		f, err := os.Create(filepath.Clean(output))
+		if err != nil {
+			logger.Error("Error on creating output file", "err", err)
 		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				logger.Error("Error closing file", "err", err)
+			}
+		}()
+		w = f
+	}
+	if err := report.Write(w, format, results, expired); err != nil {
+		logger.Error("Error on writing the assessment", "err", err)
 	}
 }
 
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/primait/nuvola/pkg/connector/services/aws/catalog"
+	"github.com/spf13/cobra"
+)
+
+var (
+	catalogOutput string
+	catalogCmd    = &cobra.Command{
+		Use:   "catalog",
+		Short: "Manage the catalog of AWS actions used to expand the policies",
+	}
+	catalogUpdateCmd = &cobra.Command{
+		Use:   "update",
+		Short: "Download the latest AWS actions, resource types and condition keys into the local cache",
+		Run:   runCatalogUpdateCmd,
+	}
+)
+
+func runCatalogUpdateCmd(cmd *cobra.Command, args []string) {
+	if cmd.Flags().Changed(flagVerbose) {
+		logger.SetVerboseLevel()
+	}
+	if cmd.Flags().Changed(flagDebug) {
+		logger.SetDebugLevel()
+	}
+
+	output := catalogOutput
+	if output == "" {
+		path, err := catalog.CachePath()
+		if err != nil {
+			logger.Error("Unable to locate the catalog cache", "err", err)
+		}
+		output = path
+	}
+
+	logger.Info("Downloading the AWS service reference", "url", catalog.ServiceReferenceURL)
+	updated, err := catalog.Update(context.Background())
+	if err != nil {
+		logger.Error("Error on updating the catalog", "err", err)
+	}
+	if err := updated.Save(output); err != nil {
+		logger.Error("Error on saving the catalog", "err", err)
+	}
+	logger.Info("Catalog updated", "version", updated.Version, "services", len(updated.Services), "actions", len(updated.Actions()), "path", output)
+}
+
+func init() {
+	catalogUpdateCmd.Flags().StringVarP(&catalogOutput, flagOutputFile, "o", "", "File where the catalog is saved, compressed if it ends with .gz (default: the user cache folder)")
+	catalogCmd.AddCommand(catalogUpdateCmd)
+	rootCmd.AddCommand(catalogCmd)
+}
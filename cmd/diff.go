@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/primait/nuvola/pkg/connector"
+	"github.com/primait/nuvola/pkg/diff"
+	"github.com/primait/nuvola/tools/yamler"
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff old.zip new.zip",
+	Short: "Compare two dumps: changed IAM entities and resources, and the assessment findings appeared or disappeared",
+	Args:  cobra.ExactArgs(2),
+	Run:   runDiffCmd,
+}
+
+func runDiffCmd(cmd *cobra.Command, args []string) {
+	if cmd.Flags().Changed(flagVerbose) {
+		logger.SetVerboseLevel()
+	}
+	if cmd.Flags().Changed(flagDebug) {
+		logger.SetDebugLevel()
+	}
+	if format := strings.ToLower(diffFormat); format != "text" && format != "json" {
+		logger.Error("Unknown output format", "format", diffFormat)
+	}
+
+	report := diff.Compare(diff.Load(args[0]), diff.Load(args[1]))
+	rules := ruleFiles(rulePaths)
+	report.CompareFindings(ruleFindings(args[0], rules), ruleFindings(args[1], rules))
+
+	if strings.ToLower(diffFormat) == "json" {
+		fmt.Println(string(logger.PrettyJSON(report)))
+		return
+	}
+	printDiff(report)
+}
+
+// Import the dump in a memory store, without touching Neo4j, and collect the findings of the enabled rules.
+// A finding is identified by the Arn of the matched node (its Name without one) and the values of the Return keys:
+// matches without any of them keep all their properties but the snapshot markers
+func ruleFindings(zipfile string, rules []yamler.RuleFile) []diff.Finding {
+	storageConnector := connector.NewMemoryStorageConnector()
+	importZipFile(storageConnector, zipfile)
+
+	findings := []diff.Finding{}
+	for _, result := range assess(storageConnector, rules, false) {
+		if !result.Evaluated() {
+			logger.Warn("Rule not evaluated: its findings are not compared", "rule", result.Rule, "dump", zipfile)
+		}
+		for _, match := range result.Matches {
+			values := match.Values
+			if len(values) == 0 {
+				values = maps.Clone(match.Properties)
+				delete(values, "SnapshotId")
+				delete(values, "LastSeen")
+			}
+			id := match.Arn
+			if id == "" {
+				id = match.Name
+			}
+			findings = append(findings, diff.Finding{Rule: result.Rule, ID: id, Values: values})
+		}
+	}
+	return findings
+}
+
+func printDiff(report *diff.Report) {
+	printChanges("Added", report.Added)
+	printChanges("Removed", report.Removed)
+	printChanges("Modified", report.Modified)
+
+	logger.PrintRed(fmt.Sprintf("Findings appeared: %d", len(report.FindingsAppeared)))
+	for _, finding := range report.FindingsAppeared {
+		fmt.Printf("  %s: %s\n", finding.Rule, finding.String())
+	}
+	logger.PrintGreen(fmt.Sprintf("Findings disappeared: %d", len(report.FindingsDisappeared)))
+	for _, finding := range report.FindingsDisappeared {
+		fmt.Printf("  %s: %s\n", finding.Rule, finding.String())
+	}
+}
+
+func printChanges(title string, changes []diff.Change) {
+	logger.PrintGreen(fmt.Sprintf("%s: %d", title, len(changes)))
+	for _, change := range changes {
+		line := fmt.Sprintf("  %s %s", change.Kind, change.ID)
+		if change.Account != "" {
+			line += fmt.Sprintf(" (account %s)", change.Account)
+		}
+		if len(change.Fields) > 0 {
+			line += ": " + strings.Join(change.Fields, ", ")
+		}
+		fmt.Println(line)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+}
@@ -3,20 +3,26 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/primait/nuvola/pkg/connector"
 	"github.com/primait/nuvola/tools/filesystem/files"
 	"github.com/primait/nuvola/tools/filesystem/zip"
+	"github.com/sourcegraph/conc/pool"
 	"github.com/spf13/cobra"
 )
 
 var (
 	AWSResults = map[string]interface{}{
 		"Whoami":           nil,
+		"Catalog":          nil,
 		"CredentialReport": nil,
+		"Organization":     nil,
 		"Groups":           nil,
 		"Users":            nil,
 		"Roles":            nil,
@@ -51,11 +57,20 @@ func runDumpCmd(cmd *cobra.Command, args []string) {
 		return
 	}
 
+	if orgRole != "" {
+		dumpOrganization(cloudConnector)
+		logger.Info("Execution Time", "seconds", time.Since(startTime))
+		return
+	}
+
 	if dumpOnly {
 		dumpData(nil, cloudConnector)
 	} else {
-		storageConnector := connector.NewStorageConnector().FlushAll()
+		storageConnector := connector.NewStorageConnector().BeginImport(flush)
 		dumpData(storageConnector, cloudConnector)
+		storageConnector.TagAccount()
+		storageConnector.AddEffectivePermissions()
+		storageConnector.AddPrivilegeEscalations()
 	}
 
 	saveResults(awsProfile, outputDirectory, outputFormat)
@@ -69,6 +84,9 @@ func dumpData(storageConnector *connector.StorageConnector, cloudConnector *conn
 	go func() {
 		defer close(dataChan)
 		cloudConnector.DumpAll("aws", dataChan)
+		if organization := cloudConnector.DumpOrganization(); organization != nil {
+			dataChan <- map[string]interface{}{"Organization": organization}
+		}
 	}()
 
 	wg.Add(1)
@@ -81,6 +99,81 @@ func dumpData(storageConnector *connector.StorageConnector, cloudConnector *conn
 	wg.Wait()
 }
 
+// Dump all the accounts of the AWS Organization, with bounded concurrency, into a single dataset
+func dumpOrganization(cloudConnector *connector.CloudConnector) {
+	var (
+		mu       sync.Mutex
+		results  = make(map[string]map[string]interface{})
+		accounts = cloudConnector.NewOrganizationConnectors(orgRole)
+		p        = pool.New().WithMaxGoroutines(max(concurrency, 1))
+	)
+
+	for accountID, accountConnector := range accounts {
+		p.Go(func() {
+			logger.Info("Dumping account", "account", accountID)
+			accountResults := collectData(accountConnector)
+			mu.Lock()
+			results[accountID] = accountResults
+			mu.Unlock()
+		})
+	}
+	p.Wait()
+
+	// The organization is described once, by the management or delegated administrator account running the dump,
+	// and saved with the dump of that account
+	for accountID, accountConnector := range accounts {
+		if accountConnector != cloudConnector || results[accountID] == nil {
+			continue
+		}
+		if organization := cloudConnector.DumpOrganization(); organization != nil {
+			results[accountID]["Organization"] = organization
+		}
+	}
+
+	if !dumpOnly {
+		storageConnector := connector.NewStorageConnector().BeginImport(flush)
+		for _, accountID := range slices.Sorted(maps.Keys(results)) {
+			importResults(storageConnector, results[accountID])
+		}
+		storageConnector.AddEffectivePermissions()
+		storageConnector.AddPrivilegeEscalations()
+	}
+
+	saveAccountsResults(awsProfile, outputDirectory, outputFormat, results)
+}
+
+func collectData(cloudConnector *connector.CloudConnector) map[string]interface{} {
+	results := make(map[string]interface{})
+	dataChan := make(chan map[string]interface{})
+
+	go func() {
+		defer close(dataChan)
+		cloudConnector.DumpAll("aws", dataChan)
+	}()
+
+	for data := range dataChan {
+		for key, value := range data {
+			results[key] = value
+		}
+	}
+	return results
+}
+
+func importResults(storageConnector *connector.StorageConnector, results map[string]interface{}) {
+	for _, key := range importOrdering {
+		value, ok := results[key]
+		if !ok {
+			continue
+		}
+		obj, err := json.Marshal(value)
+		if err != nil {
+			logger.Error("importResults: error marshalling output", "err", err)
+		}
+		storageConnector.ImportResults(key, obj)
+	}
+	storageConnector.TagAccount()
+}
+
 func processData(storageConnector *connector.StorageConnector, data map[string]interface{}) {
 	if len(data) == 0 {
 		return
@@ -120,6 +213,25 @@ func saveResults(awsProfile, outputDir, outputFormat string) {
 	}
 }
 
+func saveAccountsResults(awsProfile, outputDir, outputFormat string, results map[string]map[string]interface{}) {
+	if awsProfile == "" {
+		awsProfile = "default"
+	}
+	if outputFormat == "zip" {
+		zip.ZipAccounts(outputDir, awsProfile, results)
+	}
+
+	today := time.Now().Format("20060102")
+	for accountID, values := range results {
+		for key, value := range values {
+			if outputFormat == "json" {
+				filename := fmt.Sprintf("%s_%s.json", key, today)
+				files.PrettyJSONToFile(filepath.Join(outputDir, accountID), filename, value)
+			}
+		}
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(dumpCmd)
 }
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/primait/nuvola/pkg/report"
+	"github.com/spf13/cobra"
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Save the assessment as an offline HTML report, with the graphs of the privilege escalation paths",
+	Run:   runReportCmd,
+}
+
+func runReportCmd(cmd *cobra.Command, args []string) {
+	if cmd.Flags().Changed(flagVerbose) {
+		logger.SetVerboseLevel()
+	}
+	if cmd.Flags().Changed(flagDebug) {
+		logger.SetDebugLevel()
+	}
+
+	checkRuleFilters()
+	results := assess(openBackend(), ruleFiles(rulePaths), true)
+	expired := applySuppressions(results)
+
+	f, err := os.Create(filepath.Clean(htmlReport))
+	if err != nil {
+		logger.Error("Error on creating output file", "err", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			logger.Error("Error closing file", "err", err)
+		}
+	}()
+	if err := report.WriteHTML(f, results, expired); err != nil {
+		logger.Error("Error on writing the report", "err", err)
+	}
+	logger.Info("Report saved", "file", htmlReport)
+	if rules := report.NotEvaluated(results); len(rules) > 0 {
+		logger.Warn("Some rules were not evaluated", "rules", rules)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+}
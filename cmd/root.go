@@ -15,6 +15,19 @@ const (
 	flagDumpOnly        = "dump-only"
 	flagImportFile      = "import"
 	flagNoImport        = "no-import"
+	flagOrgRole         = "org-role"
+	flagConcurrency     = "concurrency"
+	flagOutputFile      = "output"
+	flagBackend         = "backend"
+	flagFlush           = "flush"
+	flagHTML            = "html"
+	flagSeverity        = "severity"
+	flagTag             = "tag"
+	flagRules           = "rules"
+	flagRuleName        = "rule-name"
+	flagExclude         = "exclude"
+	flagSuppressions    = "suppressions"
+	flagFailOnFindings  = "fail-on-findings"
 )
 
 var (
@@ -26,6 +39,22 @@ var (
 	dumpOnly        bool
 	importFile      string
 	noImport        bool
+	orgRole         string
+	concurrency     int
+	backend         string
+	testBackend     string
+	flush           bool
+	assessFormat    string
+	assessOutput    string
+	diffFormat      string
+	htmlReport      string
+	minSeverity     string
+	ruleTags        []string
+	rulePaths       []string
+	suppressionFile string
+	ruleNames       []string
+	excludedRules   []string
+	failOnFindings  bool
 	rootCmd         = &cobra.Command{
 		Use:   "nuvola",
 		Short: "A tool to dump and perform automatic and manual security analysis on AWS",
@@ -41,11 +70,42 @@ func init() {
 	dumpCmd.Flags().StringVarP(&awsEndpointUrl, flagAWSEndpointUrl, "e", "", "AWS Endpoint to use (e.g. for Localstack)")
 	dumpCmd.Flags().StringVarP(&outputDirectory, flagOutputDirectory, "o", "", "Output folder where the files will be saved (default: \".\")")
 	dumpCmd.Flags().StringVarP(&outputFormat, flagOutputFormat, "f", "zip", "Output format: ZIP or json files")
+	dumpCmd.Flags().StringVarP(&orgRole, flagOrgRole, "", "", "Role to assume in every account of the AWS Organization (requires a management account profile)")
+	dumpCmd.Flags().IntVarP(&concurrency, flagConcurrency, "", 4, "Number of accounts dumped in parallel with --org-role")
+	dumpCmd.Flags().BoolVarP(&flush, flagFlush, "", false, "Delete all the data in Neo4j before loading the dump, instead of updating the dumped accounts")
 	// _ = dumpCmd.MarkFlagRequired(flagAWSProfile)
 
 	assessCmd.Flags().StringVarP(&importFile, flagImportFile, "i", "", "Input ZIP file to load")
 	assessCmd.Flags().BoolVarP(&noImport, flagNoImport, "", false, "Use stored data from Neo4j without import (default)")
+	assessCmd.Flags().BoolVarP(&flush, flagFlush, "", false, "Delete all the data in Neo4j before the import, instead of updating the imported accounts")
+	assessCmd.Flags().StringVarP(&backend, flagBackend, "b", "neo4j", "Graph storage: neo4j or memory (in process, requires --import)")
+	assessCmd.Flags().StringVarP(&assessFormat, flagOutputFormat, "f", "text", "Output format: text, json, sarif or junit")
+	assessCmd.Flags().StringVarP(&assessOutput, flagOutputFile, "o", "", "File where the json, sarif or junit output is saved (default: stdout)")
+	assessCmd.Flags().StringVarP(&minSeverity, flagSeverity, "", "", "Run only the rules with at least this severity: info, low, medium, high or critical")
+	assessCmd.Flags().StringSliceVarP(&ruleTags, flagTag, "", nil, "Run only the rules with one of these tags (repeatable)")
+	assessCmd.Flags().StringSliceVarP(&rulePaths, flagRules, "", nil, "Files or folders of the rules to run (repeatable, default: the predefined ruleset)")
+	assessCmd.Flags().StringSliceVarP(&ruleNames, flagRuleName, "", nil, "Run only the rules with these names, also if disabled: wildcards are allowed (repeatable)")
+	assessCmd.Flags().StringSliceVarP(&excludedRules, flagExclude, "", nil, "Skip the rules with these names: wildcards are allowed (repeatable)")
+	assessCmd.Flags().StringVarP(&suppressionFile, flagSuppressions, "", "", "YAML file of the accepted findings, reported apart from the others")
+	assessCmd.Flags().BoolVarP(&failOnFindings, flagFailOnFindings, "", false, "Exit with code 2 when the enabled rules have findings, to gate a pipeline")
 	assessCmd.MarkFlagsMutuallyExclusive(flagImportFile, flagNoImport)
+
+	reportCmd.Flags().StringVarP(&htmlReport, flagHTML, "", "", "HTML file where the report is saved")
+	reportCmd.Flags().StringVarP(&importFile, flagImportFile, "i", "", "Input ZIP file to load")
+	reportCmd.Flags().BoolVarP(&noImport, flagNoImport, "", false, "Use stored data from Neo4j without import (default)")
+	reportCmd.Flags().BoolVarP(&flush, flagFlush, "", false, "Delete all the data in Neo4j before the import, instead of updating the imported accounts")
+	reportCmd.Flags().StringVarP(&backend, flagBackend, "b", "neo4j", "Graph storage: neo4j or memory (in process, requires --import)")
+	reportCmd.Flags().StringVarP(&minSeverity, flagSeverity, "", "", "Report only the rules with at least this severity: info, low, medium, high or critical")
+	reportCmd.Flags().StringSliceVarP(&ruleTags, flagTag, "", nil, "Report only the rules with one of these tags (repeatable)")
+	reportCmd.Flags().StringSliceVarP(&rulePaths, flagRules, "", nil, "Files or folders of the rules to run (repeatable, default: the predefined ruleset)")
+	reportCmd.Flags().StringSliceVarP(&ruleNames, flagRuleName, "", nil, "Report only the rules with these names, also if disabled: wildcards are allowed (repeatable)")
+	reportCmd.Flags().StringSliceVarP(&excludedRules, flagExclude, "", nil, "Skip the rules with these names: wildcards are allowed (repeatable)")
+	reportCmd.Flags().StringVarP(&suppressionFile, flagSuppressions, "", "", "YAML file of the accepted findings, reported apart from the others")
+	reportCmd.MarkFlagsMutuallyExclusive(flagImportFile, flagNoImport)
+	_ = reportCmd.MarkFlagRequired(flagHTML)
+
+	diffCmd.Flags().StringVarP(&diffFormat, flagOutputFormat, "f", "text", "Output format: text or json")
+	diffCmd.Flags().StringSliceVarP(&rulePaths, flagRules, "", nil, "Files or folders of the rules compared (repeatable, default: the predefined ruleset)")
 }
 
 func Execute() {
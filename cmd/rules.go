@@ -0,0 +1,270 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/primait/nuvola/pkg/connector"
+	"github.com/primait/nuvola/pkg/connector/services/aws/catalog"
+	memory "github.com/primait/nuvola/pkg/connector/services/memory"
+	"github.com/primait/nuvola/tools/yamler"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rulesCmd = &cobra.Command{
+		Use:   "rules",
+		Short: "Manage the rules used by assess",
+	}
+	rulesValidateCmd = &cobra.Command{
+		Use:   "validate [path...]",
+		Short: "Check the rules in the files or folders (default: the predefined ruleset) and report every problem with its file and line",
+		Run:   runRulesValidateCmd,
+	}
+	rulesTestCmd = &cobra.Command{
+		Use:   "test [path...]",
+		Short: "Test the rules in the files or folders (default: the predefined ruleset) against their fixtures (rule.test.json) in a scratch in-memory store, and with --backend neo4j in Neo4j too",
+		Run:   runRulesTestCmd,
+	}
+)
+
+func runRulesValidateCmd(cmd *cobra.Command, args []string) {
+	if cmd.Flags().Changed(flagVerbose) {
+		logger.SetVerboseLevel()
+	}
+	if cmd.Flags().Changed(flagDebug) {
+		logger.SetDebugLevel()
+	}
+
+	isAction := catalogAction()
+	rules := ruleFiles(args)
+	var problems []yamler.Problem
+	for _, rule := range rules {
+		content, err := rule.Read()
+		if err != nil {
+			problems = append(problems, yamler.Problem{File: relativePath(rule.Path), Message: err.Error()})
+			continue
+		}
+		problems = append(problems, yamler.Validate(relativePath(rule.Path), content, isAction)...)
+	}
+	for _, problem := range problems {
+		fmt.Println(problem.String())
+	}
+	if len(problems) > 0 {
+		fmt.Printf("%d problems in %d rules\n", len(problems), len(rules))
+		os.Exit(1)
+	}
+	fmt.Printf("%d rules are valid\n", len(rules))
+}
+
+func runRulesTestCmd(cmd *cobra.Command, args []string) {
+	if cmd.Flags().Changed(flagVerbose) {
+		logger.SetVerboseLevel()
+	}
+	if cmd.Flags().Changed(flagDebug) {
+		logger.SetDebugLevel()
+	}
+
+	backends := []string{"memory"}
+	switch strings.ToLower(testBackend) {
+	case "memory":
+	case "neo4j":
+		logger.Warn("The fixtures are imported in Neo4j after deleting all its data: use a throwaway instance")
+		backends = append(backends, "neo4j")
+	default:
+		logger.Error("Unknown backend", "backend", testBackend)
+	}
+
+	var passed, failed, untested int
+	for _, rule := range ruleFiles(args) {
+		fixture, err := rule.Fixture()
+		if fixture == nil && err == nil {
+			logger.Debug("Rule without fixture", "rule", relativePath(rule.Path))
+			untested++
+			continue
+		}
+
+		c, confErr := rule.Conf()
+		if confErr != nil {
+			fmt.Printf("FAIL %s\n", relativePath(rule.Path))
+			for _, problem := range ruleProblems(rule, confErr) {
+				fmt.Printf("    %s\n", problem)
+			}
+			failed++
+			continue
+		}
+		if err != nil {
+			fmt.Printf("FAIL %s (%s): %v\n", c.Name, relativePath(rule.Path), err)
+			failed++
+			continue
+		}
+
+		ok := true
+		for _, backend := range backends {
+			// The in-memory store cannot run the query of a cypher rule: it is tested in Neo4j only, when requested
+			if c.Cypher != "" && backend == "memory" && len(backends) > 1 {
+				continue
+			}
+			name := fmt.Sprintf("%s (%s)", c.Name, relativePath(rule.Path))
+			if len(backends) > 1 {
+				name += " [" + backend + "]"
+			}
+			missing, unexpected, err := testRule(c, fixture, scratchStore(backend))
+			switch {
+			case err != nil:
+				fmt.Printf("FAIL %s: %v\n", name, err)
+				ok = false
+			case len(missing) > 0 || len(unexpected) > 0:
+				fmt.Printf("FAIL %s\n", name)
+				for _, m := range missing {
+					fmt.Printf("    - %s\n", m)
+				}
+				for _, m := range unexpected {
+					fmt.Printf("    + %s\n", m)
+				}
+				ok = false
+			default:
+				fmt.Printf("ok   %s\n", name)
+			}
+		}
+		if ok {
+			passed++
+		} else {
+			failed++
+		}
+	}
+
+	fmt.Printf("%d passed, %d failed, %d rules without fixture\n", passed, failed, untested)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// Whether a permission is in the catalog of AWS actions: the cached one, or the one embedded in the binary. It is
+// loaded once, when a rule is validated
+var catalogAction = sync.OnceValue(func() func(string) bool {
+	cachePath, err := catalog.CachePath()
+	if err != nil {
+		logger.Warn("Unable to locate the catalog cache", "err", err)
+	}
+	actions, err := catalog.Load(cachePath)
+	if err != nil {
+		logger.Warn("Using the embedded catalog", "err", err)
+	}
+	if actions == nil {
+		logger.Error("Unable to load the catalog of AWS actions")
+	}
+	return func(name string) bool {
+		_, ok := actions.Action(name)
+		return ok
+	}
+})
+
+// The problems of a malformed rule, as `nuvola rules validate` reports them: the error of the rule when the file
+// cannot be read
+func ruleProblems(rule yamler.RuleFile, err error) []string {
+	var problems []string
+	if content, readErr := rule.Read(); readErr == nil {
+		for _, problem := range yamler.Validate(relativePath(rule.Path), content, catalogAction()) {
+			problems = append(problems, problem.String())
+		}
+	}
+	if len(problems) == 0 {
+		problems = []string{err.Error()}
+	}
+	return problems
+}
+
+// An empty store for a fixture: a new in-memory one, or the Neo4j of the configuration with all its data deleted
+func scratchStore(backend string) *connector.StorageConnector {
+	if backend == "neo4j" {
+		return connector.NewStorageConnector().FlushAll()
+	}
+	return connector.NewMemoryStorageConnector()
+}
+
+// Import the fixture in the scratch store and evaluate the rule: the matches expected and not found, and the ones
+// found but not expected
+func testRule(c *yamler.Conf, fixture *yamler.Fixture, storageConnector *connector.StorageConnector) (missing []string, unexpected []string, err error) {
+	matches, err := fixtureMatches(c, fixture, storageConnector)
+	if err != nil {
+		if errors.Is(err, memory.ErrCypher) {
+			err = fmt.Errorf("%w: test it with --%s neo4j", err, flagBackend)
+		}
+		return nil, nil, err
+	}
+
+	for _, expected := range fixture.Expected {
+		if !slices.Contains(matches, expected) {
+			missing = append(missing, expected)
+		}
+	}
+	for _, match := range matches {
+		if !slices.Contains(fixture.Expected, match) {
+			unexpected = append(unexpected, match)
+		}
+	}
+	return missing, unexpected, nil
+}
+
+// The matches of the rule in the store with the fixture imported, by Arn (Name without one), sorted without
+// duplicates: the query of PrepareQuery runs in Neo4j, the rule is evaluated directly in memory
+func fixtureMatches(c *yamler.Conf, fixture *yamler.Fixture, storageConnector *connector.StorageConnector) ([]string, error) {
+	if err := importDump(storageConnector, fixture.Dump); err != nil {
+		return nil, err
+	}
+	if err := storageConnector.ImportGraph(fixture.Graph.Nodes, fixture.Graph.Relationships); err != nil {
+		return nil, fmt.Errorf("importing graph: %w", err)
+	}
+	storageConnector.AddPrivilegeEscalations()
+
+	query, arguments := yamler.PrepareQuery(c)
+	records, err := storageConnector.QueryRule(c, query, arguments)
+	if err != nil {
+		return nil, err
+	}
+	var matches []string
+	for _, properties := range records {
+		match := newMatch(c, properties)
+		matches = append(matches, match.String())
+	}
+	// Privilege escalation rules return a match for each path of a principal, rules without target every node of the
+	// paths: the same action can be allowed by many policies
+	slices.Sort(matches)
+	return slices.Compact(matches), nil
+}
+
+// Import the files of a dump in the same order of a ZIP file
+func importDump(storageConnector *connector.StorageConnector, dump map[string]json.RawMessage) error {
+	for name := range dump {
+		if !slices.ContainsFunc(importOrdering, func(prefix string) bool { return strings.HasPrefix(name, prefix) }) {
+			return fmt.Errorf("unknown dump file %q: expected one of %s", name, strings.Join(importOrdering, ", "))
+		}
+	}
+
+	storageConnector.BeginImport(false)
+	names := slices.Sorted(maps.Keys(dump))
+	for _, prefix := range importOrdering {
+		for _, name := range names {
+			if strings.HasPrefix(name, prefix) {
+				storageConnector.ImportResults(name, dump[name])
+			}
+		}
+	}
+	storageConnector.TagAccount()
+	storageConnector.AddEffectivePermissions()
+	return nil
+}
+
+func init() {
+	rulesTestCmd.Flags().StringVarP(&testBackend, flagBackend, "b", "memory", "Graph storage of the fixtures: memory, or neo4j to also run the queries in Neo4j (all its data is deleted: use a throwaway instance)")
+	rulesCmd.AddCommand(rulesTestCmd)
+	rulesCmd.AddCommand(rulesValidateCmd)
+	rootCmd.AddCommand(rulesCmd)
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/primait/nuvola/assets"
+	"github.com/primait/nuvola/pkg/connector"
+	"github.com/primait/nuvola/tools/yamler"
+	"github.com/spf13/viper"
+)
+
+// The Neo4j of the parity tests: all its data is deleted, so they run only when it is set to a throwaway instance
+const (
+	parityNeo4jURL  = "NUVOLA_TEST_NEO4J_URL"
+	parityNeo4jPass = "NUVOLA_TEST_NEO4J_PASS"
+)
+
+// The embedded rules with a fixture
+func fixtureRules(t *testing.T) map[*yamler.Conf]*yamler.Fixture {
+	t.Helper()
+	rules, err := yamler.EmbeddedRuleFiles(assets.Rules, assets.RulesRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixtures := make(map[*yamler.Conf]*yamler.Fixture)
+	for _, rule := range rules {
+		fixture, err := rule.Fixture()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fixture == nil {
+			continue
+		}
+		c, err := rule.Conf()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fixtures[c] = fixture
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no fixtures in the embedded ruleset")
+	}
+	return fixtures
+}
+
+func parityStore(t *testing.T) {
+	t.Helper()
+	url := os.Getenv(parityNeo4jURL)
+	if url == "" {
+		t.Skipf("set %s and %s to a throwaway Neo4j to compare the backends", parityNeo4jURL, parityNeo4jPass)
+	}
+	viper.Set("NEO4J_URL", url)
+	viper.Set("NEO4J_PASS", os.Getenv(parityNeo4jPass))
+}
+
+func TestRuleFixtures(t *testing.T) {
+	for c, fixture := range fixtureRules(t) {
+		t.Run(c.Name, func(t *testing.T) {
+			missing, unexpected, err := testRule(c, fixture, scratchStore("memory"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(missing) > 0 || len(unexpected) > 0 {
+				t.Errorf("missing %v, unexpected %v", missing, unexpected)
+			}
+		})
+	}
+}
+
+// The queries run by Neo4j and the rules evaluated in memory find the same matches in the fixtures
+func TestFixtureParity(t *testing.T) {
+	parityStore(t)
+	for c, fixture := range fixtureRules(t) {
+		t.Run(c.Name, func(t *testing.T) {
+			inMemory, err := fixtureMatches(c, fixture, scratchStore("memory"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			inNeo4j, err := fixtureMatches(c, fixture, scratchStore("neo4j"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(inMemory, inNeo4j) {
+				t.Errorf("memory %v, neo4j %v", inMemory, inNeo4j)
+			}
+		})
+	}
+}
+
+// The dump of the tests imported by both backends has the same matches for the enabled rules
+func TestDumpParity(t *testing.T) {
+	parityStore(t)
+	rules, err := yamler.EmbeddedRuleFiles(assets.Rules, assets.RulesRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings := func(storageConnector *connector.StorageConnector) map[string][]string {
+		importZipFile(storageConnector, "../assets/tests/nuvola-tests.zip")
+		byRule := make(map[string][]string)
+		for _, result := range assess(storageConnector, rules, false) {
+			if !result.Evaluated() {
+				continue
+			}
+			matches := []string{}
+			for _, match := range result.Matches {
+				matches = append(matches, match.String())
+			}
+			slices.Sort(matches)
+			byRule[result.Rule] = slices.Compact(matches)
+		}
+		return byRule
+	}
+	inMemory, inNeo4j := findings(scratchStore("memory")), findings(scratchStore("neo4j"))
+	for rule, matches := range inMemory {
+		if !slices.Equal(matches, inNeo4j[rule]) {
+			t.Errorf("%s: memory %v, neo4j %v", rule, matches, inNeo4j[rule])
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"log"
 
 	"github.com/primait/nuvola/cmd"
@@ -11,7 +12,8 @@ func main() {
 	viper.AddConfigPath(".")
 	viper.SetConfigName(".env")
 	viper.SetConfigType("env")
-	if err := viper.ReadInConfig(); err != nil {
+	// The .env file is only needed to connect to Neo4j: the binary can run from any directory
+	if err := viper.ReadInConfig(); err != nil && !errors.As(err, &viper.ConfigFileNotFoundError{}) {
 		log.Fatalln(err.Error())
 	}
 	cmd.Execute()
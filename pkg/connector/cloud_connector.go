@@ -4,7 +4,9 @@ import (
 	"errors"
 	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/primait/nuvola/pkg/connector/services/aws"
+	"github.com/primait/nuvola/pkg/connector/services/aws/sts"
 	"github.com/primait/nuvola/pkg/io/logging"
 )
 
@@ -19,6 +21,35 @@ func NewCloudConnector(profile string, endpointUrl string) (*CloudConnector, err
 	return cc, nil
 }
 
+// Returns a CloudConnector for each active account of the AWS Organization: the management account
+// is accessed with the current credentials while roleName is assumed in every member account
+func (cc *CloudConnector) NewOrganizationConnectors(roleName string) map[string]*CloudConnector {
+	connectors := make(map[string]*CloudConnector)
+	caller := sts.Whoami(cc.AWSConfig.Config)
+	currentAccount := aws.ToString(caller.Account)
+
+	for _, account := range cc.AWSConfig.ListOrganizationAccounts() {
+		accountID := aws.ToString(account.Id)
+		if accountID == currentAccount {
+			connectors[accountID] = cc
+			continue
+		}
+
+		// arn:<partition>:organizations::<management>:account/<org>/<account>
+		partition := strings.Split(aws.ToString(account.Arn), ":")[1]
+		member := &CloudConnector{
+			AWSConfig: cc.AWSConfig.AssumeRole(partition, accountID, roleName),
+			logger:    cc.logger,
+		}
+		if !member.testConnection("aws") {
+			cc.logger.Warn("Unable to assume role in account", "account", accountID, "role", roleName)
+			continue
+		}
+		connectors[accountID] = member
+	}
+	return connectors
+}
+
 func SetActions() {
 	awsconfig.SetActions()
 }
@@ -31,6 +62,7 @@ func (cc *CloudConnector) DumpAll(cloudprovider string, c chan map[string]interf
 			dump func() interface{}
 		}{
 			{"Whoami", cc.AWSConfig.DumpWhoami},
+			{"Catalog", cc.AWSConfig.DumpCatalog},
 			{"CredentialReport", cc.AWSConfig.DumpCredentialReport},
 			{"Groups", cc.AWSConfig.DumpIAMGroups},
 			{"Users", cc.AWSConfig.DumpIAMUsers},
@@ -57,6 +89,12 @@ func (cc *CloudConnector) DumpAll(cloudprovider string, c chan map[string]interf
 	}
 }
 
+// The AWS Organization, described with the credentials of the connector: only the management account or a delegated
+// administrator can list it, so it is not dumped with every account. It is nil when it cannot be described
+func (cc *CloudConnector) DumpOrganization() interface{} {
+	return cc.AWSConfig.DumpOrganization()
+}
+
 func (cc *CloudConnector) testConnection(cloudprovider string) bool {
 	switch strings.ToLower(cloudprovider) {
 	case "aws":
@@ -1,14 +1,66 @@
 package connector
 
 import (
+	"time"
+
 	awsconfig "github.com/primait/nuvola/pkg/connector/services/aws"
-	neo4jconnector "github.com/primait/nuvola/pkg/connector/services/neo4j"
+	"github.com/primait/nuvola/pkg/connector/services/aws/catalog"
+	servicesDatabase "github.com/primait/nuvola/pkg/connector/services/aws/database"
+	servicesEC2 "github.com/primait/nuvola/pkg/connector/services/aws/ec2"
+	servicesIAM "github.com/primait/nuvola/pkg/connector/services/aws/iam"
+	servicesLambda "github.com/primait/nuvola/pkg/connector/services/aws/lambda"
+	servicesOrganizations "github.com/primait/nuvola/pkg/connector/services/aws/organizations"
+	servicesS3 "github.com/primait/nuvola/pkg/connector/services/aws/s3"
+	"github.com/primait/nuvola/pkg/connector/services/graph"
 	"github.com/primait/nuvola/pkg/io/logging"
+	"github.com/primait/nuvola/tools/yamler"
 )
 
+// A graph database storing the dumped resources: Neo4j or the in-memory store
+type GraphStore interface {
+	DeleteAll()
+	CreateSchema()
+	SetSnapshot(snapshotID string, seen time.Time)
+	RemoveStale(accountID string) int
+	AddAccount(partition string, accountID string)
+	SetAccountID(accountID string)
+	SetCatalog(accountID string, info catalog.Info)
+	AddOrganization(org *servicesOrganizations.Organization)
+	AddUsers(users *[]servicesIAM.User)
+	AddGroups(groups *[]servicesIAM.Group)
+	AddRoles(roles *[]servicesIAM.Role)
+	AddLinksToResourcesIAM()
+	AddEffectivePermissions()
+	AddBuckets(buckets *[]servicesS3.Bucket)
+	AddEC2(instances *[]servicesEC2.Instance)
+	AddVPC(vpcs *servicesEC2.VPC)
+	AddLambda(lambdas *[]servicesLambda.Lambda)
+	AddRDS(rdsdbs *servicesDatabase.RDS)
+	AddDynamoDB(dynamodbs *[]servicesDatabase.DynamoDB)
+	AddRedshift(redshifts *[]servicesDatabase.RedshiftDB)
+	AddPrivescEdges()
+	Query(query string, arguments map[string]interface{}) ([]map[string]interface{}, error)
+	QueryPaths(query string, arguments map[string]interface{}) ([]graph.Path, error)
+	QueryRecords(query string, arguments map[string]interface{}) ([]map[string]interface{}, error)
+}
+
+// A store evaluating the rules without Cypher: the rules it cannot evaluate are errors
+type RuleEvaluator interface {
+	EvaluateRule(rule *yamler.Conf) ([]map[string]interface{}, error)
+	EvaluateRulePaths(rule *yamler.Conf) []graph.Path
+}
+
+// A store loading graph snippets directly, without a dump
+type GraphImporter interface {
+	AddGraph(nodes []graph.PathNode, relationships []graph.PathRelationship) error
+}
+
 type StorageConnector struct {
-	Client neo4jconnector.Neo4jClient
-	logger logging.LogManager
+	Client     GraphStore
+	accountID  string
+	snapshotID string
+	catalog    *catalog.Info
+	logger     logging.LogManager
 }
 
 type CloudConnector struct {
@@ -2,11 +2,14 @@ package awsconnector
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/primait/nuvola/pkg/connector/services/aws/catalog"
 	"github.com/primait/nuvola/pkg/connector/services/aws/database"
 	"github.com/primait/nuvola/pkg/connector/services/aws/ec2"
 	"github.com/primait/nuvola/pkg/connector/services/aws/iam"
 	"github.com/primait/nuvola/pkg/connector/services/aws/lambda"
+	"github.com/primait/nuvola/pkg/connector/services/aws/organizations"
 	"github.com/primait/nuvola/pkg/connector/services/aws/s3"
 	"github.com/primait/nuvola/pkg/connector/services/aws/sts"
 	"github.com/primait/nuvola/pkg/io/logging"
@@ -14,11 +17,15 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	orgtypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	awssts "github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
 var (
 	ActionsMap   map[string][]string
 	ActionsList  []string // len(unique(ActionList)) ~= 13k
+	Catalog      *catalog.Catalog
 	Conditions   map[string]string
 	countRetries = 100
 )
@@ -48,15 +55,42 @@ func (ac *AWSConfig) TestConnection() bool {
 	return err == nil
 }
 
+// Returns a copy of the configuration that uses the credentials of the role assumed in another account
+func (ac *AWSConfig) AssumeRole(partition string, accountID string, roleName string) *AWSConfig {
+	cfg := ac.Config.Copy()
+	roleArn := fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, accountID, roleName)
+	provider := stscreds.NewAssumeRoleProvider(awssts.NewFromConfig(ac.Config), roleArn, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = "nuvola"
+	})
+	cfg.Credentials = aws.NewCredentialsCache(provider)
+	return &AWSConfig{Profile: fmt.Sprintf("%s_%s", ac.Profile, accountID), Config: cfg, logger: ac.logger}
+}
+
+func (ac *AWSConfig) ListOrganizationAccounts() []orgtypes.Account {
+	return organizations.ListActiveAccounts(ac.Config)
+}
+
 func (ac *AWSConfig) DumpWhoami() interface{} {
 	return sts.Whoami(ac.Config)
 }
 
+func (ac *AWSConfig) DumpCatalog() interface{} {
+	return Catalog.Info()
+}
+
 func (ac *AWSConfig) DumpCredentialReport() interface{} {
 	report := iam.GetCredentialReport(ac.Config)
 	return report
 }
 
+func (ac *AWSConfig) DumpOrganization() interface{} {
+	org := organizations.ListOrganization(ac.Config)
+	if org == nil {
+		return nil
+	}
+	return org
+}
+
 func (ac *AWSConfig) DumpIAMGroups() interface{} {
 	groups := iam.ListGroups(ac.Config)
 	return groups
@@ -115,6 +149,7 @@ func (ac *AWSConfig) DumpAll() map[string]interface{} {
 	// The order is important!
 	return map[string]interface{}{
 		"Whoami":           ac.DumpWhoami(),
+		"Catalog":          ac.DumpCatalog(),
 		"CredentialReport": ac.DumpCredentialReport(),
 		"Groups":           ac.DumpIAMGroups(),
 		"Users":            ac.DumpIAMUsers(),
@@ -0,0 +1,205 @@
+package catalog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// The catalog shipped with the binary: regenerate it with `make catalog` before a release
+//
+//go:embed catalog.json.gz
+var embedded []byte
+
+// Actions of every AWS service with the resource types and condition keys they support
+type Catalog struct {
+	// Digest of the services: the same set of actions always has the same version
+	Version  string             `json:"Version"`
+	Updated  string             `json:"Updated"`
+	Source   string             `json:"Source"`
+	Services map[string]Service `json:"Services"`
+}
+
+type Service struct {
+	Actions map[string]Action `json:"Actions"`
+}
+
+type Action struct {
+	ResourceTypes []string `json:"ResourceTypes,omitempty"`
+	ConditionKeys []string `json:"ConditionKeys,omitempty"`
+}
+
+// What is stored in the dump to know which catalog expanded the actions of the policies
+type Info struct {
+	Version string `json:"Version"`
+	Updated string `json:"Updated"`
+	Source  string `json:"Source"`
+}
+
+func New(source, updated string, services map[string]Service) *Catalog {
+	c := &Catalog{Updated: updated, Source: source, Services: services}
+	c.Version = c.digest()
+	return c
+}
+
+func (c *Catalog) digest() string {
+	content, _ := json.Marshal(c.Services) // map keys are sorted: the output is stable
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+func (c *Catalog) Info() Info {
+	return Info{Version: c.Version, Updated: c.Updated, Source: c.Source}
+}
+
+// All the actions in the "service:Action" form, sorted
+func (c *Catalog) Actions() []string {
+	actions := make([]string, 0)
+	for service, definition := range c.Services {
+		for action := range definition.Actions {
+			actions = append(actions, service+":"+action)
+		}
+	}
+	slices.Sort(actions)
+	return actions
+}
+
+// The actions of each service (e.g. "s3" => ["GetObject", ...]), sorted
+func (c *Catalog) ActionsByService() map[string][]string {
+	actions := make(map[string][]string, len(c.Services))
+	for service, definition := range c.Services {
+		for action := range definition.Actions {
+			actions[service] = append(actions[service], action)
+		}
+		slices.Sort(actions[service])
+	}
+	return actions
+}
+
+// Lookup of an action in the "service:Action" form: the match is case insensitive like in the policies
+func (c *Catalog) Action(name string) (Action, bool) {
+	service, action, _ := strings.Cut(name, ":")
+	definition, ok := c.Services[strings.ToLower(service)]
+	if !ok {
+		return Action{}, false
+	}
+	for key, value := range definition.Actions {
+		if strings.EqualFold(key, action) {
+			return value, true
+		}
+	}
+	return Action{}, false
+}
+
+// Whether the actions come with their resource types and condition keys: the catalogs scraped from policies.js
+// list only the action names, so the resources and the conditions of the policies cannot be checked against them
+func (c *Catalog) Detailed() bool {
+	for _, definition := range c.Services {
+		for _, action := range definition.Actions {
+			if len(action.ResourceTypes) > 0 || len(action.ConditionKeys) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Default location of the catalog refreshed with `nuvola catalog update`
+func CachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "nuvola", "catalog.json"), nil
+}
+
+func Embedded() (*Catalog, error) {
+	return decode(embedded, true)
+}
+
+// Load the catalog from the cache file, falling back to the embedded one when there is no cache: an
+// unreadable cache is reported with the error but the embedded catalog is returned anyway
+func Load(path string) (*Catalog, error) {
+	builtin, err := Embedded()
+	if err != nil {
+		return nil, fmt.Errorf("decoding embedded catalog: %w", err)
+	}
+	if path == "" {
+		return builtin, nil
+	}
+
+	content, err := os.ReadFile(filepath.Clean(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return builtin, nil
+	}
+	if err != nil {
+		return builtin, fmt.Errorf("reading catalog %s: %w", path, err)
+	}
+	cached, err := decode(content, strings.HasSuffix(path, ".gz"))
+	if err != nil {
+		return builtin, fmt.Errorf("decoding catalog %s: %w", path, err)
+	}
+	return cached, nil
+}
+
+// Write the catalog to path, compressed if the name ends with .gz
+func (c *Catalog) Save(path string) error {
+	content, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("encoding catalog: %w", err)
+	}
+	if strings.HasSuffix(path, ".gz") {
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(content); err != nil {
+			return fmt.Errorf("compressing catalog: %w", err)
+		}
+		if err := writer.Close(); err != nil {
+			return fmt.Errorf("compressing catalog: %w", err)
+		}
+		content = buf.Bytes()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("creating catalog folder: %w", err)
+	}
+	// Replace the file only once it is complete to never leave a truncated cache
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		return fmt.Errorf("writing catalog: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func decode(content []byte, compressed bool) (*Catalog, error) {
+	if compressed {
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		content, err = io.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var c Catalog
+	if err := json.Unmarshal(content, &c); err != nil {
+		return nil, err
+	}
+	if len(c.Services) == 0 {
+		return nil, errors.New("catalog without services")
+	}
+	return &c, nil
+}
@@ -0,0 +1,99 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	req "github.com/imroc/req/v3"
+	"github.com/sourcegraph/conc/pool"
+)
+
+// Machine readable version of the Service Authorization Reference
+const ServiceReferenceURL = "https://servicereference.us-east-1.amazonaws.com/"
+
+type serviceReferenceEntry struct {
+	Service string `json:"service"`
+	URL     string `json:"url"`
+}
+
+type serviceReference struct {
+	Name    string `json:"Name"`
+	Actions []struct {
+		Name                string   `json:"Name"`
+		ActionConditionKeys []string `json:"ActionConditionKeys"`
+		Resources           []struct {
+			Name string `json:"Name"`
+		} `json:"Resources"`
+	} `json:"Actions"`
+	Resources []struct {
+		Name          string   `json:"Name"`
+		ConditionKeys []string `json:"ConditionKeys"`
+	} `json:"Resources"`
+}
+
+// Download the definition of every service from the AWS service reference: nothing is returned unless all the
+// services are fetched, to never replace a complete catalog with a partial one
+func Update(ctx context.Context) (*Catalog, error) {
+	client := req.C().SetTimeout(30 * time.Second)
+
+	var entries []serviceReferenceEntry
+	response, err := client.R().SetContext(ctx).SetSuccessResult(&entries).Get(ServiceReferenceURL)
+	if err != nil {
+		return nil, fmt.Errorf("listing services: %w", err)
+	}
+	if !response.IsSuccessState() {
+		return nil, fmt.Errorf("listing services: %s", response.Status)
+	}
+
+	var mu sync.Mutex
+	services := make(map[string]Service, len(entries))
+	p := pool.New().WithErrors().WithContext(ctx).WithMaxGoroutines(16).WithCancelOnError()
+	for _, entry := range entries {
+		p.Go(func(ctx context.Context) error {
+			var reference serviceReference
+			response, err := client.R().SetContext(ctx).SetSuccessResult(&reference).Get(entry.URL)
+			if err != nil {
+				return fmt.Errorf("fetching service %s: %w", entry.Service, err)
+			}
+			if !response.IsSuccessState() {
+				return fmt.Errorf("fetching service %s: %s", entry.Service, response.Status)
+			}
+
+			mu.Lock()
+			services[strings.ToLower(entry.Service)] = reference.toService()
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := p.Wait(); err != nil {
+		return nil, err
+	}
+
+	return New(ServiceReferenceURL, time.Now().UTC().Format("2006-01-02"), services), nil
+}
+
+// The condition keys of an action are its own plus the ones of the resource types it can target
+func (sr *serviceReference) toService() Service {
+	resourceKeys := make(map[string][]string, len(sr.Resources))
+	for _, resource := range sr.Resources {
+		resourceKeys[resource.Name] = resource.ConditionKeys
+	}
+
+	service := Service{Actions: make(map[string]Action, len(sr.Actions))}
+	for _, action := range sr.Actions {
+		definition := Action{ConditionKeys: slices.Clone(action.ActionConditionKeys)}
+		for _, resource := range action.Resources {
+			definition.ResourceTypes = append(definition.ResourceTypes, resource.Name)
+			definition.ConditionKeys = append(definition.ConditionKeys, resourceKeys[resource.Name]...)
+		}
+		slices.Sort(definition.ResourceTypes)
+		slices.Sort(definition.ConditionKeys)
+		definition.ConditionKeys = slices.Compact(definition.ConditionKeys)
+		service.Actions[action.Name] = definition
+	}
+	return service
+}
@@ -0,0 +1,147 @@
+package iam
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// A Resource or NotResource element of a statement
+type ResourceMatcher struct {
+	Patterns    []string
+	NotResource bool
+	// Values of the policy variables (e.g. aws:username) lowercased; unknown variables match any value
+	Variables map[string]string
+}
+
+// Policy variables resolvable for a principal: used only for policies that are not shared between principals
+func PrincipalVariables(name, id string, tags []types.Tag) map[string]string {
+	variables := map[string]string{
+		"aws:username": name,
+		"aws:userid":   id,
+	}
+	for _, tag := range tags {
+		variables["aws:principaltag/"+strings.ToLower(aws.ToString(tag.Key))] = aws.ToString(tag.Value)
+	}
+	return variables
+}
+
+func (rm *ResourceMatcher) Match(arn string) bool {
+	for _, pattern := range rm.Patterns {
+		if MatchARN(pattern, arn, rm.Variables) {
+			return !rm.NotResource
+		}
+	}
+	return rm.NotResource
+}
+
+// Match an ARN with a Resource pattern: the partition, service, region and account segments are matched one by
+// one while wildcards in the resource segment can also span ":" and "/"
+func MatchARN(pattern string, arn string, variables map[string]string) bool {
+	if pattern == "*" {
+		return true
+	}
+
+	patternSegments := splitPattern(pattern)
+	arnSegments := strings.SplitN(arn, ":", 6)
+	if len(arnSegments) < len(patternSegments) {
+		return false
+	}
+	last := len(patternSegments) - 1
+	for i := 0; i < last; i++ {
+		if !globMatch(resolveVariables(patternSegments[i], variables), arnSegments[i]) {
+			return false
+		}
+	}
+	// A pattern with less segments matches the remaining segments with its last one (e.g. arn:aws:s3:*)
+	return globMatch(resolveVariables(patternSegments[last], variables), strings.Join(arnSegments[last:], ":"))
+}
+
+// Split a pattern in the 6 segments of an ARN ignoring the ":" inside policy variables
+func splitPattern(pattern string) (segments []string) {
+	var depth, start int
+	for i := 0; i < len(pattern) && len(segments) < 5; i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "${"):
+			depth++
+		case pattern[i] == '}' && depth > 0:
+			depth--
+		case pattern[i] == ':' && depth == 0:
+			segments = append(segments, pattern[start:i])
+			start = i + 1
+		}
+	}
+	return append(segments, pattern[start:])
+}
+
+// Replace ${...} policy variables: unknown ones become a wildcard, ${*}, ${?} and ${$} are literal characters
+func resolveVariables(pattern string, variables map[string]string) string {
+	var resolved strings.Builder
+	for {
+		start := strings.Index(pattern, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(pattern[start:], "}")
+		if end < 0 {
+			break
+		}
+		resolved.WriteString(pattern[:start])
+
+		variable := pattern[start+2 : start+end]
+		// ${aws:PrincipalTag/team, 'default'} carries a default value
+		name, defaultValue, hasDefault := strings.Cut(variable, ",")
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "*" || name == "?" || name == "$":
+			resolved.WriteString(`\` + name)
+		case variables[strings.ToLower(name)] != "":
+			resolved.WriteString(escapeGlob(variables[strings.ToLower(name)]))
+		case hasDefault:
+			resolved.WriteString(escapeGlob(strings.Trim(strings.TrimSpace(defaultValue), "'")))
+		default:
+			resolved.WriteString("*")
+		}
+		pattern = pattern[start+end+1:]
+	}
+	resolved.WriteString(pattern)
+	return resolved.String()
+}
+
+func escapeGlob(value string) string {
+	return strings.NewReplacer(`*`, `\*`, `?`, `\?`).Replace(value)
+}
+
+// Case sensitive wildcard matching: "*" matches any sequence of characters, "?" a single character and a
+// backslash escapes the following character
+func globMatch(pattern, value string) bool {
+	var p, v int
+	var starP, starV = -1, 0
+	for v < len(value) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			starP, starV = p, v
+			p++
+		case p < len(pattern) && pattern[p] == '?':
+			p++
+			v++
+		case p+1 < len(pattern) && pattern[p] == '\\' && pattern[p+1] == value[v]:
+			p += 2
+			v++
+		case p < len(pattern) && pattern[p] != '\\' && pattern[p] == value[v]:
+			p++
+			v++
+		case starP >= 0:
+			p = starP + 1
+			starV++
+			v = starV
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
@@ -0,0 +1,119 @@
+package iam
+
+import "testing"
+
+func TestMatchARN(t *testing.T) {
+	tests := []struct {
+		name      string
+		pattern   string
+		arn       string
+		variables map[string]string
+		want      bool
+	}{
+		{"any resource", "*", "arn:aws:s3:::bucket", nil, true},
+		{"exact", "arn:aws:iam::123456789012:role/admin", "arn:aws:iam::123456789012:role/admin", nil, true},
+		{"case sensitive", "arn:aws:iam::123456789012:role/Admin", "arn:aws:iam::123456789012:role/admin", nil, false},
+		{"star spans slashes in the resource", "arn:aws:s3:::bucket/*", "arn:aws:s3:::bucket/a/b.txt", nil, true},
+		{"star spans colons in the resource", "arn:aws:logs:eu-west-1:123456789012:log-group:*", "arn:aws:logs:eu-west-1:123456789012:log-group:app:log-stream:web", nil, true},
+		{"question mark is one character", "arn:aws:s3:::bucket/?", "arn:aws:s3:::bucket/a", nil, true},
+		{"question mark is not two characters", "arn:aws:s3:::bucket/?", "arn:aws:s3:::bucket/ab", nil, false},
+		{"partition wildcard", "arn:*:iam::123456789012:role/admin", "arn:aws-cn:iam::123456789012:role/admin", nil, true},
+		{"region wildcard", "arn:aws:ec2:eu-*:123456789012:instance/*", "arn:aws:ec2:eu-west-1:123456789012:instance/i-0123", nil, true},
+		{"region wildcard of another region", "arn:aws:ec2:eu-*:123456789012:instance/*", "arn:aws:ec2:us-east-1:123456789012:instance/i-0123", nil, false},
+		{"account wildcard", "arn:aws:iam::*:role/admin", "arn:aws:iam::123456789012:role/admin", nil, true},
+		{"account prefix", "arn:aws:iam::1234*:role/admin", "arn:aws:iam::123456789012:role/admin", nil, true},
+		{"account of another prefix", "arn:aws:iam::999*:role/admin", "arn:aws:iam::123456789012:role/admin", nil, false},
+		{"account question mark", "arn:aws:iam::12345678901?:role/admin", "arn:aws:iam::123456789012:role/admin", nil, true},
+		{"star does not span the region and account", "arn:aws:iam:*:role/admin", "arn:aws:iam::123456789012:role/admin", nil, false},
+		{"less segments match the rest", "arn:aws:s3:*", "arn:aws:s3:::bucket/key", nil, true},
+		{"less segments of another service", "arn:aws:s3:*", "arn:aws:iam::123456789012:role/admin", nil, false},
+		{"arn with less segments", "arn:aws:s3:::bucket", "arn:aws:s3", nil, false},
+		{"username", "arn:aws:iam::123456789012:user/${aws:username}", "arn:aws:iam::123456789012:user/alice", map[string]string{"aws:username": "alice"}, true},
+		{"username of another user", "arn:aws:iam::123456789012:user/${aws:username}", "arn:aws:iam::123456789012:user/bob", map[string]string{"aws:username": "alice"}, false},
+		{"principal tag", "arn:aws:s3:::${aws:PrincipalTag/team}-*", "arn:aws:s3:::data-raw", map[string]string{"aws:principaltag/team": "data"}, true},
+		{"principal tag of another team", "arn:aws:s3:::${aws:PrincipalTag/team}-*", "arn:aws:s3:::ops-raw", map[string]string{"aws:principaltag/team": "data"}, false},
+		{"unknown variable matches any value", "arn:aws:s3:::${aws:PrincipalTag/team}/*", "arn:aws:s3:::ops/key", nil, true},
+		{"default of a missing variable", "arn:aws:s3:::${aws:PrincipalTag/team, 'shared'}", "arn:aws:s3:::shared", nil, true},
+		{"default is not a wildcard", "arn:aws:s3:::${aws:PrincipalTag/team, 'shared'}", "arn:aws:s3:::other", nil, false},
+		{"variable value is literal", "arn:aws:iam::123456789012:user/${aws:username}", "arn:aws:iam::123456789012:user/abc", map[string]string{"aws:username": "a*"}, false},
+		{"literal star", "arn:aws:s3:::bucket${*}", "arn:aws:s3:::bucket*", nil, true},
+		{"literal star is not a wildcard", "arn:aws:s3:::bucket${*}", "arn:aws:s3:::bucket1", nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := MatchARN(test.pattern, test.arn, test.variables); got != test.want {
+				t.Errorf("MatchARN(%q, %q) = %v, want %v", test.pattern, test.arn, got, test.want)
+			}
+		})
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"", "", true},
+		{"*", "", true},
+		{"**", "abc", true},
+		{"a*b", "axxb", true},
+		{"a*b", "axxc", false},
+		{"*a*", "bab", true},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{`a\?`, "a?", true},
+		{"abc", "ABC", false},
+	}
+	for _, test := range tests {
+		if got := globMatch(test.pattern, test.value); got != test.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", test.pattern, test.value, got, test.want)
+		}
+	}
+}
+
+func TestResolveVariables(t *testing.T) {
+	variables := map[string]string{"aws:username": "alice", "aws:principaltag/team": "a?"}
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"user/${aws:username}", "user/alice"},
+		{"user/${AWS:UserName}", "user/alice"},
+		{"${aws:PrincipalTag/team}/*", `a\?/*`},
+		{"user/${aws:PrincipalTag/project}", "user/*"},
+		{"user/${aws:PrincipalTag/project, 'shared'}", "user/shared"},
+		{"user/${aws:username, 'shared'}", "user/alice"},
+		{"${*}${?}${$}", `\*\?\$`},
+		{"user/${aws:username", "user/${aws:username"},
+	}
+	for _, test := range tests {
+		if got := resolveVariables(test.pattern, variables); got != test.want {
+			t.Errorf("resolveVariables(%q) = %q, want %q", test.pattern, got, test.want)
+		}
+	}
+}
+
+func TestResourceMatcher(t *testing.T) {
+	tests := []struct {
+		name        string
+		notResource bool
+		arn         string
+		want        bool
+	}{
+		{"Resource listed", false, "arn:aws:s3:::logs/2024/app.log", true},
+		{"Resource not listed", false, "arn:aws:s3:::data/report.csv", false},
+		{"NotResource listed", true, "arn:aws:s3:::logs/2024/app.log", false},
+		{"NotResource not listed", true, "arn:aws:s3:::data/report.csv", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matcher := ResourceMatcher{Patterns: []string{"arn:aws:s3:::logs/*", "arn:aws:s3:::audit"}, NotResource: test.notResource}
+			if got := matcher.Match(test.arn); got != test.want {
+				t.Errorf("Match(%q) = %v, want %v", test.arn, got, test.want)
+			}
+		})
+	}
+}
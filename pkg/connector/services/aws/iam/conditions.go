@@ -0,0 +1,176 @@
+package iam
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Static classification of a Condition block, evaluated without any request context
+type ConditionResult string
+
+const (
+	ConditionAlwaysTrue       ConditionResult = "always-true"
+	ConditionAlwaysFalse      ConditionResult = "always-false"
+	ConditionContextDependent ConditionResult = "context-dependent"
+)
+
+type ConditionEvaluation struct {
+	Result ConditionResult
+	Keys   []string
+}
+
+func (ce ConditionEvaluation) Conditional() bool {
+	return ce.Result != ConditionAlwaysTrue
+}
+
+// Keys available in the context of every request
+var alwaysPresentKeys = map[string]bool{
+	"aws:currenttime":      true,
+	"aws:epochtime":        true,
+	"aws:principalarn":     true,
+	"aws:principalaccount": true,
+	"aws:principaltype":    true,
+	"aws:securetransport":  true,
+	"aws:userid":           true,
+}
+
+// A statement applies only when all its conditions match: one always-false condition is enough to never
+// apply it, while a single context-dependent condition makes the whole statement context-dependent
+func EvaluateCondition(condition interface{}) ConditionEvaluation {
+	return evaluateConditionAt(condition, time.Now())
+}
+
+func evaluateConditionAt(condition interface{}, now time.Time) ConditionEvaluation {
+	evaluation := ConditionEvaluation{Result: ConditionAlwaysTrue, Keys: []string{}}
+	operators, ok := condition.(map[string]interface{})
+	if !ok {
+		return evaluation
+	}
+
+	uniqueKeys := make(map[string]bool)
+	for operator, keys := range operators {
+		keysMap, ok := keys.(map[string]interface{})
+		if !ok {
+			evaluation.Result = ConditionContextDependent
+			continue
+		}
+		for key, values := range keysMap {
+			uniqueKeys[key] = true
+			switch evaluateConditionKey(operator, key, conditionValues(values), now) {
+			case ConditionAlwaysFalse:
+				evaluation.Result = ConditionAlwaysFalse
+			case ConditionContextDependent:
+				if evaluation.Result == ConditionAlwaysTrue {
+					evaluation.Result = ConditionContextDependent
+				}
+			}
+		}
+	}
+
+	for key := range uniqueKeys {
+		evaluation.Keys = append(evaluation.Keys, key)
+	}
+	sort.Strings(evaluation.Keys)
+	return evaluation
+}
+
+func conditionValues(values interface{}) (list []string) {
+	switch v := values.(type) {
+	case []interface{}:
+		for _, value := range v {
+			list = append(list, fmt.Sprint(value))
+		}
+	case []string:
+		list = append(list, v...)
+	default:
+		list = append(list, fmt.Sprint(v))
+	}
+	return
+}
+
+// Only the cases that do not depend on the request are resolved: wildcards, Null checks on keys always
+// present, the request time compared with dates already passed and IP ranges covering every address
+func evaluateConditionKey(operator, key string, values []string, now time.Time) ConditionResult {
+	// ForAllValues is true also when the key is missing from the request
+	forAllValues := strings.HasPrefix(operator, "ForAllValues:")
+	operator = strings.TrimPrefix(strings.TrimPrefix(operator, "ForAllValues:"), "ForAnyValue:")
+	ifExists := strings.HasSuffix(operator, "IfExists")
+	operator = strings.TrimSuffix(operator, "IfExists")
+	present := alwaysPresentKeys[strings.ToLower(key)]
+
+	switch operator {
+	case "Null":
+		if !present || len(values) != 1 {
+			return ConditionContextDependent
+		}
+		if strings.EqualFold(values[0], "false") {
+			return ConditionAlwaysTrue
+		}
+		return ConditionAlwaysFalse
+	case "StringLike", "ArnLike":
+		if slices.Contains(values, "*") && (present || ifExists || forAllValues) {
+			return ConditionAlwaysTrue
+		}
+	case "StringNotLike", "ArnNotLike":
+		if slices.Contains(values, "*") && present {
+			return ConditionAlwaysFalse
+		}
+	case "IpAddress":
+		if slices.Contains(values, "0.0.0.0/0") && slices.Contains(values, "::/0") && ifExists {
+			return ConditionAlwaysTrue
+		}
+	case "NotIpAddress":
+		if slices.Contains(values, "0.0.0.0/0") && slices.Contains(values, "::/0") && !ifExists {
+			return ConditionAlwaysFalse
+		}
+	case "DateLessThan", "DateLessThanEquals", "DateGreaterThan", "DateGreaterThanEquals":
+		if strings.EqualFold(key, "aws:CurrentTime") || strings.EqualFold(key, "aws:EpochTime") {
+			return evaluateRequestTime(operator, values, now)
+		}
+	}
+	return ConditionContextDependent
+}
+
+// The request time only grows: a date in the past decides the condition forever, one in the future does not
+func evaluateRequestTime(operator string, values []string, now time.Time) ConditionResult {
+	var allPast = true
+	var anyPast = false
+	for _, value := range values {
+		date, err := parseConditionDate(value)
+		if err != nil {
+			return ConditionContextDependent
+		}
+		if date.Before(now) {
+			anyPast = true
+		} else {
+			allPast = false
+		}
+	}
+
+	// Multiple values are evaluated with a logical OR
+	switch operator {
+	case "DateLessThan", "DateLessThanEquals":
+		if allPast {
+			return ConditionAlwaysFalse
+		}
+	case "DateGreaterThan", "DateGreaterThanEquals":
+		if anyPast {
+			return ConditionAlwaysTrue
+		}
+	}
+	return ConditionContextDependent
+}
+
+func parseConditionDate(value string) (time.Time, error) {
+	if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(epoch, 0), nil
+	}
+	if date, err := time.Parse(time.RFC3339, value); err == nil {
+		return date, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
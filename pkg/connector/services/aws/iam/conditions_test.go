@@ -0,0 +1,86 @@
+package iam
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEvaluateCondition(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		condition interface{}
+		want      ConditionResult
+		keys      []string
+	}{
+		{"no condition", nil, ConditionAlwaysTrue, []string{}},
+		{"Null false of a key always present", map[string]interface{}{
+			"Null": map[string]interface{}{"aws:SecureTransport": "false"},
+		}, ConditionAlwaysTrue, []string{"aws:SecureTransport"}},
+		{"Null true of a key always present", map[string]interface{}{
+			"Null": map[string]interface{}{"aws:SecureTransport": "true"},
+		}, ConditionAlwaysFalse, []string{"aws:SecureTransport"}},
+		{"Null of a key of some requests", map[string]interface{}{
+			"Null": map[string]interface{}{"aws:MultiFactorAuthPresent": "true"},
+		}, ConditionContextDependent, []string{"aws:MultiFactorAuthPresent"}},
+		{"IpAddressIfExists of every address", map[string]interface{}{
+			"IpAddressIfExists": map[string]interface{}{"aws:SourceIp": []interface{}{"0.0.0.0/0", "::/0"}},
+		}, ConditionAlwaysTrue, []string{"aws:SourceIp"}},
+		{"IpAddress of every address, without the requests of AWS services", map[string]interface{}{
+			"IpAddress": map[string]interface{}{"aws:SourceIp": []interface{}{"0.0.0.0/0", "::/0"}},
+		}, ConditionContextDependent, []string{"aws:SourceIp"}},
+		{"IpAddress of a network", map[string]interface{}{
+			"IpAddress": map[string]interface{}{"aws:SourceIp": "10.0.0.0/8"},
+		}, ConditionContextDependent, []string{"aws:SourceIp"}},
+		{"NotIpAddress of every address", map[string]interface{}{
+			"NotIpAddress": map[string]interface{}{"aws:SourceIp": []interface{}{"0.0.0.0/0", "::/0"}},
+		}, ConditionAlwaysFalse, []string{"aws:SourceIp"}},
+		{"DateLessThan a past date", map[string]interface{}{
+			"DateLessThan": map[string]interface{}{"aws:CurrentTime": "2020-01-01T00:00:00Z"},
+		}, ConditionAlwaysFalse, []string{"aws:CurrentTime"}},
+		{"DateLessThan a future date", map[string]interface{}{
+			"DateLessThan": map[string]interface{}{"aws:CurrentTime": "2030-01-01T00:00:00Z"},
+		}, ConditionContextDependent, []string{"aws:CurrentTime"}},
+		{"DateLessThan a past or a future date", map[string]interface{}{
+			"DateLessThan": map[string]interface{}{"aws:CurrentTime": []interface{}{"2020-01-01T00:00:00Z", "2030-01-01T00:00:00Z"}},
+		}, ConditionContextDependent, []string{"aws:CurrentTime"}},
+		{"DateGreaterThan a past day", map[string]interface{}{
+			"DateGreaterThan": map[string]interface{}{"aws:CurrentTime": "2020-01-01"},
+		}, ConditionAlwaysTrue, []string{"aws:CurrentTime"}},
+		{"DateGreaterThan a future epoch", map[string]interface{}{
+			"DateGreaterThan": map[string]interface{}{"aws:EpochTime": "4102444800"},
+		}, ConditionContextDependent, []string{"aws:EpochTime"}},
+		{"DateGreaterThan a malformed date", map[string]interface{}{
+			"DateGreaterThan": map[string]interface{}{"aws:CurrentTime": "yesterday"},
+		}, ConditionContextDependent, []string{"aws:CurrentTime"}},
+		{"date of another key", map[string]interface{}{
+			"DateLessThan": map[string]interface{}{"aws:TokenIssueTime": "2020-01-01T00:00:00Z"},
+		}, ConditionContextDependent, []string{"aws:TokenIssueTime"}},
+		{"StringLike any value of a key always present", map[string]interface{}{
+			"StringLike": map[string]interface{}{"aws:PrincipalArn": "*"},
+		}, ConditionAlwaysTrue, []string{"aws:PrincipalArn"}},
+		{"always false with a context-dependent condition", map[string]interface{}{
+			"DateLessThan": map[string]interface{}{"aws:CurrentTime": "2020-01-01T00:00:00Z"},
+			"StringEquals": map[string]interface{}{"aws:PrincipalTag/team": "data"},
+		}, ConditionAlwaysFalse, []string{"aws:CurrentTime", "aws:PrincipalTag/team"}},
+		{"always true with a context-dependent condition", map[string]interface{}{
+			"Null":         map[string]interface{}{"aws:SecureTransport": "false"},
+			"StringEquals": map[string]interface{}{"aws:PrincipalTag/team": "data"},
+		}, ConditionContextDependent, []string{"aws:PrincipalTag/team", "aws:SecureTransport"}},
+		{"malformed operator", map[string]interface{}{
+			"StringEquals": "data",
+		}, ConditionContextDependent, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := evaluateConditionAt(test.condition, now)
+			if got.Result != test.want || !slices.Equal(got.Keys, test.keys) {
+				t.Errorf("evaluateConditionAt() = %s %v, want %s %v", got.Result, got.Keys, test.want, test.keys)
+			}
+			if got.Conditional() != (test.want != ConditionAlwaysTrue) {
+				t.Errorf("Conditional() = %v for %s", got.Conditional(), got.Result)
+			}
+		})
+	}
+}
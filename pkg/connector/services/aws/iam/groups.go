@@ -13,7 +13,7 @@ import (
 )
 
 func ListGroups(cfg aws.Config) (groups []*Group) {
-	iamClient = IAMClient{client: iam.NewFromConfig(cfg), Config: cfg, logger: logging.GetLogManager()}
+	iamClient := IAMClient{client: iam.NewFromConfig(cfg), Config: cfg, logger: logging.GetLogManager()}
 
 	groups = iter.Map(iamClient.listGroups(), func(group *types.Group) *Group {
 		inlines := iamClient.listInlinePolicies(aws.ToString(group.GroupName), "group")
@@ -46,7 +46,7 @@ func (ic *IAMClient) listGroupsForUser(identity string) []types.Group {
 func (ic *IAMClient) listGroups() (collectedGroups []types.Group) {
 	var marker *string
 	for {
-		output, err := iamClient.client.ListGroups(context.TODO(), &iam.ListGroupsInput{
+		output, err := ic.client.ListGroups(context.TODO(), &iam.ListGroupsInput{
 			Marker: marker,
 		})
 		if errors.As(err, &re) {
@@ -8,10 +8,12 @@ import (
 	"net/url"
 	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/accessanalyzer"
 	aat "github.com/aws/aws-sdk-go-v2/service/accessanalyzer/types"
 	"github.com/aws/aws-sdk-go-v2/service/iam"
 	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/primait/nuvola/pkg/io/logging"
 )
 
 var VALIDATE = false
@@ -221,6 +223,61 @@ func (ic *IAMClient) listAttachedPolicies(identity string, object string) (attac
 	return
 }
 
+// aws iam get-{role,user}: the only APIs returning the permissions boundary and the tags of the identity
+func (ic *IAMClient) getBoundaryAndTags(identity string, object string) (*AttachedPolicies, []types.Tag) {
+	var boundary *types.AttachedPermissionsBoundary
+	var tags []types.Tag
+
+	switch object {
+	case "role":
+		output, err := ic.client.GetRole(context.TODO(), &iam.GetRoleInput{
+			RoleName: &identity,
+		})
+		if errors.As(err, &re) {
+			ic.logger.Warn("Error on GetRole", "err", re)
+		}
+		if output != nil && output.Role != nil {
+			boundary, tags = output.Role.PermissionsBoundary, output.Role.Tags
+		}
+	case "user":
+		output, err := ic.client.GetUser(context.TODO(), &iam.GetUserInput{
+			UserName: &identity,
+		})
+		if errors.As(err, &re) {
+			ic.logger.Warn("Error on GetUser", "err", re)
+		}
+		if output != nil && output.User != nil {
+			boundary, tags = output.User.PermissionsBoundary, output.User.Tags
+		}
+	default:
+		ic.logger.Warn("no user/role defined", "object", object)
+	}
+
+	if boundary == nil || boundary.PermissionsBoundaryArn == nil {
+		return nil, tags
+	}
+
+	policyArn := aws.ToString(boundary.PermissionsBoundaryArn)
+	policyVersions := ic.listPolicyVersions(boundary.PermissionsBoundaryArn)
+	if len(policyVersions) == 0 {
+		return nil, tags
+	}
+	ic.expandActions(&policyVersions[0].Document, identity)
+	return &AttachedPolicies{
+		AttachedPolicy: types.AttachedPolicy{
+			PolicyArn:  aws.String(policyArn),
+			PolicyName: aws.String(policyArn[strings.LastIndex(policyArn, "/")+1:]),
+		},
+		Versions: policyVersions,
+	}, tags
+}
+
+// Expand wildcards and NotAction of policies collected outside IAM (e.g. Service Control Policies)
+func ExpandActions(policy *PolicyDocument, identity any) {
+	ic := IAMClient{logger: logging.GetLogManager()}
+	ic.expandActions(policy, identity)
+}
+
 func (ic *IAMClient) expandActions(policy *PolicyDocument, identity any) {
 	for i, statement := range policy.Statement {
 		var realActions []string
@@ -17,7 +17,7 @@ import (
 
 // aws iam list-roles and aws iam list-instance-profiles
 func ListRoles(cfg aws.Config) (roles []*Role) {
-	iamClient = IAMClient{Config: cfg, client: iam.NewFromConfig(cfg), logger: logging.GetLogManager()}
+	iamClient := IAMClient{Config: cfg, client: iam.NewFromConfig(cfg), logger: logging.GetLogManager()}
 
 	roles = iter.Map(iamClient.listRoles(), func(role *types.Role) *Role {
 		var assumeRoleDocument = PolicyDocument{}
@@ -66,12 +66,15 @@ func ListRoles(cfg aws.Config) (roles []*Role) {
 
 		inline := iamClient.listInlinePolicies(aws.ToString(role.RoleName), "role")
 		attached := iamClient.listAttachedPolicies(aws.ToString(role.RoleName), "role")
+		boundary, tags := iamClient.getBoundaryAndTags(aws.ToString(role.RoleName), "role")
+		role.Tags = tags
 		return &Role{
 			Role:                     *role,
 			AssumeRolePolicyDocument: assumeRoleDocument,
 			AssumableBy:              assumableBy,
 			AttachedPolicies:         attached,
 			InlinePolicies:           inline,
+			BoundaryPolicy:           boundary,
 			InstanceProfileID:        instanceProfileRef,
 			InstanceProfileArn:       instanceProfileArn,
 		}
@@ -91,7 +94,7 @@ func (ic *IAMClient) listRoles() []types.Role {
 	)
 
 	for {
-		roleOutput, err := iamClient.client.ListRoles(context.TODO(), &iam.ListRolesInput{
+		roleOutput, err := ic.client.ListRoles(context.TODO(), &iam.ListRolesInput{
 			Marker:   marker,
 			MaxItems: aws.Int32(300),
 		})
@@ -115,7 +118,7 @@ func (ic *IAMClient) listInstanceProfiles() []types.InstanceProfile {
 	)
 
 	for {
-		roleOutput, err := iamClient.client.ListInstanceProfiles(context.TODO(), &iam.ListInstanceProfilesInput{
+		roleOutput, err := ic.client.ListInstanceProfiles(context.TODO(), &iam.ListInstanceProfilesInput{
 			Marker:   marker,
 			MaxItems: aws.Int32(300),
 		})
@@ -1,6 +1,7 @@
 package iam
 
 import (
+	"encoding/json"
 	"sort"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -44,6 +45,7 @@ type Role struct {
 	AssumableBy              []string           `json:"AssumableBy,omitempty"`
 	AttachedPolicies         []AttachedPolicies `json:"AttachedPolicies,omitempty"`
 	InlinePolicies           []PolicyDocument   `json:"InlinePolicies,omitempty"`
+	BoundaryPolicy           *AttachedPolicies  `json:"BoundaryPolicy,omitempty"`
 	InstanceProfileID        string             `json:"InstanceProfileId,omitempty"`
 	InstanceProfileArn       string             `json:"InstanceProfileArn,omitempty"`
 }
@@ -59,6 +61,7 @@ type User struct {
 	LoginProfile        types.LoginProfile        `json:"LoginProfile,omitempty"`
 	AttachedPolicies    []AttachedPolicies        `json:"AttachedPolicies,omitempty"`
 	InlinePolicies      []PolicyDocument          `json:"InlinePolicies,omitempty"`
+	BoundaryPolicy      *AttachedPolicies         `json:"BoundaryPolicy,omitempty"`
 }
 
 // Override SDK Group type
@@ -100,19 +103,31 @@ type Principal struct {
 	Federated interface{} `json:"Federated,omitempty"`
 }
 
+// "Principal": "*" is equivalent to "Principal": {"AWS": "*"}
+func (p *Principal) UnmarshalJSON(data []byte) error {
+	var wildcard string
+	if err := json.Unmarshal(data, &wildcard); err == nil {
+		p.AWS = wildcard
+		return nil
+	}
+
+	type principal Principal
+	return json.Unmarshal(data, (*principal)(p))
+}
+
 type Statement struct {
-	Sid       string      `json:"Sid,omitempty"`
-	Effect    string      `json:"Effect"`
-	Principal *Principal  `json:"Principal,omitempty"`
-	Action    interface{} `json:"Action,omitempty"`
-	NotAction interface{} `json:"NotAction,omitempty"`
-	Resource  interface{} `json:"Resource,omitempty"`
-	Condition interface{} `json:"Condition,omitempty"`
+	Sid         string      `json:"Sid,omitempty"`
+	Effect      string      `json:"Effect"`
+	Principal   *Principal  `json:"Principal,omitempty"`
+	Action      interface{} `json:"Action,omitempty"`
+	NotAction   interface{} `json:"NotAction,omitempty"`
+	Resource    interface{} `json:"Resource,omitempty"`
+	NotResource interface{} `json:"NotResource,omitempty"`
+	Condition   interface{} `json:"Condition,omitempty"`
 }
 
 var (
 	re          *awshttp.ResponseError
-	iamClient   IAMClient
 	ActionsMap  map[string][]string
 	ActionsList []string
 )
@@ -36,12 +36,15 @@ func ListUsers(cfg aws.Config, credentialReport map[string]*CredentialReport) (u
 		})
 	}
 
-	users = append(users, iter.Map(listUsers(), func(user *types.User) *User {
+	iamClient := IAMClient{Config: cfg, client: iam.NewFromConfig(cfg), logger: logging.GetLogManager()}
+	users = append(users, iter.Map(iamClient.listUsers(), func(user *types.User) *User {
 		groups := iamClient.listGroupsForUser(aws.ToString(user.UserName))
 		inline := iamClient.listInlinePolicies(aws.ToString(user.UserName), "user")
 		attached := iamClient.listAttachedPolicies(aws.ToString(user.UserName), "user")
 		accessKeys := iamClient.listAccessKeys(aws.ToString(user.UserName))
 		loginProfile := iamClient.listLoginProfile(aws.ToString(user.UserName))
+		boundary, tags := iamClient.getBoundaryAndTags(aws.ToString(user.UserName), "user")
+		user.Tags = tags
 
 		userAccount := credentialReport[aws.ToString(user.UserName)]
 		return &User{
@@ -51,6 +54,7 @@ func ListUsers(cfg aws.Config, credentialReport map[string]*CredentialReport) (u
 			LoginProfile:        loginProfile,
 			InlinePolicies:      inline,
 			AttachedPolicies:    attached,
+			BoundaryPolicy:      boundary,
 			PasswordEnabled:     userAccount.PasswordEnabled,
 			PasswordLastChanged: userAccount.PasswordLastChanged,
 			MfaActive:           userAccount.MfaActive,
@@ -64,18 +68,15 @@ func ListUsers(cfg aws.Config, credentialReport map[string]*CredentialReport) (u
 	return
 }
 
-func listUsers() (collectedUsers []types.User) {
-	var (
-		marker *string
-		logger = logging.GetLogManager()
-	)
+func (ic *IAMClient) listUsers() (collectedUsers []types.User) {
+	var marker *string
 
 	for {
-		output, err := iamClient.client.ListUsers(context.TODO(), &iam.ListUsersInput{
+		output, err := ic.client.ListUsers(context.TODO(), &iam.ListUsersInput{
 			Marker: marker,
 		})
 		if errors.As(err, &re) {
-			logger.Warn("Error on ListUsers", "err", re)
+			ic.logger.Warn("Error on ListUsers", "err", re)
 		}
 
 		collectedUsers = append(collectedUsers, output.Users...)
@@ -0,0 +1,224 @@
+package organizations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	"github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/primait/nuvola/pkg/connector/services/aws/iam"
+	"github.com/primait/nuvola/pkg/io/logging"
+)
+
+// aws organizations describe-organization: only the management account (or a delegated administrator) can list the whole tree
+func ListOrganization(cfg aws.Config) (org *Organization) {
+	orgClient := OrganizationsClient{Config: cfg, client: organizations.NewFromConfig(cfg), logger: logging.GetLogManager()}
+
+	output, err := orgClient.client.DescribeOrganization(context.TODO(), &organizations.DescribeOrganizationInput{})
+	if errors.As(err, &re) {
+		orgClient.logger.Warn("Error on DescribeOrganization", "err", re)
+		return nil
+	}
+	if output == nil || output.Organization == nil {
+		return nil
+	}
+
+	org = &Organization{Organization: *output.Organization}
+	org.Roots = orgClient.listRoots()
+	for _, root := range org.Roots {
+		orgClient.walkOrganizationalUnits(aws.ToString(root.Id), org)
+	}
+	org.Policies = orgClient.listServiceControlPolicies()
+
+	sort.Slice(org.OrganizationalUnits, func(i, j int) bool {
+		return aws.ToString(org.OrganizationalUnits[i].Id) < aws.ToString(org.OrganizationalUnits[j].Id)
+	})
+	sort.Slice(org.Accounts, func(i, j int) bool {
+		return aws.ToString(org.Accounts[i].Id) < aws.ToString(org.Accounts[j].Id)
+	})
+	return
+}
+
+// aws organizations list-accounts: only ACTIVE accounts can be accessed
+func ListActiveAccounts(cfg aws.Config) (accounts []types.Account) {
+	orgClient := OrganizationsClient{Config: cfg, client: organizations.NewFromConfig(cfg), logger: logging.GetLogManager()}
+
+	var nextToken *string
+	for {
+		output, err := orgClient.client.ListAccounts(context.TODO(), &organizations.ListAccountsInput{
+			NextToken: nextToken,
+		})
+		if errors.As(err, &re) || output == nil {
+			orgClient.logger.Warn("Error on ListAccounts", "err", err)
+			return
+		}
+
+		for _, account := range output.Accounts {
+			if account.State == types.AccountStateActive {
+				accounts = append(accounts, account)
+			}
+		}
+		if output.NextToken == nil {
+			break
+		}
+		nextToken = output.NextToken
+	}
+
+	sort.Slice(accounts, func(i, j int) bool {
+		return aws.ToString(accounts[i].Id) < aws.ToString(accounts[j].Id)
+	})
+	return
+}
+
+func (oc *OrganizationsClient) listRoots() (roots []types.Root) {
+	var nextToken *string
+	for {
+		output, err := oc.client.ListRoots(context.TODO(), &organizations.ListRootsInput{
+			NextToken: nextToken,
+		})
+		if errors.As(err, &re) || output == nil {
+			oc.logger.Warn("Error on ListRoots", "err", err)
+			return
+		}
+
+		roots = append(roots, output.Roots...)
+		if output.NextToken == nil {
+			break
+		}
+		nextToken = output.NextToken
+	}
+	return
+}
+
+// Recursively collect OUs and accounts under a parent (Root or OU)
+func (oc *OrganizationsClient) walkOrganizationalUnits(parentID string, org *Organization) {
+	for _, account := range oc.listAccountsForParent(parentID) {
+		org.Accounts = append(org.Accounts, Account{Account: account, ParentID: parentID})
+	}
+
+	for _, ou := range oc.listOrganizationalUnitsForParent(parentID) {
+		org.OrganizationalUnits = append(org.OrganizationalUnits, OrganizationalUnit{OrganizationalUnit: ou, ParentID: parentID})
+		oc.walkOrganizationalUnits(aws.ToString(ou.Id), org)
+	}
+}
+
+func (oc *OrganizationsClient) listOrganizationalUnitsForParent(parentID string) (ous []types.OrganizationalUnit) {
+	var nextToken *string
+	for {
+		output, err := oc.client.ListOrganizationalUnitsForParent(context.TODO(), &organizations.ListOrganizationalUnitsForParentInput{
+			ParentId:  &parentID,
+			NextToken: nextToken,
+		})
+		if errors.As(err, &re) || output == nil {
+			oc.logger.Warn("Error on ListOrganizationalUnitsForParent", "err", err)
+			return
+		}
+
+		ous = append(ous, output.OrganizationalUnits...)
+		if output.NextToken == nil {
+			break
+		}
+		nextToken = output.NextToken
+	}
+	return
+}
+
+func (oc *OrganizationsClient) listAccountsForParent(parentID string) (accounts []types.Account) {
+	var nextToken *string
+	for {
+		output, err := oc.client.ListAccountsForParent(context.TODO(), &organizations.ListAccountsForParentInput{
+			ParentId:  &parentID,
+			NextToken: nextToken,
+		})
+		if errors.As(err, &re) || output == nil {
+			oc.logger.Warn("Error on ListAccountsForParent", "err", err)
+			return
+		}
+
+		accounts = append(accounts, output.Accounts...)
+		if output.NextToken == nil {
+			break
+		}
+		nextToken = output.NextToken
+	}
+	return
+}
+
+// aws organizations list-policies --filter SERVICE_CONTROL_POLICY
+func (oc *OrganizationsClient) listServiceControlPolicies() (policies []ServiceControlPolicy) {
+	var nextToken *string
+	for {
+		output, err := oc.client.ListPolicies(context.TODO(), &organizations.ListPoliciesInput{
+			Filter:    types.PolicyTypeServiceControlPolicy,
+			NextToken: nextToken,
+		})
+		if errors.As(err, &re) || output == nil {
+			oc.logger.Warn("Error on ListPolicies", "err", err)
+			return
+		}
+
+		for _, policy := range output.Policies {
+			policies = append(policies, ServiceControlPolicy{
+				PolicySummary: policy,
+				Document:      oc.describePolicy(policy.Id),
+				Targets:       oc.listTargetsForPolicy(policy.Id),
+			})
+		}
+		if output.NextToken == nil {
+			break
+		}
+		nextToken = output.NextToken
+	}
+
+	sort.Slice(policies, func(i, j int) bool {
+		return aws.ToString(policies[i].Name) < aws.ToString(policies[j].Name)
+	})
+	return
+}
+
+func (oc *OrganizationsClient) describePolicy(policyID *string) (document iam.PolicyDocument) {
+	output, err := oc.client.DescribePolicy(context.TODO(), &organizations.DescribePolicyInput{
+		PolicyId: policyID,
+	})
+	if errors.As(err, &re) {
+		oc.logger.Warn("Error on DescribePolicy", "err", re)
+	}
+
+	if output != nil && output.Policy != nil {
+		decodedValue, _ := url.QueryUnescape(aws.ToString(output.Policy.Content))
+		if err := json.Unmarshal([]byte(decodedValue), &document); err != nil {
+			oc.logger.Warn("Error on Unmarshalling SCP document", "err", err)
+		}
+		document.PolicyName = aws.ToString(output.Policy.PolicySummary.Name)
+		iam.ExpandActions(&document, aws.ToString(policyID))
+	}
+	return
+}
+
+func (oc *OrganizationsClient) listTargetsForPolicy(policyID *string) (targets []string) {
+	var nextToken *string
+	for {
+		output, err := oc.client.ListTargetsForPolicy(context.TODO(), &organizations.ListTargetsForPolicyInput{
+			PolicyId:  policyID,
+			NextToken: nextToken,
+		})
+		if errors.As(err, &re) || output == nil {
+			oc.logger.Warn("Error on ListTargetsForPolicy", "err", err)
+			return
+		}
+
+		for _, target := range output.Targets {
+			targets = append(targets, aws.ToString(target.TargetId))
+		}
+		if output.NextToken == nil {
+			break
+		}
+		nextToken = output.NextToken
+	}
+	sort.Strings(targets)
+	return
+}
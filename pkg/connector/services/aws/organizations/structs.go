@@ -0,0 +1,46 @@
+package organizations
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	"github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/primait/nuvola/pkg/connector/services/aws/iam"
+	"github.com/primait/nuvola/pkg/io/logging"
+)
+
+type OrganizationsClient struct {
+	client *organizations.Client
+	Config aws.Config
+	logger logging.LogManager
+}
+
+// The whole AWS Organization: the OU tree, the member accounts and the Service Control Policies
+type Organization struct {
+	types.Organization
+	Roots               []types.Root           `json:"Roots,omitempty"`
+	OrganizationalUnits []OrganizationalUnit   `json:"OrganizationalUnits,omitempty"`
+	Accounts            []Account              `json:"Accounts,omitempty"`
+	Policies            []ServiceControlPolicy `json:"Policies,omitempty"`
+}
+
+// Override SDK OrganizationalUnit type to keep track of the parent (Root or OU)
+type OrganizationalUnit struct {
+	types.OrganizationalUnit
+	ParentID string `json:"ParentId"`
+}
+
+// Override SDK Account type to keep track of the parent (Root or OU)
+type Account struct {
+	types.Account
+	ParentID string `json:"ParentId"`
+}
+
+// Override SDK PolicySummary type with the document and the targets (Root, OU or Account IDs)
+type ServiceControlPolicy struct {
+	types.PolicySummary
+	Document iam.PolicyDocument `json:"Document"`
+	Targets  []string           `json:"Targets,omitempty"`
+}
+
+var re *awshttp.ResponseError
@@ -197,3 +197,16 @@ var IAMActionResourceMap = map[string]string{
 	"UploadSigningCertificate":                  "User",
 	"UploadSSHPublicKey":                        "User",
 }
+
+// The STS actions applying to a role: sts:AssumeRole is required to assume the roles trusting the whole account
+var STSActionResourceMap = map[string]string{
+	"AssumeRole":        "Role",
+	"SetSourceIdentity": "Role",
+	"TagSession":        "Role",
+}
+
+// The actions on the IAM principals by service
+var IdentityActionResourceMaps = map[string]map[string]string{
+	"iam": IAMActionResourceMap,
+	"sts": STSActionResourceMap,
+}
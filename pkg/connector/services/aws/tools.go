@@ -1,56 +1,32 @@
 package awsconnector
 
 import (
-	"strings"
-	"time"
-
-	req "github.com/imroc/req/v3"
-	"github.com/itchyny/gojq"
-	"github.com/ohler55/ojg/oj"
+	"github.com/primait/nuvola/pkg/connector/services/aws/catalog"
 	"github.com/primait/nuvola/pkg/io/logging"
 )
 
+// Load the actions from the cached catalog (see `nuvola catalog update`) or from the one embedded in the binary
 func SetActions() {
 	logger := logging.GetLogManager()
-	URL := "https://awspolicygen.s3.amazonaws.com/js/policies.js"
-	client := req.C().SetBaseURL(URL).SetTimeout(30 * time.Second).SetUserAgent("Mozilla/5.0 (X11; Linux x86_64; rv:103.0) Gecko/20100101 Firefox/103.0")
-
-	response, err := client.R().
-		SetHeader("Connection", "keep-alive").
-		SetHeader("Pragma", "no-cache").
-		SetHeader("Cache-Control", "no-cache").
-		Get(URL)
+	path, err := catalog.CachePath()
 	if err != nil {
-		logger.Error("Error on calling HTTP endpoint", "err", err)
+		logger.Warn("Unable to locate the catalog cache, using the embedded catalog", "err", err)
 	}
 
-	resString := strings.Replace(response.String(), "app.PolicyEditorConfig=", "", 1)
-	obj, err := oj.ParseString(resString)
-	if err != nil {
-		logger.Error("Error on parsing output string", "err", err)
+	Catalog, err = catalog.Load(path)
+	if Catalog == nil {
+		logger.Error("Error on loading the action catalog", "err", err)
 	}
-	query, err := gojq.Parse(`.serviceMap[] | .StringPrefix as $prefix | .Actions[] | "\($prefix):\(.)"`)
 	if err != nil {
-		logger.Error("Error on mapping string to object", "err", err)
+		logger.Warn("Unable to load the cached catalog, using the embedded catalog", "err", err)
 	}
-
-	iter := query.Run(obj)
-	ActionsMap = make(map[string][]string)
-	for {
-		v, ok := iter.Next()
-		if !ok {
-			break
-		}
-		if err, ok := v.(error); ok {
-			logger.Error("Error on iterating over objects", "err", err)
-		}
-
-		ActionsList = append(ActionsList, v.(string))
-		split := strings.Split(v.(string), ":")
-		ActionsMap[split[0]] = append(ActionsMap[split[0]], split[1])
+	logger.Debug("Loaded action catalog", "version", Catalog.Version, "updated", Catalog.Updated)
+	if !Catalog.Detailed() {
+		logger.Warn("The action catalog has no resource types and condition keys: run `nuvola catalog update` to download them", "version", Catalog.Version, "source", Catalog.Source)
 	}
 
-	ActionsList = unique(ActionsList)
+	ActionsList = Catalog.Actions()
+	ActionsMap = Catalog.ActionsByService()
 }
 
 func unique(slice []string) []string {
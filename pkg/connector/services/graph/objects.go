@@ -0,0 +1,73 @@
+package graph
+
+import (
+	servicesDatabase "github.com/primait/nuvola/pkg/connector/services/aws/database"
+	servicesEC2 "github.com/primait/nuvola/pkg/connector/services/aws/ec2"
+	servicesLambda "github.com/primait/nuvola/pkg/connector/services/aws/lambda"
+	servicesOrganizations "github.com/primait/nuvola/pkg/connector/services/aws/organizations"
+	servicesS3 "github.com/primait/nuvola/pkg/connector/services/aws/s3"
+	"github.com/sourcegraph/conc/iter"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	orgtypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/notdodo/goflat/v2"
+	"github.com/ohler55/ojg/oj"
+)
+
+type EnumAWSTypes interface {
+	servicesS3.Bucket | servicesEC2.Instance | ec2types.Vpc | ec2types.VpcPeeringConnection | servicesLambda.Lambda | rdstypes.DBCluster | rdstypes.DBInstance | servicesDatabase.DynamoDB | servicesDatabase.RedshiftDB |
+		orgtypes.Root | servicesOrganizations.OrganizationalUnit | servicesOrganizations.Account
+}
+
+// Labels of the nodes of the dumped resources: they also have the Service label
+var ServiceLabels = []string{"S3", "Ec2", "Vpc", "Lambda", "Rds", "Dynamodb", "Redshift"}
+
+// Labels of all the nodes created by the stores
+var NodeLabels = append([]string{
+	"Service", "IAM", "User", "Group", "Role", "InstanceProfile", "Policy", "Attached", "Inline", "SCP", "Action",
+	"Account", "ExternalAccount", "OrganizationalUnit", "Root", "AWSService", "FederatedProvider", "AnyAWSPrincipal", "Anonymous",
+}, ServiceLabels...)
+
+// Types of all the relationships created by the stores
+var RelationshipTypes = []string{
+	"MEMBER_OF", "HAS_POLICY", "ALLOWS", "DENIES", "HAS_BOUNDARY", "HAS_SCP", "HAS_PERMISSION", "CAN_ASSUME",
+	"CAN_PRIVESC", "BELONGS_TO", "CHILD_OF", "ON", "USES", "NETWORK", "PEERING",
+}
+
+// Flatten the objects to store them as node properties (e.g. Placement_AvailabilityZone)
+func FlatObjects[N EnumAWSTypes](o []N) (result map[string]interface{}) {
+	result = make(map[string]interface{}, 0)
+	result["objects"] = make([]map[string]interface{}, len(o))
+
+	items := iter.Map(o, func(obj *N) map[string]interface{} {
+		jsonString, _ := oj.Marshal(obj)
+		flat, _ := goflat.FlatJSON(string(jsonString), goflat.FlattenerConfig{
+			Prefix:    "",
+			Separator: "_",
+			OmitNil:   true,
+			OmitEmpty: true,
+		})
+		flatObject := make(map[string]interface{})
+		_ = oj.Unmarshal([]byte(flat), &flatObject)
+		return flatObject
+	})
+	result["objects"] = items
+	return
+}
+
+// Set the Arn property on the flattened objects: not all the services return it
+func WithARNs(objects map[string]interface{}, arns []string) map[string]interface{} {
+	for i, object := range objects["objects"].([]map[string]interface{}) {
+		object["Arn"] = arns[i]
+	}
+	return objects
+}
+
+func ResourceARN(partition, service, region, account, resource string) string {
+	if partition == "" {
+		partition = "aws"
+	}
+	return arn.ARN{Partition: partition, Service: service, Region: region, AccountID: account, Resource: resource}.String()
+}
@@ -0,0 +1,21 @@
+package graph
+
+// A path returned by a rule, in the order it is walked: the relationship i links the node i to the node i+1.
+// IDs are only unique within the store the path comes from
+type Path struct {
+	Nodes         []PathNode
+	Relationships []PathRelationship
+}
+
+type PathNode struct {
+	ID         string
+	Labels     []string
+	Properties map[string]interface{}
+}
+
+type PathRelationship struct {
+	Type       string
+	From       string
+	To         string
+	Properties map[string]interface{}
+}
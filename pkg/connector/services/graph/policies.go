@@ -0,0 +1,258 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	servicesIAM "github.com/primait/nuvola/pkg/connector/services/aws/iam"
+	"github.com/primait/nuvola/pkg/io/logging"
+)
+
+// Resource element of a statement for a single Action: linked with ON to the resources matching it
+type ActionResource struct {
+	Policy       string
+	Service      string
+	Action       string
+	Relationship string
+	Condition    string
+	Matcher      servicesIAM.ResourceMatcher
+}
+
+// The Resource elements of all the imported policies, matched with the resources imported next
+type ActionResources struct {
+	relations []ActionResource
+}
+
+func (ar *ActionResources) add(statement *servicesIAM.Statement, service, action, policy, relationship, condition string, variables map[string]string) {
+	matcher := servicesIAM.ResourceMatcher{Patterns: ToStringList(statement.Resource), Variables: variables}
+	if statement.NotResource != nil {
+		matcher.Patterns, matcher.NotResource = ToStringList(statement.NotResource), true
+	}
+
+	ar.relations = append(ar.relations, ActionResource{
+		Policy:       policy,
+		Service:      strings.ToLower(service),
+		Action:       action,
+		Relationship: relationship,
+		Condition:    condition,
+		Matcher:      matcher,
+	})
+}
+
+// Match the resources (ARN) of a service with the statements of all the imported policies
+func (ar *ActionResources) Match(service string, arns []string, filter func(*ActionResource) bool) (links []map[string]string) {
+	ar.relations = uniqueActionsResources(ar.relations)
+	for i := range ar.relations {
+		relation := &ar.relations[i]
+		if !strings.EqualFold(relation.Service, service) || !filter(relation) {
+			continue
+		}
+		for _, arn := range arns {
+			if relation.Matcher.Match(arn) {
+				links = append(links, map[string]string{
+					"policy":       relation.Policy,
+					"service":      relation.Service,
+					"action":       relation.Action,
+					"relationship": relation.Relationship,
+					"condition":    relation.Condition,
+					"arn":          arn,
+				})
+			}
+		}
+	}
+	return
+}
+
+func uniqueActionsResources(slice []ActionResource) (list []ActionResource) {
+	keys := make(map[string]bool)
+
+	for _, v := range slice {
+		key := fmt.Sprint(v.Policy, v.Relationship, v.Condition, v.Service, v.Action, v.Matcher.Patterns, v.Matcher.NotResource, v.Matcher.Variables)
+		if _, value := keys[key]; !value {
+			keys[key] = true
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// Flatten a string-or-list element of a statement (Resource, Action, Principal values)
+func ToStringList(element any) (list []string) {
+	switch v := element.(type) {
+	case []interface{}:
+		for _, item := range v {
+			list = append(list, fmt.Sprint(item))
+		}
+	case []string:
+		list = append(list, v...)
+	case string:
+		list = append(list, v)
+	}
+	return
+}
+
+// Split the statements of a policy in the "allows" and "denies" lists of Actions, with the static evaluation
+// of their conditions, and register their Resource elements. Policy variables are resolved only for
+// policies not shared between principals (variables not nil)
+func ParseStatements(policy string, statements *[]servicesIAM.Statement, variables map[string]string, resources *ActionResources) map[string]interface{} {
+	logger := logging.GetLogManager()
+	actions := make(map[string]interface{})
+	actions["allows"] = make([]map[string]interface{}, 0)
+	actions["denies"] = make([]map[string]interface{}, 0)
+
+	for i := range *statements {
+		statement := &(*statements)[i]
+		var relationship, key string
+		switch statement.Effect {
+		case "Allow":
+			relationship, key = "ALLOWS", "allows"
+		case "Deny":
+			relationship, key = "DENIES", "denies"
+		default:
+			logger.Warn("ParseStatements - unknown effect", "effect", statement.Effect)
+			continue
+		}
+
+		items := make([]map[string]interface{}, 0)
+		statementResources := ToStringList(statement.Resource)
+		evaluation := servicesIAM.EvaluateCondition(statement.Condition)
+		conditions := []string{}
+		if statement.Condition != nil {
+			serialized, _ := json.Marshal(statement.Condition)
+			conditions = append(conditions, string(serialized))
+		}
+
+		var serviceActions []string
+		switch v := statement.Action.(type) {
+		case []interface{}:
+			// list of Actions
+			serviceActions = ToStringList(v)
+		case string:
+			// single Action
+			serviceActions = []string{v}
+		default:
+			logger.Warn("ParseStatements - case not implemented", "action", statement.Action, "type", v)
+		}
+
+		for _, serviceAction := range serviceActions {
+			service, action, _ := strings.Cut(serviceAction, ":")
+			items = append(items, map[string]interface{}{
+				"service":       strings.ToLower(service),
+				"action":        action,
+				"policy":        policy,
+				"resources":     statementResources,
+				"condition":     string(evaluation.Result),
+				"conditionKeys": evaluation.Keys,
+				"conditions":    conditions,
+			})
+			resources.add(statement, service, action, policy, relationship, string(evaluation.Result), variables)
+		}
+
+		actions[key] = append(actions[key].([]map[string]interface{}), items...)
+	}
+	return actions
+}
+
+// UNWIND lists of the principals granted by trust and resource-based policies: AWS principals (accounts, users
+// and roles), services and federated providers are linked to different kind of nodes
+func NewGrants() map[string]interface{} {
+	return map[string]interface{}{
+		"aws":       make([]map[string]interface{}, 0),
+		"services":  make([]map[string]interface{}, 0),
+		"federated": make([]map[string]interface{}, 0),
+	}
+}
+
+func parseStatementPrincipals(grants map[string]interface{}, target string, principal *servicesIAM.Principal, properties map[string]interface{}) {
+	for _, awsPrincipal := range ToStringList(principal.AWS) {
+		item := map[string]interface{}{"target": target, "principal": awsPrincipal, "account": awsPrincipal, "properties": properties}
+		if arned, err := arn.Parse(awsPrincipal); err == nil {
+			item["account"] = arned.AccountID
+			// The root of an account means every principal of the account allowed by its own policies
+			if arned.Resource == "root" {
+				item["principal"] = ""
+			}
+		}
+		grants["aws"] = append(grants["aws"].([]map[string]interface{}), item)
+	}
+	for _, service := range ToStringList(principal.Service) {
+		grants["services"] = append(grants["services"].([]map[string]interface{}), map[string]interface{}{"target": target, "principal": service, "properties": properties})
+	}
+	for _, provider := range ToStringList(principal.Federated) {
+		grants["federated"] = append(grants["federated"].([]map[string]interface{}), map[string]interface{}{"target": target, "principal": provider, "properties": properties})
+	}
+}
+
+// Trust policy of a role: conditions are kept as properties of the CAN_ASSUME relationship
+func ParseTrustPolicy(role *servicesIAM.Role) map[string]interface{} {
+	grants := NewGrants()
+	for _, statement := range role.AssumeRolePolicyDocument.Statement {
+		// Deny statements and NotPrincipal are not modeled: they can only restrict who can assume the role
+		if statement.Effect != "Allow" || statement.Principal == nil {
+			continue
+		}
+
+		properties := ConditionsToProperties(statement.Condition)
+		properties["Actions"] = ToStringList(statement.Action)
+		parseStatementPrincipals(grants, aws.ToString(role.Arn), statement.Principal, properties)
+	}
+	return grants
+}
+
+// Resource-based policy (e.g. bucket or function policy): one CAN relationship for each allowed Action
+func ParseResourcePolicy(target string, policy any) map[string]interface{} {
+	grants := NewGrants()
+	document := servicesIAM.PolicyDocument{}
+	jsonPolicy, _ := json.Marshal(policy)
+	if err := json.Unmarshal(jsonPolicy, &document); err != nil {
+		return grants
+	}
+
+	for _, statement := range document.Statement {
+		// As for trust policies, Deny statements only restrict the access
+		if statement.Effect != "Allow" || statement.Principal == nil {
+			continue
+		}
+
+		for _, action := range ToStringList(statement.Action) {
+			properties := ConditionsToProperties(statement.Condition)
+			properties["Action"] = action
+			properties["Resources"] = ToStringList(statement.Resource)
+			parseStatementPrincipals(grants, target, statement.Principal, properties)
+		}
+	}
+	return grants
+}
+
+// Convert a Condition block to relationship properties: the static evaluation of the block, each condition
+// key (e.g. sts:ExternalId) with its values and the whole block serialized in Conditions
+func ConditionsToProperties(condition interface{}) map[string]interface{} {
+	evaluation := servicesIAM.EvaluateCondition(condition)
+	properties := map[string]interface{}{
+		"Condition":     string(evaluation.Result),
+		"Conditional":   evaluation.Conditional(),
+		"ConditionKeys": evaluation.Keys,
+	}
+	operators, ok := condition.(map[string]interface{})
+	if !ok || len(operators) == 0 {
+		return properties
+	}
+
+	for _, keys := range operators {
+		keysMap, ok := keys.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for key, values := range keysMap {
+			previous, _ := properties[key].([]string)
+			properties[key] = append(previous, ToStringList(values)...)
+		}
+	}
+
+	serialized, _ := json.Marshal(condition)
+	properties["Conditions"] = []string{string(serialized)}
+	return properties
+}
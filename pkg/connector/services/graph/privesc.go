@@ -0,0 +1,72 @@
+package graph
+
+// How the target of a privilege escalation technique must be related to the principal
+type PrivescRelation string
+
+const (
+	// A policy attached to the principal or to one of its groups
+	AttachedPolicy PrivescRelation = "attached-policy"
+	// The principal itself
+	Self PrivescRelation = "self"
+	// A group of the principal
+	OwnGroup PrivescRelation = "own-group"
+	// The principal itself or a role it can assume
+	SelfOrAssumable PrivescRelation = "self-or-assumable"
+	// A group the user is not a member of
+	OtherGroup PrivescRelation = "other-group"
+	// Any other identity
+	Other PrivescRelation = "other"
+	// A role trusting the service in PassTo
+	Passable PrivescRelation = "passable"
+	// The role of an existing Lambda function other than the principal
+	LambdaRole PrivescRelation = "lambda-role"
+)
+
+// A privilege escalation technique: the principal must have Action on a target with the Target labels and the
+// Relation with the principal, and all the other Permissions on any resource
+type PrivescTechnique struct {
+	Name        string
+	Action      string
+	Target      []string
+	Relation    PrivescRelation
+	PassTo      string
+	Permissions []string
+	// The technique grants any permission, not only the ones of the target
+	Unbounded bool
+}
+
+// https://rhinosecuritylabs.com/aws/aws-privilege-escalation-methods-mitigation/
+var PrivescTechniques = []PrivescTechnique{
+	// Change the permissions of a policy attached to the principal or one of its groups
+	{Name: "CreatePolicyVersion", Action: "iam:CreatePolicyVersion", Target: []string{"Policy"}, Relation: AttachedPolicy, Unbounded: true},
+	{Name: "SetDefaultPolicyVersion", Action: "iam:SetDefaultPolicyVersion", Target: []string{"Policy"}, Relation: AttachedPolicy},
+	// Add policies to the principal itself, to its groups or to a role it can assume
+	{Name: "AttachUserPolicy", Action: "iam:AttachUserPolicy", Target: []string{"User"}, Relation: Self, Unbounded: true},
+	{Name: "PutUserPolicy", Action: "iam:PutUserPolicy", Target: []string{"User"}, Relation: Self, Unbounded: true},
+	{Name: "AttachGroupPolicy", Action: "iam:AttachGroupPolicy", Target: []string{"Group"}, Relation: OwnGroup, Unbounded: true},
+	{Name: "PutGroupPolicy", Action: "iam:PutGroupPolicy", Target: []string{"Group"}, Relation: OwnGroup, Unbounded: true},
+	{Name: "AttachRolePolicy", Action: "iam:AttachRolePolicy", Target: []string{"Role"}, Relation: SelfOrAssumable, Unbounded: true},
+	{Name: "PutRolePolicy", Action: "iam:PutRolePolicy", Target: []string{"Role"}, Relation: SelfOrAssumable, Unbounded: true},
+	{Name: "AddUserToGroup", Action: "iam:AddUserToGroup", Target: []string{"Group"}, Relation: OtherGroup},
+	// Get credentials of another identity
+	{Name: "CreateAccessKey", Action: "iam:CreateAccessKey", Target: []string{"User"}, Relation: Other},
+	{Name: "CreateLoginProfile", Action: "iam:CreateLoginProfile", Target: []string{"User"}, Relation: Other},
+	{Name: "UpdateLoginProfile", Action: "iam:UpdateLoginProfile", Target: []string{"User"}, Relation: Other},
+	{Name: "UpdateAssumeRolePolicy", Action: "iam:UpdateAssumeRolePolicy", Target: []string{"Role"}, Relation: Other, Permissions: []string{"sts:AssumeRole"}},
+	// Pass a role to a service and run code with it
+	{Name: "PassRole+ec2:RunInstances", Action: "iam:PassRole", Target: []string{"Role", "InstanceProfile"}, Relation: Passable, PassTo: "ec2", Permissions: []string{"ec2:RunInstances"}},
+	{Name: "PassRole+lambda:CreateFunction", Action: "iam:PassRole", Target: []string{"Role"}, Relation: Passable, PassTo: "lambda", Permissions: []string{"lambda:CreateFunction", "lambda:InvokeFunction"}},
+	{Name: "PassRole+lambda:CreateEventSourceMapping", Action: "iam:PassRole", Target: []string{"Role"}, Relation: Passable, PassTo: "lambda", Permissions: []string{"lambda:CreateFunction", "lambda:CreateEventSourceMapping"}},
+	{Name: "PassRole+glue:CreateDevEndpoint", Action: "iam:PassRole", Target: []string{"Role"}, Relation: Passable, PassTo: "glue", Permissions: []string{"glue:CreateDevEndpoint"}},
+	{Name: "PassRole+cloudformation:CreateStack", Action: "iam:PassRole", Target: []string{"Role"}, Relation: Passable, PassTo: "cloudformation", Permissions: []string{"cloudformation:CreateStack"}},
+	{Name: "PassRole+datapipeline:CreatePipeline", Action: "iam:PassRole", Target: []string{"Role"}, Relation: Passable, PassTo: "datapipeline", Permissions: []string{"datapipeline:CreatePipeline", "datapipeline:PutPipelineDefinition"}},
+	{Name: "PassRole+sagemaker:CreateNotebookInstance", Action: "iam:PassRole", Target: []string{"Role"}, Relation: Passable, PassTo: "sagemaker", Permissions: []string{"sagemaker:CreateNotebookInstance", "sagemaker:CreatePresignedNotebookInstanceUrl"}},
+	{Name: "PassRole+codebuild:CreateProject", Action: "iam:PassRole", Target: []string{"Role"}, Relation: Passable, PassTo: "codebuild", Permissions: []string{"codebuild:CreateProject", "codebuild:StartBuild"}},
+	// Run code with the role of an existing function
+	{Name: "UpdateFunctionCode", Action: "lambda:UpdateFunctionCode", Target: []string{"Role"}, Relation: LambdaRole},
+}
+
+// The principal of the service trusted by a role that can be passed to it
+func ServicePrincipal(service string) string {
+	return service + ".amazonaws.com"
+}
@@ -0,0 +1,19 @@
+package graph
+
+import "time"
+
+// The relationships are rewritten when their owner is imported again: a relationship not seen again while its
+// owner was is stale. HAS_PERMISSION and CAN_PRIVESC are derived and rebuilt from scratch instead
+var (
+	// Written with the source node (e.g. memberships, policies and their statements, network links)
+	SourceOwned = []string{"CHILD_OF", "HAS_SCP", "MEMBER_OF", "HAS_POLICY", "HAS_BOUNDARY", "ALLOWS", "DENIES", "USES", "NETWORK", "PEERING", "BELONGS_TO"}
+	// Written with the target from its trust or resource-based policy
+	TargetOwned = []string{"CAN_ASSUME", "CAN"}
+	// Written only when both the policy and the resource are imported in the same run
+	BothOwned = []string{"ON"}
+)
+
+// A new snapshot ID for an import started at the given time
+func NewSnapshotID(t time.Time) string {
+	return t.UTC().Format("20060102T150405.000Z")
+}
@@ -0,0 +1,314 @@
+package memory_connector
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/primait/nuvola/pkg/connector/services/graph"
+	"github.com/primait/nuvola/pkg/io/logging"
+)
+
+type Node struct {
+	ID         int64
+	Labels     []string
+	Properties map[string]interface{}
+}
+
+type Relationship struct {
+	Type       string
+	From       *Node
+	To         *Node
+	Properties map[string]interface{}
+}
+
+// In-process graph with the same nodes and relationships created in Neo4j: the rules are evaluated natively
+// since Cypher queries cannot be executed
+type MemoryClient struct {
+	nodes         []*Node
+	byLabel       map[string][]*Node
+	outgoing      map[*Node][]*Relationship
+	incoming      map[*Node][]*Relationship
+	relationships map[string][]*Relationship
+	// Action nodes of each policy by relationship, condition, service and action
+	policyActions map[string]*Node
+	nextID        int64
+	logger        logging.LogManager
+	partition     string
+	accountID     string
+	resources     graph.ActionResources
+	snapshotID    string
+	lastSeen      time.Time
+}
+
+func NewMemoryClient() *MemoryClient {
+	mc := &MemoryClient{logger: logging.GetLogManager()}
+	mc.DeleteAll()
+	return mc
+}
+
+func (mc *MemoryClient) DeleteAll() {
+	mc.nodes = make([]*Node, 0)
+	mc.byLabel = make(map[string][]*Node)
+	mc.outgoing = make(map[*Node][]*Relationship)
+	mc.incoming = make(map[*Node][]*Relationship)
+	mc.relationships = make(map[string][]*Relationship)
+	mc.policyActions = make(map[string]*Node)
+	mc.resources = graph.ActionResources{}
+	mc.nextID = 0
+}
+
+// Nothing to create: lookups scan the nodes by label
+func (mc *MemoryClient) CreateSchema() {}
+
+// Every node and relationship written from now on is marked with the snapshot ID and the time it was seen
+func (mc *MemoryClient) SetSnapshot(snapshotID string, seen time.Time) {
+	mc.snapshotID, mc.lastSeen = snapshotID, seen
+}
+
+func (mc *MemoryClient) stamp(properties map[string]interface{}) {
+	properties["SnapshotId"], properties["LastSeen"] = mc.snapshotID, mc.lastSeen
+}
+
+func (mc *MemoryClient) seen(properties map[string]interface{}) bool {
+	return properties["SnapshotId"] == mc.snapshotID
+}
+
+// The in-memory store cannot run Cypher: the rules are evaluated with EvaluateRule, and the cypher rules need Neo4j
+var ErrCypher = errors.New("cypher queries need Neo4j: the in-memory store cannot run them")
+
+func (mc *MemoryClient) Query(query string, arguments map[string]interface{}) ([]map[string]interface{}, error) {
+	return nil, ErrCypher
+}
+
+func (mc *MemoryClient) QueryRecords(query string, arguments map[string]interface{}) ([]map[string]interface{}, error) {
+	return nil, ErrCypher
+}
+
+// Paths are found with EvaluateRulePaths
+func (mc *MemoryClient) QueryPaths(query string, arguments map[string]interface{}) ([]graph.Path, error) {
+	return nil, ErrCypher
+}
+
+func (n *Node) HasLabel(label string) bool {
+	return slices.Contains(n.Labels, label)
+}
+
+func (n *Node) hasLabels(labels []string) bool {
+	for _, label := range labels {
+		if !n.HasLabel(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// Property as a string, "" if missing
+func (n *Node) String(key string) string {
+	value, _ := n.Properties[key].(string)
+	return value
+}
+
+func (mc *MemoryClient) createNode(labels []string, properties map[string]interface{}) *Node {
+	mc.nextID++
+	n := &Node{ID: mc.nextID, Properties: make(map[string]interface{}, len(properties))}
+	maps.Copy(n.Properties, properties)
+	mc.nodes = append(mc.nodes, n)
+	for _, label := range labels {
+		mc.addLabel(n, label)
+	}
+	return n
+}
+
+func (mc *MemoryClient) addLabel(n *Node, label string) {
+	if n.HasLabel(label) {
+		return
+	}
+	n.Labels = append(n.Labels, label)
+	mc.byLabel[label] = append(mc.byLabel[label], n)
+}
+
+func (mc *MemoryClient) removeLabel(n *Node, label string) {
+	if !n.HasLabel(label) {
+		return
+	}
+	n.Labels = slices.DeleteFunc(n.Labels, func(l string) bool { return l == label })
+	mc.byLabel[label] = slices.DeleteFunc(mc.byLabel[label], func(other *Node) bool { return other == n })
+}
+
+// Nodes with all the labels and the properties: like a MATCH, a missing property never matches
+func (mc *MemoryClient) findNodes(labels []string, properties map[string]interface{}) (found []*Node) {
+	for _, n := range mc.byLabel[labels[0]] {
+		if n.hasLabels(labels) && hasProperties(n.Properties, properties) {
+			found = append(found, n)
+		}
+	}
+	return
+}
+
+func (mc *MemoryClient) findNode(labels []string, properties map[string]interface{}) *Node {
+	if found := mc.findNodes(labels, properties); len(found) > 0 {
+		return found[0]
+	}
+	return nil
+}
+
+// Like MERGE: the first node with the labels and the properties or a new one. created is true for a new node
+func (mc *MemoryClient) mergeNode(labels []string, properties map[string]interface{}) (n *Node, created bool) {
+	if n = mc.findNode(labels, properties); n != nil {
+		return n, false
+	}
+	return mc.createNode(labels, properties), true
+}
+
+// Like MERGE on the key followed by SET +=: properties missing from the import are kept, nil ones removed
+func (mc *MemoryClient) upsertNode(labels []string, key map[string]interface{}, properties map[string]interface{}) *Node {
+	n, _ := mc.mergeNode(labels[:1], key)
+	for _, label := range labels[1:] {
+		mc.addLabel(n, label)
+	}
+	for k, v := range properties {
+		if v == nil {
+			delete(n.Properties, k)
+		} else {
+			n.Properties[k] = v
+		}
+	}
+	mc.stamp(n.Properties)
+	return n
+}
+
+func hasProperties(properties, filter map[string]interface{}) bool {
+	for key, value := range filter {
+		current, ok := properties[key]
+		if !ok || !equalValues(current, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Equality of property values: numbers are compared by value as Neo4j does with integers and floats
+func equalValues(a, b interface{}) bool {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func relationshipKey(from *Node, relationshipType string, to *Node) string {
+	return fmt.Sprintf("%d|%s|%d", from.ID, relationshipType, to.ID)
+}
+
+// Like MERGE of a relationship: the first one between the nodes with the type and the properties or a new one
+func (mc *MemoryClient) mergeRelationship(from *Node, relationshipType string, properties map[string]interface{}, to *Node) *Relationship {
+	key := relationshipKey(from, relationshipType, to)
+	for _, r := range mc.relationships[key] {
+		if hasProperties(r.Properties, properties) {
+			return r
+		}
+	}
+
+	r := &Relationship{Type: relationshipType, From: from, To: to, Properties: make(map[string]interface{}, len(properties))}
+	maps.Copy(r.Properties, properties)
+	mc.relationships[key] = append(mc.relationships[key], r)
+	mc.outgoing[from] = append(mc.outgoing[from], r)
+	mc.incoming[to] = append(mc.incoming[to], r)
+	return r
+}
+
+// Like MERGE of a relationship written by the import: it is marked as seen in the current snapshot
+func (mc *MemoryClient) upsertRelationship(from *Node, relationshipType string, properties map[string]interface{}, to *Node) *Relationship {
+	r := mc.mergeRelationship(from, relationshipType, properties, to)
+	mc.stamp(r.Properties)
+	return r
+}
+
+func (mc *MemoryClient) deleteRelationship(r *Relationship) {
+	remove := func(list []*Relationship) []*Relationship {
+		return slices.DeleteFunc(list, func(other *Relationship) bool { return other == r })
+	}
+	key := relationshipKey(r.From, r.Type, r.To)
+	mc.relationships[key] = remove(mc.relationships[key])
+	if len(mc.relationships[key]) == 0 {
+		delete(mc.relationships, key)
+	}
+	mc.outgoing[r.From] = remove(mc.outgoing[r.From])
+	mc.incoming[r.To] = remove(mc.incoming[r.To])
+}
+
+// Like DETACH DELETE
+func (mc *MemoryClient) deleteNode(n *Node) {
+	for _, r := range slices.Concat(mc.outgoing[n], mc.incoming[n]) {
+		mc.deleteRelationship(r)
+	}
+	delete(mc.outgoing, n)
+	delete(mc.incoming, n)
+	for _, label := range slices.Clone(n.Labels) {
+		mc.removeLabel(n, label)
+	}
+	mc.nodes = slices.DeleteFunc(mc.nodes, func(other *Node) bool { return other == n })
+	maps.DeleteFunc(mc.policyActions, func(_ string, action *Node) bool { return action == n })
+}
+
+// Delete all the relationships of a type
+func (mc *MemoryClient) deleteRelationships(relationshipType string) {
+	for _, n := range mc.nodes {
+		for _, r := range mc.out(n, relationshipType) {
+			mc.deleteRelationship(r)
+		}
+	}
+}
+
+func (mc *MemoryClient) hasRelationship(from *Node, relationshipType string, to *Node) bool {
+	return len(mc.relationships[relationshipKey(from, relationshipType, to)]) > 0
+}
+
+// Relationships starting from the node with one of the types
+func (mc *MemoryClient) out(from *Node, types ...string) (found []*Relationship) {
+	for _, r := range mc.outgoing[from] {
+		if slices.Contains(types, r.Type) {
+			found = append(found, r)
+		}
+	}
+	return
+}
+
+func (mc *MemoryClient) in(to *Node, types ...string) (found []*Relationship) {
+	for _, r := range mc.incoming[to] {
+		if slices.Contains(types, r.Type) {
+			found = append(found, r)
+		}
+	}
+	return
+}
+
+// The users and roles
+func (mc *MemoryClient) principals() (found []*Node) {
+	for _, n := range mc.byLabel["IAM"] {
+		if n.HasLabel("User") || n.HasLabel("Role") {
+			found = append(found, n)
+		}
+	}
+	return
+}
@@ -0,0 +1,110 @@
+package memory_connector
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/primait/nuvola/pkg/connector/services/graph"
+)
+
+// The targets reached with ON from the Action of the permission, related to the principal as required by the
+// technique
+func (mc *MemoryClient) privescTargets(principal *Node, permission *Relationship, technique *graph.PrivescTechnique) (targets []*Node) {
+	for _, on := range mc.out(permission.To, "ON") {
+		if deniedOn(permission, on) {
+			continue
+		}
+		candidates := []*Node{on.To}
+		if technique.Relation == graph.LambdaRole {
+			if !on.To.HasLabel("Lambda") {
+				continue
+			}
+			candidates = nil
+			for _, uses := range mc.out(on.To, "USES") {
+				candidates = append(candidates, uses.To)
+			}
+		}
+		for _, target := range candidates {
+			if target.hasLabels(technique.Target) && mc.privescRelated(principal, target, technique) {
+				targets = append(targets, target)
+			}
+		}
+	}
+	return
+}
+
+func (mc *MemoryClient) privescRelated(principal *Node, target *Node, technique *graph.PrivescTechnique) bool {
+	switch technique.Relation {
+	case graph.AttachedPolicy:
+		holders := []*Node{principal}
+		for _, r := range mc.out(principal, "MEMBER_OF") {
+			holders = append(holders, r.To)
+		}
+		return slices.ContainsFunc(holders, func(holder *Node) bool { return mc.hasRelationship(holder, "HAS_POLICY", target) })
+	case graph.Self:
+		return target == principal
+	case graph.OwnGroup:
+		return mc.hasRelationship(principal, "MEMBER_OF", target)
+	case graph.SelfOrAssumable:
+		return target == principal || mc.hasRelationship(principal, "CAN_ASSUME", target)
+	case graph.OtherGroup:
+		return principal.HasLabel("User") && !mc.hasRelationship(principal, "MEMBER_OF", target)
+	case graph.Other, graph.LambdaRole:
+		return target != principal
+	case graph.Passable:
+		service := mc.findNode([]string{"AWSService"}, map[string]interface{}{"Name": graph.ServicePrincipal(technique.PassTo)})
+		return service != nil && mc.hasRelationship(service, "CAN_ASSUME", target)
+	}
+	return false
+}
+
+// Materialize CAN_PRIVESC relationships from the effective permissions of each principal: it must run once all
+// the accounts and resources are imported, and it rebuilds them from scratch. The relationship is conditional only
+// if all the ways are conditional
+func (mc *MemoryClient) AddPrivescEdges() {
+	mc.deleteRelationships("CAN_PRIVESC")
+	for i := range graph.PrivescTechniques {
+		technique := &graph.PrivescTechniques[i]
+		service, action, _ := strings.Cut(technique.Action, ":")
+
+		for _, principal := range mc.principals() {
+			// For each other permission: whether the principal has it and whether it has it unconditionally
+			hasAll, unconditional := true, true
+			for _, permission := range technique.Permissions {
+				service, action, _ := strings.Cut(permission, ":")
+				found, always := false, false
+				for _, r := range mc.out(principal, "HAS_PERMISSION") {
+					if r.To.String("Service") == service && r.To.String("Action") == action {
+						found = true
+						always = always || r.Properties["Conditional"] == false
+					}
+				}
+				hasAll, unconditional = hasAll && found, unconditional && always
+			}
+			if !hasAll {
+				continue
+			}
+
+			ways := make(map[*Node][]bool)
+			var order []*Node
+			for _, permission := range mc.out(principal, "HAS_PERMISSION") {
+				if permission.To.String("Service") != service || permission.To.String("Action") != action {
+					continue
+				}
+				conditional := permission.Properties["Conditional"] == true || !unconditional
+				for _, target := range mc.privescTargets(principal, permission, technique) {
+					if _, ok := ways[target]; !ok {
+						order = append(order, target)
+					}
+					ways[target] = append(ways[target], conditional)
+				}
+			}
+
+			for _, target := range order {
+				r := mc.upsertRelationship(principal, "CAN_PRIVESC", map[string]interface{}{"Technique": technique.Name}, target)
+				r.Properties["Unbounded"] = technique.Unbounded
+				r.Properties["Conditional"] = !slices.Contains(ways[target], false)
+			}
+		}
+	}
+}
@@ -0,0 +1,480 @@
+package memory_connector
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/primait/nuvola/pkg/connector/services/graph"
+
+	"github.com/primait/nuvola/tools/yamler"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// Evaluate a rule as the query built by yamler.PrepareQuery: the records of services and privilege escalation
+// rules, the properties of the distinct nodes found by the others
+func (mc *MemoryClient) EvaluateRule(rule *yamler.Conf) ([]map[string]interface{}, error) {
+	var found []*Node
+	switch {
+	case rule.Cypher != "":
+		return nil, ErrCypher
+	case len(rule.Services) > 0:
+		return mc.projectServices(rule, mc.evaluateServices(rule)), nil
+	case len(rule.Find.Target) > 0:
+		return mc.evaluatePrivEsc(rule), nil
+	case len(rule.Find.With) > 0:
+		found = mc.evaluatePaths(rule)
+	default:
+		return nil, fmt.Errorf("rule %s is not supported by the in-memory store: expected services, find.with or find.target", rule.Name)
+	}
+
+	results := make([]map[string]interface{}, 0, len(found))
+	for _, n := range found {
+		results = append(results, n.Properties)
+	}
+	return results, nil
+}
+
+func (mc *MemoryClient) evaluateServices(rule *yamler.Conf) (found []*Node) {
+	labels := make([]string, len(rule.Services))
+	for i, service := range rule.Services {
+		labels[i] = cases.Title(language.Und).String(service)
+	}
+	predicate, err := yamler.ParsePredicate(rule.Properties)
+	if err != nil {
+		mc.logger.Error("Malformed properties: check the rule with `nuvola rules validate`", "rule", rule.Name, "err", err)
+	}
+
+	now := time.Now()
+	for _, n := range mc.byLabel["Service"] {
+		if slices.ContainsFunc(labels, n.HasLabel) && n.matches(&predicate, now) {
+			found = append(found, n)
+		}
+	}
+	return
+}
+
+// The Arn of each node with the values selected by return, ordered by Arn
+func (mc *MemoryClient) projectServices(rule *yamler.Conf, found []*Node) []map[string]interface{} {
+	projections, err := yamler.ParseReturn(rule.Return)
+	if err != nil {
+		mc.logger.Error("Malformed rule: check it with `nuvola rules validate`", "rule", rule.Name, "err", err)
+	}
+	slices.SortStableFunc(found, func(a, b *Node) int {
+		return strings.Compare(a.String(yamler.IdentifierColumn), b.String(yamler.IdentifierColumn))
+	})
+
+	records := make([]map[string]interface{}, 0, len(found))
+	for _, n := range found {
+		records = append(records, map[string]interface{}{
+			yamler.IdentifierColumn: n.Properties[yamler.IdentifierColumn],
+			yamler.ValuesColumn:     yamler.Project(projections, n.Properties),
+		})
+	}
+	return records
+}
+
+// The predicate holds as the condition of yamler.PrepareQuery on the node
+func (n *Node) matches(p *yamler.Predicate, now time.Time) bool {
+	switch p.Operator {
+	case yamler.AllOf, yamler.Not:
+		all := true
+		for i := range p.Predicates {
+			all = all && n.matches(&p.Predicates[i], now)
+		}
+		return all != (p.Operator == yamler.Not)
+	case yamler.AnyOf:
+		for i := range p.Predicates {
+			if n.matches(&p.Predicates[i], now) {
+				return true
+			}
+		}
+		return false
+	case yamler.OpExists:
+		return n.hasProperty(p.Key, func(interface{}) bool { return true }) == p.Value
+	}
+	return n.hasProperty(p.Key, func(value interface{}) bool { return compare(p, value, now) })
+}
+
+// The property key or one of its nested properties key_..., case insensitive, has a value satisfying match
+func (n *Node) hasProperty(key string, match func(value interface{}) bool) bool {
+	key = strings.ToLower(key)
+	for prop, current := range n.Properties {
+		prop = strings.ToLower(prop)
+		if (prop == key || strings.HasPrefix(prop, key+"_")) && match(current) {
+			return true
+		}
+	}
+	return false
+}
+
+// Comparisons as Cypher does them: values of different types are not comparable, so they do not match
+func compare(p *yamler.Predicate, value interface{}, now time.Time) bool {
+	switch p.Operator {
+	case yamler.OpEq:
+		return equalValues(value, p.Value)
+	case yamler.OpIn:
+		return slices.ContainsFunc(p.Value.([]interface{}), func(item interface{}) bool { return equalValues(value, item) })
+	case yamler.OpRegex:
+		s, ok := value.(string)
+		return ok && regexp.MustCompile("^(?:"+p.Value.(string)+")$").MatchString(s)
+	case yamler.OpGt, yamler.OpGte, yamler.OpLt, yamler.OpLte:
+		x, ok := toFloat(value)
+		y, _ := toFloat(p.Value)
+		return ok && (p.Operator == yamler.OpGt && x > y || p.Operator == yamler.OpGte && x >= y ||
+			p.Operator == yamler.OpLt && x < y || p.Operator == yamler.OpLte && x <= y)
+	case yamler.OpOlderThan, yamler.OpNewerThan:
+		date, ok := toTime(value)
+		cutoff := yamler.Cutoff(p.Value.(time.Duration), now)
+		return ok && (p.Operator == yamler.OpOlderThan && date.Before(cutoff) || p.Operator == yamler.OpNewerThan && date.After(cutoff))
+	}
+	return false
+}
+
+// Dates of the dumps: RFC 3339, or with the offset without colon of Lambda (2023-06-10T17:30:20.000+0000)
+var dateLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.000-0700", "2006-01-02T15:04:05-0700", time.DateOnly}
+
+func toTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		for _, layout := range dateLayouts {
+			if date, err := time.Parse(layout, v); err == nil {
+				return date, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// For each principal ("who") with all the permissions in find.with, the nodes of the paths granting them:
+// (who)-[:MEMBER_OF*0..1]->()-[:HAS_POLICY]->(:Policy)-[:ALLOWS]->(:Action), when the Action is also linked with
+// HAS_PERMISSION. A rule with a target also requires a path from the principal to one of the targets
+func (mc *MemoryClient) evaluatePaths(rule *yamler.Conf) (found []*Node) {
+	seen := make(map[*Node]bool)
+	mc.matchWho(rule, func(who *Node, granted [][]*Relationship) {
+		for _, path := range granted {
+			for _, n := range pathNodes(who, path) {
+				if !seen[n] {
+					seen[n] = true
+					found = append(found, n)
+				}
+			}
+		}
+	})
+	return
+}
+
+// Each principal with one of its paths to a target, ordered by principal and length of the path
+func (mc *MemoryClient) evaluatePrivEsc(rule *yamler.Conf) (records []map[string]interface{}) {
+	mc.matchWho(rule, func(who *Node, _ [][]*Relationship) {
+		for _, path := range mc.targetPaths(who, rule) {
+			records = append(records, map[string]interface{}{yamler.PrincipalColumn: who.Properties, yamler.PathColumn: toPath(who, path)})
+		}
+	})
+	slices.SortStableFunc(records, func(a, b map[string]interface{}) int {
+		arnA, _ := a[yamler.PrincipalColumn].(map[string]interface{})["Arn"].(string)
+		arnB, _ := b[yamler.PrincipalColumn].(map[string]interface{})["Arn"].(string)
+		if c := strings.Compare(arnA, arnB); c != 0 {
+			return c
+		}
+		return len(a[yamler.PathColumn].(graph.Path).Relationships) - len(b[yamler.PathColumn].(graph.Path).Relationships)
+	})
+	return records
+}
+
+// The paths of a privilege escalation rule, as the query of yamler.PreparePathsQuery: the paths granting the
+// permissions and all the shortest paths to the targets
+func (mc *MemoryClient) EvaluateRulePaths(rule *yamler.Conf) (paths []graph.Path) {
+	if len(rule.Find.Target) == 0 {
+		return nil
+	}
+	mc.matchWho(rule, func(who *Node, granted [][]*Relationship) {
+		for _, path := range append(granted, mc.targetPaths(who, rule)...) {
+			paths = append(paths, toPath(who, path))
+		}
+	})
+	return
+}
+
+// Call match for each principal matching the rule with the paths granting the permissions in find.with
+func (mc *MemoryClient) matchWho(rule *yamler.Conf, match func(who *Node, granted [][]*Relationship)) {
+	whoLabels := make([]string, len(rule.Find.Who))
+	for i, who := range rule.Find.Who {
+		whoLabels[i] = cases.Title(language.Und).String(who)
+	}
+
+	for _, who := range mc.nodes {
+		if len(whoLabels) > 0 && !slices.ContainsFunc(whoLabels, who.HasLabel) {
+			continue
+		}
+
+		var granted [][]*Relationship
+		for _, permission := range rule.Find.With {
+			service, action, _ := strings.Cut(permission, ":")
+			paths := mc.permissionPaths(who, service, action, rule.Find.ExcludeConditional())
+			if len(paths) == 0 {
+				granted = nil
+				break
+			}
+			granted = append(granted, paths...)
+		}
+		if len(granted) == 0 {
+			continue
+		}
+		if len(rule.Find.Target) > 0 && !mc.reachesTarget(who, rule) {
+			continue
+		}
+		match(who, granted)
+	}
+}
+
+// All the paths granting the effective permission to the principal
+func (mc *MemoryClient) permissionPaths(who *Node, service, action string, excludeConditional bool) (paths [][]*Relationship) {
+	holders := [][]*Relationship{{}}
+	for _, r := range mc.out(who, "MEMBER_OF") {
+		holders = append(holders, []*Relationship{r})
+	}
+
+	for _, holder := range holders {
+		for _, hasPolicy := range mc.out(pathNodes(who, holder)[len(holder)], "HAS_POLICY") {
+			if !hasPolicy.To.HasLabel("Policy") {
+				continue
+			}
+			for _, allows := range mc.out(hasPolicy.To, "ALLOWS") {
+				a := allows.To
+				if !a.HasLabel("Action") || a.String("Service") != service || a.String("Action") != action || !mc.effective(who, a, excludeConditional) {
+					continue
+				}
+				paths = append(paths, append(slices.Clone(holder), hasPolicy, allows))
+			}
+		}
+	}
+	return
+}
+
+func pathNodes(start *Node, path []*Relationship) []*Node {
+	nodes := []*Node{start}
+	for _, r := range path {
+		nodes = append(nodes, r.To)
+	}
+	return nodes
+}
+
+func toPath(start *Node, path []*Relationship) graph.Path {
+	result := graph.Path{}
+	for _, n := range pathNodes(start, path) {
+		result.Nodes = append(result.Nodes, graph.PathNode{ID: fmt.Sprint(n.ID), Labels: n.Labels, Properties: n.Properties})
+	}
+	for _, r := range path {
+		result.Relationships = append(result.Relationships, graph.PathRelationship{
+			Type:       r.Type,
+			From:       fmt.Sprint(r.From.ID),
+			To:         fmt.Sprint(r.To.ID),
+			Properties: r.Properties,
+		})
+	}
+	return result
+}
+
+func (mc *MemoryClient) effective(who *Node, action *Node, excludeConditional bool) bool {
+	for _, r := range mc.relationships[relationshipKey(who, "HAS_PERMISSION", action)] {
+		if !excludeConditional || r.Properties["Conditional"] == false {
+			return true
+		}
+	}
+	return false
+}
+
+// The nodes matching one of the targets of find.target, as the condition of yamler.PrepareQuery
+func (mc *MemoryClient) targetMatcher(rule *yamler.Conf) func(n *Node) bool {
+	targets, err := rule.Find.Targets()
+	if err != nil {
+		mc.logger.Error("Malformed target: check the rule with `nuvola rules validate`", "rule", rule.Name, "err", err)
+	}
+	now := time.Now()
+	return func(n *Node) bool {
+		return slices.ContainsFunc(targets, func(t yamler.Target) bool { return n.isTarget(&t, now) })
+	}
+}
+
+// A target has the label and all the criteria set: the name, the Arn, the tags and the predicate of the properties
+func (n *Node) isTarget(t *yamler.Target, now time.Time) bool {
+	if !n.HasLabel(t.Label) {
+		return false
+	}
+	if t.Name != "" && !slices.ContainsFunc(yamler.TargetProperties[t.Label], func(key string) bool { return equalValues(n.Properties[key], t.Name) }) {
+		return false
+	}
+	if t.Arn != "" && n.String("Arn") != t.Arn {
+		return false
+	}
+	for key, value := range t.Tags {
+		if !n.hasTag(key, value) {
+			return false
+		}
+	}
+	return t.Predicate == nil || n.matches(t.Predicate, now)
+}
+
+var tagKey = regexp.MustCompile("^(?:" + yamler.TagKeyPattern + ")$")
+
+// The flattened tags are pairs of properties: Tags_0_Key and Tags_0_Value
+func (n *Node) hasTag(key, value string) bool {
+	for prop, current := range n.Properties {
+		if tagKey.MatchString(prop) && current == key && n.Properties[strings.TrimSuffix(prop, "Key")+"Value"] == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the relationship is walked to the targets of the rule
+func walks(rule *yamler.Conf, r *Relationship) bool {
+	return rule.Find.Walks(r.Type) && !(rule.Find.ExcludeConditional() && r.Properties["Conditional"] == true)
+}
+
+// A permission does not reach with ON the resources denied to the principal by a resource scoped Deny
+func deniedOn(permission *Relationship, on *Relationship) bool {
+	if permission == nil || permission.Type != "HAS_PERMISSION" || on.Type != "ON" {
+		return false
+	}
+	return slices.Contains(graph.ToStringList(permission.Properties["DeniedResources"]), on.To.String("Arn"))
+}
+
+// A node reached by the walks to the targets: the Actions reached through a permission with denied resources are
+// walked apart, as the resources they reach depend on the permission
+type step struct {
+	node       *Node
+	permission *Relationship
+}
+
+func nextStep(r *Relationship) step {
+	if r.Type == "HAS_PERMISSION" && len(graph.ToStringList(r.Properties["DeniedResources"])) > 0 {
+		return step{node: r.To, permission: r}
+	}
+	return step{node: r.To}
+}
+
+// The relationships walked from a step to the targets of the rule
+func (mc *MemoryClient) walked(rule *yamler.Conf, from step) (relationships []*Relationship) {
+	for _, r := range mc.outgoing[from.node] {
+		if walks(rule, r) && !deniedOn(from.permission, r) {
+			relationships = append(relationships, r)
+		}
+	}
+	return
+}
+
+// Breadth-first search of a target within find.max_depth relationships, as (who)-[*1..max_depth]->(target)
+func (mc *MemoryClient) reachesTarget(who *Node, rule *yamler.Conf) bool {
+	isTarget := mc.targetMatcher(rule)
+	visited := map[step]bool{{node: who}: true}
+	frontier := []step{{node: who}}
+	for depth := 0; depth < rule.Find.Depth() && len(frontier) > 0; depth++ {
+		var next []step
+		for _, s := range frontier {
+			for _, r := range mc.walked(rule, s) {
+				if isTarget(r.To) {
+					return true
+				}
+				if to := nextStep(r); !visited[to] {
+					visited[to] = true
+					next = append(next, to)
+				}
+			}
+		}
+		frontier = next
+	}
+	return false
+}
+
+// The paths to the targets: all the shortest paths to each target, or every simple path with find.paths: all
+func (mc *MemoryClient) targetPaths(who *Node, rule *yamler.Conf) [][]*Relationship {
+	if rule.Find.AllPaths() {
+		return mc.simplePaths(who, rule)
+	}
+	return mc.shortestPaths(who, rule)
+}
+
+// Like reachesTarget, the paths to each target found at its minimum depth, as allShortestPaths
+func (mc *MemoryClient) shortestPaths(who *Node, rule *yamler.Conf) (paths [][]*Relationship) {
+	type parent struct {
+		from step
+		r    *Relationship
+	}
+	isTarget := mc.targetMatcher(rule)
+	start := step{node: who}
+	depths := map[step]int{start: 0}
+	parents := make(map[step][]parent)
+	targets := make(map[*Node]int)
+	var reached []step
+	frontier := []step{start}
+	for depth := 0; depth < rule.Find.Depth() && len(frontier) > 0; depth++ {
+		var next []step
+		for _, s := range frontier {
+			for _, r := range mc.walked(rule, s) {
+				to := nextStep(r)
+				if d, ok := depths[to]; !ok {
+					depths[to] = depth + 1
+					next = append(next, to)
+					if _, found := targets[r.To]; isTarget(r.To) && !found {
+						targets[r.To] = depth + 1
+					}
+					if isTarget(r.To) && targets[r.To] == depth+1 {
+						reached = append(reached, to)
+					}
+				} else if d != depth+1 {
+					continue
+				}
+				parents[to] = append(parents[to], parent{from: s, r: r})
+			}
+		}
+		frontier = next
+	}
+
+	var walk func(s step, suffix []*Relationship)
+	walk = func(s step, suffix []*Relationship) {
+		if s == start {
+			paths = append(paths, suffix)
+			return
+		}
+		for _, p := range parents[s] {
+			walk(p.from, append([]*Relationship{p.r}, suffix...))
+		}
+	}
+	for _, s := range reached {
+		walk(s, nil)
+	}
+	return
+}
+
+// Depth-first search of every path to a target within find.max_depth relationships, without visiting a node twice
+func (mc *MemoryClient) simplePaths(who *Node, rule *yamler.Conf) (paths [][]*Relationship) {
+	isTarget := mc.targetMatcher(rule)
+	visited := map[*Node]bool{who: true}
+	var walk func(s step, path []*Relationship)
+	walk = func(s step, path []*Relationship) {
+		if len(path) == rule.Find.Depth() {
+			return
+		}
+		for _, r := range mc.walked(rule, s) {
+			if visited[r.To] {
+				continue
+			}
+			next := append(slices.Clone(path), r)
+			if isTarget(r.To) {
+				paths = append(paths, next)
+			}
+			visited[r.To] = true
+			walk(nextStep(r), next)
+			visited[r.To] = false
+		}
+	}
+	walk(step{node: who}, nil)
+	return
+}
@@ -0,0 +1,55 @@
+package memory_connector
+
+import (
+	"slices"
+
+	"github.com/primait/nuvola/pkg/connector/services/graph"
+)
+
+// Remove what disappeared from the account since the previous snapshots: its nodes not seen in the current one
+// and the relationships not seen again where their owner was. Nodes of other accounts are left alone.
+// It returns the number of nodes and relationships removed
+func (mc *MemoryClient) RemoveStale(accountID string) int {
+	var staleRelationships []*Relationship
+	for _, n := range mc.nodes {
+		for _, r := range mc.outgoing[n] {
+			if mc.seen(r.Properties) {
+				continue
+			}
+			if (slices.Contains(graph.SourceOwned, r.Type) && mc.seen(r.From.Properties)) ||
+				(slices.Contains(graph.TargetOwned, r.Type) && mc.seen(r.To.Properties)) ||
+				(slices.Contains(graph.BothOwned, r.Type) && mc.seen(r.From.Properties) && mc.seen(r.To.Properties)) {
+				staleRelationships = append(staleRelationships, r)
+			}
+		}
+	}
+	for _, r := range staleRelationships {
+		mc.deleteRelationship(r)
+	}
+
+	// Action nodes can be shared between accounts by AWS managed policies: they are removed with their policy
+	var staleNodes []*Node
+	for _, n := range mc.nodes {
+		if n.Properties["AccountId"] == accountID && !mc.seen(n.Properties) && !n.HasLabel("Action") {
+			staleNodes = append(staleNodes, n)
+		}
+	}
+	for _, n := range staleNodes {
+		mc.deleteNode(n)
+	}
+
+	orphans := 0
+	for _, n := range slices.Clone(mc.byLabel["Attached"]) {
+		if n.HasLabel("Policy") && n.Properties["AccountId"] == "aws" && len(mc.in(n, "HAS_POLICY", "HAS_BOUNDARY")) == 0 {
+			mc.deleteNode(n)
+			orphans++
+		}
+	}
+	for _, action := range slices.Clone(mc.byLabel["Action"]) {
+		if len(mc.in(action, "ALLOWS", "DENIES")) == 0 {
+			mc.deleteNode(action)
+			orphans++
+		}
+	}
+	return len(staleRelationships) + len(staleNodes) + orphans
+}
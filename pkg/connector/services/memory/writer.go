@@ -0,0 +1,839 @@
+package memory_connector
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	awsconfig "github.com/primait/nuvola/pkg/connector/services/aws"
+	"github.com/primait/nuvola/pkg/connector/services/aws/catalog"
+	servicesDatabase "github.com/primait/nuvola/pkg/connector/services/aws/database"
+	servicesEC2 "github.com/primait/nuvola/pkg/connector/services/aws/ec2"
+	servicesIAM "github.com/primait/nuvola/pkg/connector/services/aws/iam"
+	servicesLambda "github.com/primait/nuvola/pkg/connector/services/aws/lambda"
+	servicesOrganizations "github.com/primait/nuvola/pkg/connector/services/aws/organizations"
+	servicesS3 "github.com/primait/nuvola/pkg/connector/services/aws/s3"
+	"github.com/primait/nuvola/pkg/connector/services/graph"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+func objects(flat map[string]interface{}) []map[string]interface{} {
+	return flat["objects"].([]map[string]interface{})
+}
+
+func (mc *MemoryClient) AddOrganization(org *servicesOrganizations.Organization) {
+	for _, root := range objects(graph.FlatObjects(org.Roots)) {
+		mc.upsertNode([]string{"OrganizationalUnit", "Root"}, map[string]interface{}{"Id": root["Id"]}, root)
+	}
+	for _, ou := range objects(graph.FlatObjects(org.OrganizationalUnits)) {
+		o := mc.upsertNode([]string{"OrganizationalUnit"}, map[string]interface{}{"Id": ou["Id"]}, ou)
+		parent, _ := mc.mergeNode([]string{"OrganizationalUnit"}, map[string]interface{}{"Id": ou["ParentId"]})
+		mc.upsertRelationship(o, "CHILD_OF", nil, parent)
+	}
+	for _, account := range objects(graph.FlatObjects(org.Accounts)) {
+		a := mc.upsertNode([]string{"Account"}, map[string]interface{}{"Id": account["Id"]}, account)
+		mc.removeLabel(a, "ExternalAccount")
+		parent, _ := mc.mergeNode([]string{"OrganizationalUnit"}, map[string]interface{}{"Id": account["ParentId"]})
+		mc.upsertRelationship(a, "CHILD_OF", nil, parent)
+	}
+	// SCPs do not affect the management account
+	for _, a := range mc.findNodes([]string{"Account"}, map[string]interface{}{"Id": aws.ToString(org.MasterAccountId)}) {
+		a.Properties["Management"] = true
+	}
+
+	for _, policy := range org.Policies {
+		scp := mc.upsertNode([]string{"SCP", "Policy"}, map[string]interface{}{"Id": aws.ToString(policy.Id)}, map[string]interface{}{
+			"Name":        aws.ToString(policy.Name),
+			"Arn":         aws.ToString(policy.Arn),
+			"Description": aws.ToString(policy.Description),
+			"AwsManaged":  policy.AwsManaged,
+			"Type":        "scp",
+		})
+		for _, label := range []string{"Account", "OrganizationalUnit"} {
+			for _, target := range mc.byLabel[label] {
+				if slices.Contains(policy.Targets, target.String("Id")) {
+					mc.upsertRelationship(target, "HAS_SCP", nil, scp)
+				}
+			}
+		}
+		mc.createPolicyRelationships(scp, &policy.Document.Statement, nil)
+	}
+}
+
+func (mc *MemoryClient) AddUsers(users *[]servicesIAM.User) {
+	for _, user := range *users {
+		u := mc.createUser(user)
+		for _, inlinePolicy := range user.InlinePolicies {
+			policy := mc.createPolicy(u, "", inlinePolicy.PolicyName, "inline")
+			pol := inlinePolicy
+			mc.createPolicyRelationships(policy, &pol.Statement, servicesIAM.PrincipalVariables(aws.ToString(user.UserName), aws.ToString(user.UserId), user.Tags))
+		}
+
+		for _, attachedPolicy := range user.AttachedPolicies {
+			policy := mc.createPolicy(u, *attachedPolicy.PolicyArn, *attachedPolicy.PolicyName, "attached")
+			mc.createPolicyRelationships(policy, &attachedPolicy.Versions[0].Document.Statement, nil)
+		}
+
+		if boundary := user.BoundaryPolicy; boundary != nil {
+			policy := mc.createPolicy(u, *boundary.PolicyArn, *boundary.PolicyName, "boundary")
+			mc.createPolicyRelationships(policy, &boundary.Versions[0].Document.Statement, nil)
+		}
+	}
+}
+
+func (mc *MemoryClient) AddGroups(groups *[]servicesIAM.Group) {
+	for _, group := range *groups {
+		g := mc.upsertNode([]string{"IAM", "Group"}, map[string]interface{}{"Arn": aws.ToString(group.Arn)}, map[string]interface{}{
+			"GroupName":  aws.ToString(group.GroupName),
+			"CreateDate": fmt.Sprint(group.CreateDate),
+			"Path":       aws.ToString(group.Path),
+			"GroupId":    aws.ToString(group.GroupId),
+		})
+		for _, inlinePolicy := range group.InlinePolicies {
+			policy := mc.createPolicy(g, "", inlinePolicy.PolicyName, "inline")
+			pol := inlinePolicy
+			mc.createPolicyRelationships(policy, &pol.Statement, nil)
+		}
+
+		for _, attachedPolicy := range group.AttachedPolicies {
+			policy := mc.createPolicy(g, *attachedPolicy.PolicyArn, *attachedPolicy.PolicyName, "attached")
+			mc.createPolicyRelationships(policy, &attachedPolicy.Versions[0].Document.Statement, nil)
+		}
+	}
+}
+
+func (mc *MemoryClient) AddRoles(roles *[]servicesIAM.Role) {
+	for _, role := range *roles {
+		r := mc.createRole(role)
+		for _, inlinePolicy := range role.InlinePolicies {
+			policy := mc.createPolicy(r, "", inlinePolicy.PolicyName, "inline")
+			pol := inlinePolicy
+			// aws:username and aws:userid of a role depend on the session
+			mc.createPolicyRelationships(policy, &pol.Statement, servicesIAM.PrincipalVariables("", "", role.Tags))
+		}
+
+		for _, attachedPolicy := range role.AttachedPolicies {
+			policy := mc.createPolicy(r, *attachedPolicy.PolicyArn, *attachedPolicy.PolicyName, "attached")
+			mc.createPolicyRelationships(policy, &attachedPolicy.Versions[0].Document.Statement, nil)
+		}
+
+		if boundary := role.BoundaryPolicy; boundary != nil {
+			policy := mc.createPolicy(r, *boundary.PolicyArn, *boundary.PolicyName, "boundary")
+			mc.createPolicyRelationships(policy, &boundary.Versions[0].Document.Statement, nil)
+		}
+	}
+
+	// Trust policies can reference other roles of the account: link them once all the roles exist
+	for i := range *roles {
+		mc.createPrincipalRelationships("Role", "CAN_ASSUME", nil, graph.ParseTrustPolicy(&(*roles)[i]))
+	}
+}
+
+func (mc *MemoryClient) createUser(user servicesIAM.User) *Node {
+	var groupNames interface{}
+	for _, group := range user.Groups {
+		names, _ := groupNames.([]string)
+		groupNames = append(names, aws.ToString(group.GroupName))
+	}
+	u := mc.upsertNode([]string{"IAM", "User"}, map[string]interface{}{"Arn": aws.ToString(user.Arn)}, map[string]interface{}{
+		"UserName":            aws.ToString(user.UserName),
+		"UserId":              aws.ToString(user.UserId),
+		"PasswordEnabled":     user.PasswordEnabled,
+		"PasswordLastChanged": user.PasswordLastChanged,
+		"MFAStatus":           user.MfaActive,
+		"Groups":              groupNames,
+	})
+
+	for _, group := range user.Groups {
+		if g := mc.findNode([]string{"Group"}, map[string]interface{}{"Arn": aws.ToString(group.Arn)}); g != nil {
+			mc.upsertRelationship(u, "MEMBER_OF", nil, g)
+		}
+	}
+	return u
+}
+
+func (mc *MemoryClient) createRole(role servicesIAM.Role) *Node {
+	// Properties set to nil are removed: the instance profile can be detached between two snapshots
+	properties := map[string]interface{}{
+		"RoleName":             aws.ToString(role.RoleName),
+		"Path":                 aws.ToString(role.Path),
+		"Description":          role.Description,
+		"RoleId":               aws.ToString(role.RoleId),
+		"AssumableBy":          role.AssumableBy,
+		"InstanceProfileArn":   nil,
+		"IamInstanceProfileId": nil,
+	}
+	if role.InstanceProfileID != "" {
+		properties["InstanceProfileArn"] = role.InstanceProfileArn
+		properties["IamInstanceProfileId"] = role.InstanceProfileID
+	}
+	r := mc.upsertNode([]string{"IAM", "Role"}, map[string]interface{}{"Arn": aws.ToString(role.Arn)}, properties)
+	if role.InstanceProfileID != "" {
+		mc.addLabel(r, "InstanceProfile")
+	} else {
+		mc.removeLabel(r, "InstanceProfile")
+	}
+	return r
+}
+
+// Attached policies are shared between the principals and merged on the Arn, inline ones are merged on the
+// name for each principal. Policies of users and roles are labeled IAM
+func (mc *MemoryClient) createPolicy(principal *Node, policyArn string, name string, policyType string) *Node {
+	relationship := "HAS_POLICY"
+	if policyType == "boundary" {
+		// A permissions boundary is a managed policy: the node is shared with the principals that have it attached
+		relationship, policyType = "HAS_BOUNDARY", "attached"
+	}
+	// Every policy is an IAM resource, also the ones of groups: the IAM actions on policies reach them with ON
+	labels := []string{"Policy", cases.Title(language.Und).String(policyType), "IAM"}
+	properties := map[string]interface{}{"Name": name, "Type": policyType}
+
+	var policy *Node
+	switch policyType {
+	case "attached":
+		policy = mc.upsertNode(labels, map[string]interface{}{"Arn": policyArn}, properties)
+	case "inline":
+		for _, r := range mc.out(principal, relationship) {
+			if r.To.hasLabels(labels) && hasProperties(r.To.Properties, properties) {
+				policy = r.To
+				break
+			}
+		}
+		if policy == nil {
+			policy = mc.createNode(labels, properties)
+		}
+		mc.stamp(policy.Properties)
+	}
+	mc.upsertRelationship(principal, relationship, nil, policy)
+	return policy
+}
+
+// Link the policy to an Action node for each allowed or denied action, as in Neo4j: statements with a different
+// evaluation of their conditions are kept on different relationships
+func (mc *MemoryClient) createPolicyRelationships(policy *Node, statements *[]servicesIAM.Statement, variables map[string]string) {
+	actions := graph.ParseStatements(fmt.Sprint(policy.ID), statements, variables, &mc.resources)
+	for key, relationship := range map[string]string{"allows": "ALLOWS", "denies": "DENIES"} {
+		for _, item := range actions[key].([]map[string]interface{}) {
+			action := mc.policyAction(fmt.Sprint(policy.ID), relationship, item["condition"].(string), item["service"].(string), item["action"].(string))
+			if action == nil {
+				action = mc.createNode([]string{"Action"}, map[string]interface{}{"Action": item["action"], "Service": item["service"]})
+				mc.policyActions[policyActionKey(fmt.Sprint(policy.ID), relationship, item["condition"].(string), item["service"].(string), item["action"].(string))] = action
+			}
+			r := mc.mergeRelationship(policy, relationship, map[string]interface{}{"Condition": item["condition"]}, action)
+
+			// The lists merged from several statements restart on the first statement of a new snapshot
+			previous := map[string]interface{}{}
+			if mc.seen(r.Properties) {
+				previous = r.Properties
+			}
+			r.Properties["Conditional"] = item["condition"] != "always-true"
+			r.Properties["ConditionKeys"] = appendUnique(previous["ConditionKeys"], item["conditionKeys"].([]string))
+			r.Properties["Conditions"] = appendUnique(previous["Conditions"], item["conditions"].([]string))
+			if relationship == "DENIES" {
+				// The list of resources is kept on the relationship to distinguish a total Deny from a resource scoped one:
+				// the resources matched by a scoped Deny are listed in DeniedResources once they are imported
+				r.Properties["Resources"] = appendUnique(previous["Resources"], item["resources"].([]string))
+				r.Properties["DeniedResources"] = appendUnique(previous["DeniedResources"], nil)
+			}
+			mc.stamp(r.Properties)
+			mc.stamp(action.Properties)
+		}
+	}
+}
+
+func policyActionKey(policy, relationship, condition, service, action string) string {
+	return strings.Join([]string{policy, relationship, condition, service, action}, "|")
+}
+
+func (mc *MemoryClient) policyAction(policy, relationship, condition, service, action string) *Node {
+	return mc.policyActions[policyActionKey(policy, relationship, condition, service, action)]
+}
+
+func appendUnique(current interface{}, values []string) []string {
+	list, _ := current.([]string)
+	if list == nil {
+		list = make([]string, 0)
+	}
+	for _, value := range values {
+		if !slices.Contains(list, value) {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+// Link the principals granted by a trust or resource-based policy to the resource with the given label and Arn:
+// the properties in keys identify the relationship, as CAN {Action, Condition}
+func (mc *MemoryClient) createPrincipalRelationships(label string, relationship string, keys []string, grants map[string]interface{}) {
+	link := func(from *Node, to *Node, properties map[string]interface{}) *Relationship {
+		identity := make(map[string]interface{}, len(keys))
+		for _, key := range keys {
+			identity[key] = properties[key]
+		}
+		r := mc.upsertRelationship(from, relationship, identity, to)
+		maps.Copy(r.Properties, properties)
+		return r
+	}
+
+	// Known users and roles are linked directly; the root of an account, unknown principals and principals of
+	// accounts not (yet) imported are linked through the Account node, labeled ExternalAccount until imported.
+	// "*" is everyone, also unauthenticated for resource-based policies: a single node shared by all the accounts
+	for _, grant := range grants["aws"].([]map[string]interface{}) {
+		properties := grant["properties"].(map[string]interface{})
+		for _, target := range mc.findNodes([]string{label}, map[string]interface{}{"Arn": grant["target"]}) {
+			var principal *Node
+			for _, candidate := range mc.findNodes([]string{"IAM"}, map[string]interface{}{"Arn": grant["principal"]}) {
+				if candidate.HasLabel("User") || candidate.HasLabel("Role") {
+					principal = candidate
+					break
+				}
+			}
+			switch {
+			case principal != nil:
+				link(principal, target, properties)
+			case grant["account"] != "*":
+				account, created := mc.mergeNode([]string{"Account"}, map[string]interface{}{"Id": grant["account"]})
+				if created {
+					mc.addLabel(account, "ExternalAccount")
+				}
+				link(account, target, properties).Properties["Principal"] = grant["principal"]
+			}
+			if grant["account"] == "*" {
+				anyone, _ := mc.mergeNode([]string{"AnyAWSPrincipal", "Anonymous"}, map[string]interface{}{"Id": "*"})
+				link(anyone, target, properties)
+			}
+		}
+	}
+	for kind, nodeLabel := range map[string]string{"services": "AWSService", "federated": "FederatedProvider"} {
+		for _, grant := range grants[kind].([]map[string]interface{}) {
+			for _, target := range mc.findNodes([]string{label}, map[string]interface{}{"Arn": grant["target"]}) {
+				principal := mc.upsertNode([]string{nodeLabel}, map[string]interface{}{"Name": grant["principal"]}, nil)
+				link(principal, target, grant["properties"].(map[string]interface{}))
+			}
+		}
+	}
+}
+
+// Upsert the nodes of a service, one for each flattened object with its Arn, with the Service label
+func (mc *MemoryClient) addServiceNodes(label string, flat map[string]interface{}) {
+	for _, object := range objects(flat) {
+		mc.upsertNode([]string{label, "Service"}, map[string]interface{}{"Arn": object["Arn"]}, object)
+	}
+}
+
+// Link the Actions of all the imported policies to the resources of a service matching their Resource element
+func (mc *MemoryClient) addLinksToResources(service string, arns []string) {
+	label := cases.Title(language.Und).String(service)
+	resources := make(map[string][]*Node)
+	for _, n := range mc.byLabel[label] {
+		if n.HasLabel("Service") {
+			resources[n.String("Arn")] = append(resources[n.String("Arn")], n)
+		}
+	}
+
+	for _, link := range mc.resources.Match(service, arns, func(ar *graph.ActionResource) bool {
+		return !strings.Contains(ar.Action, "Create")
+	}) {
+		for _, resource := range resources[link["arn"]] {
+			mc.linkResource(link, resource)
+		}
+	}
+}
+
+// The Actions allowed on a resource are linked to it with ON, while the resources matched by a Deny are listed on
+// the DENIES relationship: they are subtracted from the permissions of the principals holding the policy
+func (mc *MemoryClient) linkResource(link map[string]string, resource *Node) {
+	action := mc.policyAction(link["policy"], link["relationship"], link["condition"], link["service"], link["action"])
+	if action == nil {
+		return
+	}
+	if link["relationship"] == "ALLOWS" {
+		mc.upsertRelationship(action, "ON", nil, resource)
+		return
+	}
+	for _, r := range mc.in(action, "DENIES") {
+		if fmt.Sprint(r.From.ID) == link["policy"] && r.Properties["Condition"] == link["condition"] {
+			r.Properties["DeniedResources"] = appendUnique(r.Properties["DeniedResources"], []string{resource.String("Arn")})
+		}
+	}
+}
+
+func (mc *MemoryClient) AddLinksToResourcesIAM() {
+	// An IAM or STS Action applies only to the type of resource defined for it; roles are matched also by instance
+	// profile
+	for _, principal := range mc.byLabel["IAM"] {
+		principalArn, ok := principal.Properties["Arn"].(string)
+		if !ok {
+			continue
+		}
+		arns := []string{principalArn}
+		if instanceProfileArn := principal.String("InstanceProfileArn"); instanceProfileArn != "" {
+			arns = append(arns, instanceProfileArn)
+		}
+		for _, service := range slices.Sorted(maps.Keys(awsconfig.IdentityActionResourceMaps)) {
+			for _, link := range mc.resources.Match(service, arns, func(ar *graph.ActionResource) bool {
+				resourceType := awsconfig.IdentityActionResourceMaps[service][ar.Action]
+				return resourceType == "*" || (resourceType != "" && principal.HasLabel(resourceType))
+			}) {
+				mc.linkResource(link, principal)
+			}
+		}
+	}
+}
+
+// Resolve Allow and Deny statements of all the inline, attached and group policies of each principal:
+// an allowed Action is linked with HAS_PERMISSION only if it is not explicitly denied on every resource
+// and, when the principal has a permissions boundary or SCPs apply to its account, only if they allow it too.
+// The resources denied to the principal by the others are listed in DeniedResources: ON never reaches them
+func (mc *MemoryClient) AddEffectivePermissions() {
+	principals := mc.principals()
+	// The account ID is the 5th field of the ARN
+	for _, principal := range principals {
+		fields := strings.Split(principal.String("Arn"), ":")
+		if len(fields) < 5 {
+			continue
+		}
+		for _, account := range mc.findNodes([]string{"Account"}, map[string]interface{}{"Id": fields[4]}) {
+			mc.upsertRelationship(principal, "BELONGS_TO", nil, account)
+		}
+	}
+
+	// Permissions are derived from the whole graph: they are rebuilt from scratch, also for the other accounts
+	mc.deleteRelationships("HAS_PERMISSION")
+
+	scps := make(map[*Node]*policyActions)
+	for _, principal := range principals {
+		// (principal)-[:MEMBER_OF*0..1]->()
+		holders := []*Node{principal}
+		for _, r := range mc.out(principal, "MEMBER_OF") {
+			holders = append(holders, r.To)
+		}
+
+		denied := newPolicyActions()
+		var allows []*Relationship
+		for _, holder := range holders {
+			for _, hasPolicy := range mc.out(holder, "HAS_POLICY", "HAS_BOUNDARY") {
+				denied.addDenies(mc, hasPolicy.To)
+				if hasPolicy.Type == "HAS_POLICY" {
+					allows = append(allows, mc.out(hasPolicy.To, "ALLOWS")...)
+				}
+			}
+		}
+
+		var boundary *policyActions
+		if boundaries := mc.out(principal, "HAS_BOUNDARY"); len(boundaries) > 0 {
+			boundary = newPolicyActions()
+			for _, r := range boundaries {
+				boundary.addAllows(mc, r.To)
+			}
+		}
+
+		// SCPs are evaluated on every level from the account up to the Root: each level must allow the action.
+		// Service-linked roles and the management account are not affected by SCPs.
+		var levels [][]*policyActions
+		if !strings.HasPrefix(principal.String("Path"), "/aws-service-role/") {
+			for _, level := range mc.organizationLevels(principal) {
+				var levelSCPs []*policyActions
+				for _, r := range mc.out(level, "HAS_SCP") {
+					if !r.To.HasLabel("SCP") {
+						continue
+					}
+					if scps[r.To] == nil {
+						scps[r.To] = newPolicyActions()
+						scps[r.To].addAllows(mc, r.To)
+						scps[r.To].addDenies(mc, r.To)
+					}
+					levelSCPs = append(levelSCPs, scps[r.To])
+				}
+				levels = append(levels, levelSCPs)
+			}
+		}
+
+		// Allows whose conditions can never match are ignored, while Denies are applied only when their conditions
+		// always match: a conditional permission is kept and marked as such on HAS_PERMISSION
+		for _, allow := range allows {
+			if allow.Properties["Condition"] == "always-false" {
+				continue
+			}
+			key := actionKey(allow.To)
+			if denied.denied[key] || (boundary != nil && !boundary.allowed[key]) || !levelsAllow(levels, key) {
+				continue
+			}
+			permission := mc.upsertRelationship(principal, "HAS_PERMISSION", nil, allow.To)
+			// The permission is conditional also when a Deny, the boundary or a level of SCPs depends on the
+			// context of the request: the keys of all their conditions are merged
+			conditional := allow.Properties["Conditional"] == true
+			conditionKeys := appendUnique(nil, graph.ToStringList(allow.Properties["ConditionKeys"]))
+			addConditions := func(contextDependent bool, keys []string) {
+				if contextDependent {
+					conditional, conditionKeys = true, appendUnique(conditionKeys, keys)
+				}
+			}
+			addConditions(denied.conditionallyDenied[key], denied.denyKeys[key])
+			if boundary != nil {
+				addConditions(!boundary.alwaysAllowed[key], boundary.allowKeys[key])
+			}
+			deniedResources := appendUnique(permission.Properties["DeniedResources"], denied.deniedResources[key])
+			for _, level := range levels {
+				levelConditional, levelKeys := len(level) > 0, []string{}
+				for _, scp := range level {
+					addConditions(scp.conditionallyDenied[key], scp.denyKeys[key])
+					levelConditional = levelConditional && !scp.alwaysAllowed[key]
+					levelKeys = appendUnique(levelKeys, scp.allowKeys[key])
+					deniedResources = appendUnique(deniedResources, scp.deniedResources[key])
+				}
+				addConditions(levelConditional, levelKeys)
+			}
+			permission.Properties["Conditional"] = conditional
+			permission.Properties["ConditionKeys"] = conditionKeys
+			permission.Properties["DeniedResources"] = deniedResources
+		}
+	}
+
+	mc.addAccountAssumes(principals)
+}
+
+// A role trusting an account can be assumed only by the principals of the account allowed to by their own
+// permissions: they are linked to the role with a CAN_ASSUME relationship holding the Account, rebuilt as well.
+// It is conditional only if all the ways are conditional
+func (mc *MemoryClient) addAccountAssumes(principals []*Node) {
+	for _, n := range mc.nodes {
+		for _, r := range mc.out(n, "CAN_ASSUME") {
+			if r.Properties["Account"] != nil {
+				mc.deleteRelationship(r)
+			}
+		}
+	}
+
+	for _, principal := range principals {
+		for _, belongsTo := range mc.out(principal, "BELONGS_TO") {
+			account := belongsTo.To
+			ways := make(map[*Node][]bool)
+			keys := make(map[*Node][]string)
+			var order []*Node
+			for _, trust := range mc.out(account, "CAN_ASSUME") {
+				role := trust.To
+				if !role.HasLabel("Role") || trust.Properties["Condition"] == "always-false" ||
+					!slices.Contains([]interface{}{nil, "", account.Properties["Id"]}, trust.Properties["Principal"]) {
+					continue
+				}
+				for _, permission := range mc.out(principal, "HAS_PERMISSION") {
+					if permission.To.String("Service") != "sts" || permission.To.String("Action") != "AssumeRole" ||
+						!mc.hasRelationship(permission.To, "ON", role) ||
+						slices.Contains(graph.ToStringList(permission.Properties["DeniedResources"]), role.String("Arn")) {
+						continue
+					}
+					if _, ok := ways[role]; !ok {
+						order = append(order, role)
+					}
+					ways[role] = append(ways[role], trust.Properties["Conditional"] == true || permission.Properties["Conditional"] == true)
+					keys[role] = appendUnique(keys[role], graph.ToStringList(trust.Properties["ConditionKeys"]))
+					keys[role] = appendUnique(keys[role], graph.ToStringList(permission.Properties["ConditionKeys"]))
+				}
+			}
+
+			for _, role := range order {
+				r := mc.upsertRelationship(principal, "CAN_ASSUME", map[string]interface{}{"Account": account.Properties["Id"]}, role)
+				r.Properties["Conditional"] = !slices.Contains(ways[role], false)
+				r.Properties["ConditionKeys"] = keys[role]
+			}
+		}
+	}
+}
+
+// Allowed and totally denied actions of a set of policies, and the resources they are denied on, by "service:Action".
+// The actions allowed only by context-dependent statements, or denied by one of them, keep the keys of their conditions
+type policyActions struct {
+	allowed             map[string]bool
+	alwaysAllowed       map[string]bool
+	allowKeys           map[string][]string
+	denied              map[string]bool
+	conditionallyDenied map[string]bool
+	denyKeys            map[string][]string
+	deniedResources     map[string][]string
+}
+
+func newPolicyActions() *policyActions {
+	return &policyActions{
+		allowed:             make(map[string]bool),
+		alwaysAllowed:       make(map[string]bool),
+		allowKeys:           make(map[string][]string),
+		denied:              make(map[string]bool),
+		conditionallyDenied: make(map[string]bool),
+		denyKeys:            make(map[string][]string),
+		deniedResources:     make(map[string][]string),
+	}
+}
+
+func actionKey(action *Node) string {
+	return action.String("Service") + ":" + action.String("Action")
+}
+
+func (pa *policyActions) addAllows(mc *MemoryClient, policy *Node) {
+	for _, r := range mc.out(policy, "ALLOWS") {
+		key := actionKey(r.To)
+		switch r.Properties["Condition"] {
+		case "always-false":
+		case "always-true":
+			pa.allowed[key], pa.alwaysAllowed[key] = true, true
+		default:
+			pa.allowed[key] = true
+			pa.allowKeys[key] = appendUnique(pa.allowKeys[key], graph.ToStringList(r.Properties["ConditionKeys"]))
+		}
+	}
+}
+
+func (pa *policyActions) addDenies(mc *MemoryClient, policy *Node) {
+	for _, r := range mc.out(policy, "DENIES") {
+		key := actionKey(r.To)
+		switch r.Properties["Condition"] {
+		case "always-false":
+		case "always-true":
+			if slices.Contains(graph.ToStringList(r.Properties["Resources"]), "*") {
+				pa.denied[key] = true
+			}
+			pa.deniedResources[key] = appendUnique(pa.deniedResources[key], graph.ToStringList(r.Properties["DeniedResources"]))
+		default:
+			pa.conditionallyDenied[key] = true
+			pa.denyKeys[key] = appendUnique(pa.denyKeys[key], graph.ToStringList(r.Properties["ConditionKeys"]))
+		}
+	}
+}
+
+func levelsAllow(levels [][]*policyActions, key string) bool {
+	for _, level := range levels {
+		if len(level) == 0 {
+			continue
+		}
+		allowed := false
+		for _, scp := range level {
+			if scp.denied[key] {
+				return false
+			}
+			allowed = allowed || scp.allowed[key]
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// The account of the principal and all its parents up to the Root, unless it is the management account
+func (mc *MemoryClient) organizationLevels(principal *Node) (levels []*Node) {
+	visited := make(map[*Node]bool)
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if visited[n] {
+			return
+		}
+		visited[n] = true
+		levels = append(levels, n)
+		for _, r := range mc.out(n, "CHILD_OF") {
+			walk(r.To)
+		}
+	}
+	for _, r := range mc.out(principal, "BELONGS_TO") {
+		if r.To.HasLabel("Account") && r.To.Properties["Management"] == nil {
+			walk(r.To)
+		}
+	}
+	return
+}
+
+// The account being imported is no longer external, even if other accounts referenced it before.
+// Partition and account are used to build the ARN of the resources imported next
+func (mc *MemoryClient) AddAccount(partition string, accountID string) {
+	mc.partition, mc.accountID = partition, accountID
+	account := mc.upsertNode([]string{"Account"}, map[string]interface{}{"Id": accountID}, nil)
+	mc.removeLabel(account, "ExternalAccount")
+}
+
+// Record on the account the catalog that expanded the actions of its policies
+func (mc *MemoryClient) SetCatalog(accountID string, info catalog.Info) {
+	mc.upsertNode([]string{"Account"}, map[string]interface{}{"Id": accountID},
+		map[string]interface{}{"CatalogVersion": info.Version, "CatalogUpdated": info.Updated, "CatalogSource": info.Source})
+}
+
+// Set the AccountId property on every node still without one: AWS managed policies, services and
+// public principals belong to "aws", Account nodes and external VPCs to their own account
+func (mc *MemoryClient) SetAccountID(accountID string) {
+	for _, n := range mc.nodes {
+		if _, ok := n.Properties["AccountId"]; ok {
+			continue
+		}
+		switch {
+		case n.HasLabel("Account"):
+			n.Properties["AccountId"] = n.Properties["Id"]
+		case strings.HasPrefix(n.String("Arn"), "arn:aws:iam::aws:") || n.HasLabel("AWSService") || n.HasLabel("AnyAWSPrincipal"):
+			n.Properties["AccountId"] = "aws"
+		case n.HasLabel("Vpc") && n.Properties["OwnerId"] != nil:
+			n.Properties["AccountId"] = n.Properties["OwnerId"]
+		default:
+			n.Properties["AccountId"] = accountID
+		}
+	}
+}
+
+func (mc *MemoryClient) resourceARN(service, region, account, resource string) string {
+	return graph.ResourceARN(mc.partition, service, region, account, resource)
+}
+
+func (mc *MemoryClient) AddBuckets(buckets *[]servicesS3.Bucket) {
+	arns := make([]string, len(*buckets))
+	for i, bucket := range *buckets {
+		arns[i] = mc.resourceARN("s3", "", "", aws.ToString(bucket.Name))
+	}
+	mc.addServiceNodes("S3", graph.WithARNs(graph.FlatObjects(*buckets), arns))
+	for i, bucket := range *buckets {
+		mc.createPrincipalRelationships("S3", "CAN", []string{"Action", "Condition"}, graph.ParseResourcePolicy(arns[i], bucket.Policy))
+	}
+	mc.addLinksToResources("s3", arns)
+}
+
+func (mc *MemoryClient) AddEC2(instances *[]servicesEC2.Instance) {
+	arns := make([]string, len(*instances))
+	for i, instance := range *instances {
+		var region, owner = "", mc.accountID
+		if instance.Placement != nil {
+			region = strings.TrimRight(aws.ToString(instance.Placement.AvailabilityZone), "abcdefghijklmnopqrstuvwxyz")
+		}
+		if len(instance.NetworkInterfaces) > 0 && instance.NetworkInterfaces[0].OwnerId != nil {
+			owner = aws.ToString(instance.NetworkInterfaces[0].OwnerId)
+		}
+		arns[i] = mc.resourceARN("ec2", region, owner, "instance/"+aws.ToString(instance.InstanceId))
+	}
+	mc.addServiceNodes("Ec2", graph.WithARNs(graph.FlatObjects(*instances), arns))
+
+	for _, role := range mc.byLabel["Role"] {
+		if role.String("InstanceProfileArn") == "" {
+			continue
+		}
+		for _, instance := range mc.findNodes([]string{"Ec2", "Service"}, map[string]interface{}{"IamInstanceProfile_Arn": role.Properties["InstanceProfileArn"]}) {
+			mc.upsertRelationship(instance, "USES", nil, role)
+		}
+	}
+	mc.addLinksToResources("ec2", arns)
+}
+
+func (mc *MemoryClient) AddVPC(vpcs *servicesEC2.VPC) {
+	for _, object := range objects(graph.FlatObjects(vpcs.VPCs)) {
+		vpc := mc.upsertNode([]string{"Vpc", "Service"}, map[string]interface{}{"VpcId": object["VpcId"]}, object)
+		vpc.Properties["Type"] = "Internal"
+		for _, instance := range mc.findNodes([]string{"Ec2"}, map[string]interface{}{"VpcId": object["VpcId"]}) {
+			mc.upsertRelationship(instance, "NETWORK", nil, vpc)
+		}
+	}
+
+	for _, peering := range objects(graph.FlatObjects(vpcs.Peerings)) {
+		requester, _ := mc.mergeNode([]string{"Vpc"}, map[string]interface{}{"VpcId": peering["RequesterVpcInfo_VpcId"], "OwnerId": peering["RequesterVpcInfo_OwnerId"]})
+		accepter, _ := mc.mergeNode([]string{"Vpc"}, map[string]interface{}{"VpcId": peering["AccepterVpcInfo_VpcId"], "OwnerId": peering["AccepterVpcInfo_OwnerId"]})
+		for _, vpc := range []*Node{requester, accepter} {
+			if vpc.Properties["Type"] == nil {
+				vpc.Properties["Type"] = "External"
+			}
+		}
+		r := mc.upsertRelationship(requester, "PEERING", map[string]interface{}{"VpcPeeringConnectionId": peering["VpcPeeringConnectionId"]}, accepter)
+		maps.Copy(r.Properties, peering)
+	}
+}
+
+func (mc *MemoryClient) AddLambda(lambdas *[]servicesLambda.Lambda) {
+	arns := make([]string, len(*lambdas))
+	for i, lambda := range *lambdas {
+		arns[i] = aws.ToString(lambda.FunctionArn)
+	}
+	mc.addServiceNodes("Lambda", graph.WithARNs(graph.FlatObjects(*lambdas), arns))
+	for i, lambda := range *lambdas {
+		mc.createPrincipalRelationships("Lambda", "CAN", []string{"Action", "Condition"}, graph.ParseResourcePolicy(arns[i], lambda.Policy))
+	}
+
+	for _, role := range mc.byLabel["Role"] {
+		for _, function := range mc.findNodes([]string{"Lambda", "Service"}, map[string]interface{}{"Role": role.Properties["Arn"]}) {
+			mc.upsertRelationship(function, "USES", nil, role)
+		}
+	}
+	mc.linkVpcs("Lambda", "VpcConfig_VpcId")
+	mc.addLinksToResources("lambda", arns)
+}
+
+// Link the nodes with the label to the VPC with the ID in the property
+func (mc *MemoryClient) linkVpcs(label string, property string) {
+	for _, n := range mc.byLabel[label] {
+		vpcID := n.String(property)
+		if vpcID == "" {
+			continue
+		}
+		for _, vpc := range mc.findNodes([]string{"Vpc"}, map[string]interface{}{"VpcId": vpcID}) {
+			mc.upsertRelationship(n, "NETWORK", nil, vpc)
+		}
+	}
+}
+
+func (mc *MemoryClient) AddRDS(rdsdbs *servicesDatabase.RDS) {
+	clusterArns := make([]string, len(rdsdbs.Clusters))
+	for i, cluster := range rdsdbs.Clusters {
+		clusterArns[i] = aws.ToString(cluster.DBClusterArn)
+	}
+	instanceArns := make([]string, len(rdsdbs.Instances))
+	for i, instance := range rdsdbs.Instances {
+		instanceArns[i] = aws.ToString(instance.DBInstanceArn)
+	}
+
+	mc.addServiceNodes("Rds", graph.WithARNs(graph.FlatObjects(rdsdbs.Clusters), clusterArns))
+	mc.addServiceNodes("Rds", graph.WithARNs(graph.FlatObjects(rdsdbs.Instances), instanceArns))
+	mc.addLinksToResources("rds", append(clusterArns, instanceArns...))
+}
+
+func (mc *MemoryClient) AddDynamoDB(dynamodbs *[]servicesDatabase.DynamoDB) {
+	arns := make([]string, len(*dynamodbs))
+	for i, table := range *dynamodbs {
+		arns[i] = mc.resourceARN("dynamodb", table.Region, mc.accountID, "table/"+table.Name)
+	}
+	mc.addServiceNodes("Dynamodb", graph.WithARNs(graph.FlatObjects(*dynamodbs), arns))
+	mc.addLinksToResources("dynamodb", arns)
+}
+
+func (mc *MemoryClient) AddRedshift(redshifts *[]servicesDatabase.RedshiftDB) {
+	arns := make([]string, len(*redshifts))
+	for i, cluster := range *redshifts {
+		// The namespace ARN has the same partition, region and account of the cluster
+		namespace, err := arn.Parse(aws.ToString(cluster.ClusterNamespaceArn))
+		if err != nil {
+			namespace = arn.ARN{Region: strings.TrimRight(aws.ToString(cluster.AvailabilityZone), "abcdefghijklmnopqrstuvwxyz"), AccountID: mc.accountID}
+		}
+		arns[i] = mc.resourceARN("redshift", namespace.Region, namespace.AccountID, "cluster:"+aws.ToString(cluster.ClusterIdentifier))
+	}
+	mc.addServiceNodes("Redshift", graph.WithARNs(graph.FlatObjects(*redshifts), arns))
+	mc.addLinksToResources("redshift", arns)
+	mc.linkVpcs("Redshift", "VpcId")
+}
+
+// Add nodes and relationships as they are, e.g. the graph snippet of a rule fixture: relationships reference
+// the nodes by their ID in the snippet
+func (mc *MemoryClient) AddGraph(nodes []graph.PathNode, relationships []graph.PathRelationship) error {
+	byID := make(map[string]*Node, len(nodes))
+	for _, n := range nodes {
+		if len(n.Labels) == 0 {
+			return fmt.Errorf("node %q without labels", n.ID)
+		}
+		if _, ok := byID[n.ID]; ok {
+			return fmt.Errorf("duplicated node %q", n.ID)
+		}
+		node := mc.createNode(n.Labels, n.Properties)
+		mc.stamp(node.Properties)
+		byID[n.ID] = node
+	}
+	for _, r := range relationships {
+		from, to := byID[r.From], byID[r.To]
+		if from == nil || to == nil {
+			return fmt.Errorf("relationship %s from %q to %q: unknown node", r.Type, r.From, r.To)
+		}
+		mc.upsertRelationship(from, r.Type, r.Properties, to)
+	}
+	return nil
+}
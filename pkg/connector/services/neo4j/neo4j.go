@@ -2,19 +2,25 @@ package neo4j_connector
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
 	"github.com/neo4j/neo4j-go-driver/v5/neo4j/config"
 	"github.com/neo4j/neo4j-go-driver/v5/neo4j/dbtype"
 	"github.com/neo4j/neo4j-go-driver/v5/neo4j/log"
+	"github.com/primait/nuvola/pkg/connector/services/graph"
 	"github.com/primait/nuvola/pkg/io/logging"
 )
 
 type Neo4jClient struct {
-	Driver neo4j.DriverWithContext
-	err    error
-	logger logging.LogManager
+	Driver    neo4j.DriverWithContext
+	err       error
+	logger    logging.LogManager
+	partition string
+	accountID string
+	resources graph.ActionResources
+	snapshot  map[string]interface{}
 }
 
 var logLevel = log.Level(log.WARNING)
@@ -54,19 +60,41 @@ func (nc *Neo4jClient) DeleteAll() {
 	session.Run(context.TODO(), `call apoc.periodic.commit("MATCH (n) WITH n LIMIT $limit DETACH DELETE n RETURN count(*)", {limit:20000})`, nil) // #nosec G104
 	session.Run(context.TODO(), "CALL apoc.schema.assert({},{})", nil)                                                                            // #nosec G104
 	session.Run(context.TODO(), "CALL apoc.trigger.removeAll()", nil)                                                                             // #nosec G104
+	nc.CreateSchema()
+}
+
+// Create the constraints and the indexes missing, keeping the data: nodes are merged on the unique properties
+//
+//nolint:all
+func (nc *Neo4jClient) CreateSchema() {
+	session := nc.NewSession()
+	defer func() {
+		if err := session.Close(context.TODO()); err != nil {
+			nc.logger.Error("failed to close session: %v", err)
+		}
+	}()
+
+	// Redshift clusters and DynamoDB tables are merged on Arn: their names are reused across accounts and regions
+	nc.dropConstraints(session, map[string]string{"Redshift": "ClusterIdentifier", "Dynamodb": "Name"})
 
 	// UNIQUE also create an index
-	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (u:User) ASSERT u.Arn IS UNIQUE", nil)                   // #nosec G104
-	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (r:Role) ASSERT r.Arn IS UNIQUE", nil)                   // #nosec G104
-	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (g:Group) ASSERT g.Arn IS UNIQUE", nil)                  // #nosec G104
-	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (e:Ec2) ASSERT e.InstanceId IS UNIQUE", nil)             // #nosec G104
-	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (b:S3) ASSERT b.Name IS UNIQUE", nil)                    // #nosec G104
-	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (l:Lambda) ASSERT l.FunctionArn IS UNIQUE", nil)         // #nosec G104
-	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (v:Vpc) ASSERT v.VpcId IS UNIQUE", nil)                  // #nosec G104
-	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (r:Redshift) ASSERT r.ClusterIdentifier IS UNIQUE", nil) // #nosec G104
-	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (d:Dynamodb) ASSERT d.Name IS UNIQUE", nil)              // #nosec G104
-	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (r:Rds) ASSERT r.DBClusterArn IS UNIQUE", nil)           // #nosec G104
-	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (r:Rds) ASSERT r.DBInstanceArn IS UNIQUE", nil)          // #nosec G104
+	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (u:User) ASSERT u.Arn IS UNIQUE", nil)           // #nosec G104
+	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (r:Role) ASSERT r.Arn IS UNIQUE", nil)           // #nosec G104
+	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (g:Group) ASSERT g.Arn IS UNIQUE", nil)          // #nosec G104
+	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (e:Ec2) ASSERT e.InstanceId IS UNIQUE", nil)     // #nosec G104
+	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (b:S3) ASSERT b.Name IS UNIQUE", nil)            // #nosec G104
+	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (l:Lambda) ASSERT l.FunctionArn IS UNIQUE", nil) // #nosec G104
+	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (v:Vpc) ASSERT v.VpcId IS UNIQUE", nil)          // #nosec G104
+	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (r:Redshift) ASSERT r.Arn IS UNIQUE", nil)       // #nosec G104
+	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (d:Dynamodb) ASSERT d.Arn IS UNIQUE", nil)       // #nosec G104
+	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (r:Rds) ASSERT r.DBClusterArn IS UNIQUE", nil)   // #nosec G104
+	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (r:Rds) ASSERT r.DBInstanceArn IS UNIQUE", nil)  // #nosec G104
+
+	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (a:Account) ASSERT a.Id IS UNIQUE", nil)             // #nosec G104
+	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (o:OrganizationalUnit) ASSERT o.Id IS UNIQUE", nil)  // #nosec G104
+	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (s:SCP) ASSERT s.Id IS UNIQUE", nil)                 // #nosec G104
+	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (s:AWSService) ASSERT s.Name IS UNIQUE", nil)        // #nosec G104
+	session.Run(context.TODO(), "CREATE CONSTRAINT IF NOT EXISTS ON (f:FederatedProvider) ASSERT f.Name IS UNIQUE", nil) // #nosec G104
 
 	session.Run(context.TODO(), "CREATE INDEX index_User IF NOT EXISTS FOR (u:User) ON u.UserName", nil) // #nosec G104
 
@@ -84,12 +112,37 @@ func (nc *Neo4jClient) DeleteAll() {
 	session.Run(context.TODO(), "CREATE INDEX index_VpcOwner IF NOT EXISTS FOR (v:Vpc) ON v.OwnerId", nil) // #nosec G104
 	session.Run(context.TODO(), "CREATE INDEX index_VpcType IF NOT EXISTS FOR (v:Vpc) ON v.Type", nil)     // #nosec G104
 
+	session.Run(context.TODO(), "CREATE INDEX index_ServiceArn IF NOT EXISTS FOR (s:Service) ON s.Arn", nil) // #nosec G104
+
 	session.Run(context.TODO(), "CREATE INDEX index_LambdaRole IF NOT EXISTS FOR (l:Lambda) ON l.Role", nil) // #nosec G104
 
 	session.Run(context.TODO(), "CALL db.awaitIndexes(3000)", nil) // #nosec G104
 }
 
-func (nc *Neo4jClient) Query(query string, arguments map[string]interface{}) []map[string]interface{} {
+// Drop the unique constraints of the databases created by the previous versions on the properties of a label
+func (nc *Neo4jClient) dropConstraints(session neo4j.SessionWithContext, outdated map[string]string) {
+	for label, property := range outdated {
+		result, err := session.Run(context.TODO(), `SHOW CONSTRAINTS YIELD name, labelsOrTypes, properties
+			WHERE labelsOrTypes = [$label] AND properties = [$property]
+			RETURN name`, map[string]interface{}{"label": label, "property": property})
+		if err != nil {
+			nc.logger.Warn("Unable to list the constraints", "label", label, "err", err)
+			continue
+		}
+		records, err := result.Collect(context.TODO())
+		if err != nil {
+			nc.logger.Warn("Unable to list the constraints", "label", label, "err", err)
+			continue
+		}
+		for _, record := range records {
+			if name, ok := record.Get("name"); ok {
+				session.Run(context.TODO(), fmt.Sprintf("DROP CONSTRAINT `%v` IF EXISTS", name), nil) // #nosec G104
+			}
+		}
+	}
+}
+
+func (nc *Neo4jClient) Query(query string, arguments map[string]interface{}) ([]map[string]interface{}, error) {
 	session := nc.NewSession()
 	defer func() {
 		if err := session.Close(context.TODO()); err != nil {
@@ -124,8 +177,117 @@ func (nc *Neo4jClient) Query(query string, arguments map[string]interface{}) []m
 		return results, result.Err()
 	})
 	if err != nil {
-		nc.logger.Warn("Error on executing query", "err", err, "query", query, "arguments", arguments)
+		nc.logger.Debug("Error on executing query", "err", err, "query", query, "arguments", arguments)
+		return nil, fmt.Errorf("executing query: %w", err)
 	}
 
-	return results.([]map[string]interface{})
+	return results.([]map[string]interface{}), nil
+}
+
+// Run a read-only query and return its records by column: nodes and relationships are converted to their
+// properties and paths to graph.Path
+func (nc *Neo4jClient) QueryRecords(query string, arguments map[string]interface{}) ([]map[string]interface{}, error) {
+	session := nc.NewSession()
+	defer func() {
+		if err := session.Close(context.TODO()); err != nil {
+			nc.logger.Error("failed to close session: %v", err)
+		}
+	}()
+
+	records, err := session.ExecuteRead(context.TODO(), func(tx neo4j.ManagedTransaction) (any, error) {
+		result, err := tx.Run(context.TODO(), query, arguments)
+		if err != nil {
+			return nil, err
+		}
+
+		records := make([]map[string]interface{}, 0)
+		for result.Next(context.TODO()) {
+			record := result.Record()
+			columns := make(map[string]interface{}, len(record.Keys))
+			for i, key := range record.Keys {
+				columns[key] = recordValue(record.Values[i])
+			}
+			records = append(records, columns)
+		}
+		return records, result.Err()
+	})
+	if err != nil {
+		nc.logger.Debug("Error on executing query", "err", err, "query", query, "arguments", arguments)
+		return nil, fmt.Errorf("executing query: %w", err)
+	}
+
+	return records.([]map[string]interface{}), nil
+}
+
+func recordValue(value any) any {
+	switch v := value.(type) {
+	case dbtype.Node:
+		return v.Props
+	case dbtype.Relationship:
+		return v.Props
+	case dbtype.Path:
+		return toPath(&v)
+	case []any:
+		values := make([]any, len(v))
+		for i := range v {
+			values[i] = recordValue(v[i])
+		}
+		return values
+	case map[string]any:
+		values := make(map[string]any, len(v))
+		for key := range v {
+			values[key] = recordValue(v[key])
+		}
+		return values
+	}
+	return value
+}
+
+// Run a query returning paths: the values of the records which are not paths are ignored
+func (nc *Neo4jClient) QueryPaths(query string, arguments map[string]interface{}) ([]graph.Path, error) {
+	session := nc.NewSession()
+	defer func() {
+		if err := session.Close(context.TODO()); err != nil {
+			nc.logger.Error("failed to close session: %v", err)
+		}
+	}()
+
+	paths, err := session.ExecuteRead(context.TODO(), func(tx neo4j.ManagedTransaction) (any, error) {
+		result, err := tx.Run(context.TODO(), query, arguments)
+		if err != nil {
+			return nil, err
+		}
+
+		paths := make([]graph.Path, 0)
+		for result.Next(context.TODO()) {
+			for _, value := range result.Record().Values {
+				if path, ok := value.(dbtype.Path); ok {
+					paths = append(paths, toPath(&path))
+				}
+			}
+		}
+		return paths, result.Err()
+	})
+	if err != nil {
+		nc.logger.Debug("Error on executing query", "err", err, "query", query, "arguments", arguments)
+		return nil, fmt.Errorf("executing query: %w", err)
+	}
+
+	return paths.([]graph.Path), nil
+}
+
+func toPath(path *dbtype.Path) graph.Path {
+	result := graph.Path{}
+	for _, node := range path.Nodes {
+		result.Nodes = append(result.Nodes, graph.PathNode{ID: node.ElementId, Labels: node.Labels, Properties: node.Props})
+	}
+	for _, relationship := range path.Relationships {
+		result.Relationships = append(result.Relationships, graph.PathRelationship{
+			Type:       relationship.Type,
+			From:       relationship.StartElementId,
+			To:         relationship.EndElementId,
+			Properties: relationship.Props,
+		})
+	}
+	return result
 }
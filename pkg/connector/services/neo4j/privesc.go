@@ -0,0 +1,90 @@
+package neo4j_connector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/primait/nuvola/pkg/connector/services/graph"
+)
+
+// The pattern after the ON relationship, binding `target`, the filter relating it to the principal and the variable
+// of the resource reached with ON
+func privescPattern(technique *graph.PrivescTechnique) (target string, where string, resource string) {
+	target, resource = fmt.Sprintf("(target:%s)", strings.Join(technique.Target, ":")), "target"
+	switch technique.Relation {
+	case graph.AttachedPolicy:
+		where = "(principal)-[:MEMBER_OF*0..1]->()-[:HAS_POLICY]->(target)"
+	case graph.Self:
+		where = "target = principal"
+	case graph.OwnGroup:
+		where = "(principal)-[:MEMBER_OF]->(target)"
+	case graph.SelfOrAssumable:
+		where = "(target = principal OR (principal)-[:CAN_ASSUME]->(target))"
+	case graph.OtherGroup:
+		where = "principal:User AND NOT (principal)-[:MEMBER_OF]->(target)"
+	case graph.Other:
+		where = "target <> principal"
+	case graph.Passable:
+		where = fmt.Sprintf("(:AWSService {Name: '%s'})-[:CAN_ASSUME]->(target)", graph.ServicePrincipal(technique.PassTo))
+	case graph.LambdaRole:
+		target, resource = fmt.Sprintf("(lambda:Lambda)-[:USES]->%s", target), "lambda"
+		where = "target <> principal"
+	}
+	return
+}
+
+// Materialize CAN_PRIVESC relationships from the effective permissions of each principal: it must run once all
+// the accounts and resources are imported, and it rebuilds them from scratch. The relationship is conditional only
+// if all the ways are conditional
+func (nc *Neo4jClient) AddPrivescEdges() {
+	session := nc.NewSession()
+	defer func() {
+		if err := session.Close(context.TODO()); err != nil {
+			nc.logger.Error("failed to close session: %v", err)
+		}
+	}()
+
+	clearPrivesc := `CALL apoc.periodic.iterate("MATCH ()-[p:CAN_PRIVESC]->() RETURN p", "DELETE p", {batchSize:10000, iterateList:true})`
+	if _, err := session.Run(context.TODO(), clearPrivesc, nil); err != nil {
+		nc.logger.Error("Error on executing query", "err", err, "query", clearPrivesc)
+	}
+
+	for i := range graph.PrivescTechniques {
+		technique := &graph.PrivescTechniques[i]
+		permissions := make([]map[string]string, 0, len(technique.Permissions))
+		for _, permission := range technique.Permissions {
+			service, action, _ := strings.Cut(permission, ":")
+			permissions = append(permissions, map[string]string{"service": service, "action": action})
+		}
+		service, action, _ := strings.Cut(technique.Action, ":")
+		target, where, resource := privescPattern(technique)
+
+		query := fmt.Sprintf(`CALL apoc.periodic.iterate("
+			MATCH (principal:IAM) WHERE principal:User OR principal:Role
+			MATCH (principal)-[permission:HAS_PERMISSION]->(:Action {Service: $service, Action: $action})-[:ON]->%s
+			WHERE %s AND NOT coalesce(%s.Arn, '') IN coalesce(permission.DeniedResources, []) AND ALL(p IN $permissions WHERE EXISTS {
+				MATCH (principal)-[:HAS_PERMISSION]->(:Action {Service: p.service, Action: p.action})
+			})
+			WITH principal, target, coalesce(permission.Conditional, false) OR ANY(p IN $permissions WHERE NOT EXISTS {
+				MATCH (principal)-[:HAS_PERMISSION {Conditional: false}]->(:Action {Service: p.service, Action: p.action})
+			}) AS conditional
+			WITH principal, target, collect(conditional) AS ways
+			RETURN principal, target, ALL(c IN ways WHERE c) AS conditional",
+			"MERGE (principal)-[r:CAN_PRIVESC {Technique: $technique}]->(target)
+			SET r.Unbounded = $unbounded, r.Conditional = conditional, %s",
+			{batchSize:10000, iterateList:true, params: {service: $service, action: $action, permissions: $permissions, technique: $technique, unbounded: $unbounded, snapshot: $snapshot}})`,
+			target, where, resource, stamp("r"))
+		_, err := session.Run(context.TODO(), query, map[string]interface{}{
+			"service":     service,
+			"action":      action,
+			"permissions": permissions,
+			"technique":   technique.Name,
+			"unbounded":   technique.Unbounded,
+			"snapshot":    nc.snapshot,
+		})
+		if err != nil {
+			nc.logger.Error("Error on executing query", "err", err, "query", query)
+		}
+	}
+}
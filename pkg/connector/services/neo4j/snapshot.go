@@ -0,0 +1,97 @@
+package neo4j_connector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/primait/nuvola/pkg/connector/services/graph"
+)
+
+// Every node and relationship written from now on is marked with the snapshot ID and the time it was seen
+func (nc *Neo4jClient) SetSnapshot(snapshotID string, seen time.Time) {
+	nc.snapshot = map[string]interface{}{"id": snapshotID, "seen": seen}
+}
+
+// SET items marking the variables as seen in the current snapshot ($snapshot parameter)
+func stamp(variables ...string) string {
+	items := make([]string, 0, 2*len(variables))
+	for _, v := range variables {
+		items = append(items, fmt.Sprintf("%[1]s.SnapshotId = $snapshot.id, %[1]s.LastSeen = $snapshot.seen", v))
+	}
+	return strings.Join(items, ", ")
+}
+
+// Remove what disappeared from the account since the previous snapshots: its nodes not seen in the current one
+// and the relationships not seen again where their owner was. Nodes of other accounts are left alone.
+// It returns the number of nodes and relationships removed
+func (nc *Neo4jClient) RemoveStale(accountID string) int {
+	session := nc.NewSession()
+	defer func() {
+		if err := session.Close(context.TODO()); err != nil {
+			nc.logger.Error("failed to close session: %v", err)
+		}
+	}()
+
+	// Action nodes can be shared between accounts by AWS managed policies: they are removed with their policy
+	queries := []string{
+		`CALL apoc.periodic.iterate("
+			MATCH (source)-[r]->(target) WHERE coalesce(r.SnapshotId, '') <> $snapshot.id AND (
+				(type(r) IN $bySource AND source.SnapshotId = $snapshot.id) OR
+				(type(r) IN $byTarget AND target.SnapshotId = $snapshot.id) OR
+				(type(r) IN $byBoth AND source.SnapshotId = $snapshot.id AND target.SnapshotId = $snapshot.id))
+			RETURN r",
+			"DELETE r",
+			{batchSize:10000, iterateList:true, params: {snapshot: $snapshot, bySource: $bySource, byTarget: $byTarget, byBoth: $byBoth}})
+		YIELD total RETURN total`,
+		`CALL apoc.periodic.iterate("
+			MATCH (n) WHERE n.AccountId = $accountId AND coalesce(n.SnapshotId, '') <> $snapshot.id AND NOT n:Action
+			RETURN n",
+			"DETACH DELETE n",
+			{batchSize:10000, iterateList:true, params: {snapshot: $snapshot, accountId: $accountId}})
+		YIELD total RETURN total`,
+		`CALL apoc.periodic.iterate("
+			MATCH (p:Policy:Attached) WHERE p.AccountId = 'aws' AND NOT ()-[:HAS_POLICY|HAS_BOUNDARY]->(p)
+			RETURN p",
+			"DETACH DELETE p",
+			{batchSize:10000, iterateList:true})
+		YIELD total RETURN total`,
+		`CALL apoc.periodic.iterate("
+			MATCH (a:Action) WHERE NOT ()-[:ALLOWS|DENIES]->(a)
+			RETURN a",
+			"DETACH DELETE a",
+			{batchSize:10000, iterateList:true})
+		YIELD total RETURN total`,
+	}
+
+	arguments := map[string]interface{}{
+		"snapshot":  nc.snapshot,
+		"accountId": accountID,
+		"bySource":  graph.SourceOwned,
+		"byTarget":  graph.TargetOwned,
+		"byBoth":    graph.BothOwned,
+	}
+	removed := 0
+	for _, query := range queries {
+		total, err := session.ExecuteWrite(context.TODO(), func(tx neo4j.ManagedTransaction) (any, error) {
+			result, err := tx.Run(context.TODO(), query, arguments)
+			if err != nil {
+				return nil, err
+			}
+			record, err := result.Single(context.TODO())
+			if err != nil {
+				return nil, err
+			}
+			return record.Values[0], nil
+		})
+		if err != nil {
+			nc.logger.Error("Error on executing query", "err", err, "query", query)
+		}
+		if total, ok := total.(int64); ok {
+			removed += int(total)
+		}
+	}
+	return removed
+}
@@ -3,34 +3,118 @@ package neo4j_connector
 import (
 	"context"
 	"fmt"
+	"maps"
+	"slices"
 
 	awsconfig "github.com/primait/nuvola/pkg/connector/services/aws"
+	"github.com/primait/nuvola/pkg/connector/services/aws/catalog"
 	servicesDatabase "github.com/primait/nuvola/pkg/connector/services/aws/database"
 	servicesEC2 "github.com/primait/nuvola/pkg/connector/services/aws/ec2"
 	servicesIAM "github.com/primait/nuvola/pkg/connector/services/aws/iam"
 	servicesLambda "github.com/primait/nuvola/pkg/connector/services/aws/lambda"
+	servicesOrganizations "github.com/primait/nuvola/pkg/connector/services/aws/organizations"
 	servicesS3 "github.com/primait/nuvola/pkg/connector/services/aws/s3"
+	"github.com/primait/nuvola/pkg/connector/services/graph"
 
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
 	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
 
+func (nc *Neo4jClient) AddOrganization(org *servicesOrganizations.Organization) {
+	queryRoots := `UNWIND $objects AS root
+		MERGE (r:OrganizationalUnit {Id: root.Id})
+		SET r += root, r:Root, ` + stamp("r")
+
+	queryOUs := `UNWIND $objects AS ou
+		MERGE (o:OrganizationalUnit {Id: ou.Id})
+		SET o += ou, ` + stamp("o") + `
+		WITH o, ou
+		MERGE (parent:OrganizationalUnit {Id: ou.ParentId})
+		MERGE (o)-[c:CHILD_OF]->(parent)
+		SET ` + stamp("c")
+
+	queryAccounts := `UNWIND $objects AS account
+		MERGE (a:Account {Id: account.Id})
+		REMOVE a:ExternalAccount
+		SET a += account, ` + stamp("a") + `
+		WITH a, account
+		MERGE (parent:OrganizationalUnit {Id: account.ParentId})
+		MERGE (a)-[c:CHILD_OF]->(parent)
+		SET ` + stamp("c")
+
+	nc.AddObjects(graph.FlatObjects(org.Roots), queryRoots)
+	nc.AddObjects(graph.FlatObjects(org.OrganizationalUnits), queryOUs)
+	nc.AddObjects(graph.FlatObjects(org.Accounts), queryAccounts)
+	// SCPs do not affect the management account
+	nc.AddObjects(map[string]interface{}{"id": aws.ToString(org.MasterAccountId)}, `MATCH (a:Account {Id: $id}) SET a.Management = true`)
+
+	for _, policy := range org.Policies {
+		idPolicy := nc.createSCP(policy)
+		nc.createPolicyRelationships(idPolicy, &policy.Document.Statement, nil)
+	}
+}
+
+func (nc *Neo4jClient) createSCP(policy servicesOrganizations.ServiceControlPolicy) int64 {
+	session := nc.NewSession()
+	defer func() {
+		if err := session.Close(context.TODO()); err != nil {
+			nc.logger.Error("failed to close session: %v", err)
+		}
+	}()
+	query := `MERGE (scp:SCP:Policy {Id: $Id})
+		SET scp.Name = $Name, scp.Arn = $Arn, scp.Description = $Description, scp.AwsManaged = $AwsManaged, scp.Type = "scp", ` + stamp("scp") + `
+		WITH scp
+		OPTIONAL MATCH (target) WHERE (target:Account OR target:OrganizationalUnit) AND target.Id IN $Targets
+		FOREACH (t IN CASE WHEN target IS NULL THEN [] ELSE [target] END | MERGE (t)-[h:HAS_SCP]->(scp) SET ` + stamp("h") + `)
+		RETURN DISTINCT id(scp)`
+
+	idPolicy, err := session.ExecuteWrite(context.TODO(), func(tx neo4j.ManagedTransaction) (any, error) {
+		var result, err = tx.Run(context.TODO(), query, map[string]interface{}{
+			"Id":          aws.ToString(policy.Id),
+			"Name":        aws.ToString(policy.Name),
+			"Arn":         aws.ToString(policy.Arn),
+			"Description": aws.ToString(policy.Description),
+			"AwsManaged":  policy.AwsManaged,
+			"Targets":     policy.Targets,
+			"snapshot":    nc.snapshot,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		result.Next(context.TODO())
+		return result.Record().Values[0].(int64), result.Err()
+	})
+
+	if err != nil {
+		nc.logger.Error("Error on executing query", "err", err, "query", query, "arguments", policy)
+	}
+	return idPolicy.(int64)
+}
+
 func (nc *Neo4jClient) AddUsers(users *[]servicesIAM.User) {
 	for _, user := range *users {
 		idUser := nc.createUser(user)
 		for _, inlinePolicy := range user.InlinePolicies {
-			idPolicy := nc.createPolicyUser(idUser, "", inlinePolicy.PolicyName, "inline")
+			idPolicy := nc.createPolicy(idUser, "", inlinePolicy.PolicyName, "inline")
 			pol := inlinePolicy
-			nc.createPolicyRelationships(idPolicy, &pol.Statement, *user.UserName)
+			nc.createPolicyRelationships(idPolicy, &pol.Statement, servicesIAM.PrincipalVariables(aws.ToString(user.UserName), aws.ToString(user.UserId), user.Tags))
 		}
 
 		for _, attachedPolicy := range user.AttachedPolicies {
-			idPolicy := nc.createPolicyUser(idUser, *attachedPolicy.PolicyArn, *attachedPolicy.PolicyName, "attached")
-			nc.createPolicyRelationships(idPolicy, &attachedPolicy.Versions[0].Document.Statement, *user.UserName)
+			idPolicy := nc.createPolicy(idUser, *attachedPolicy.PolicyArn, *attachedPolicy.PolicyName, "attached")
+			nc.createPolicyRelationships(idPolicy, &attachedPolicy.Versions[0].Document.Statement, nil)
+		}
+
+		if boundary := user.BoundaryPolicy; boundary != nil {
+			idPolicy := nc.createPolicy(idUser, *boundary.PolicyArn, *boundary.PolicyName, "boundary")
+			nc.createPolicyRelationships(idPolicy, &boundary.Versions[0].Document.Statement, nil)
 		}
 	}
 }
@@ -39,14 +123,14 @@ func (nc *Neo4jClient) AddGroups(groups *[]servicesIAM.Group) {
 	for _, group := range *groups {
 		idGroup := nc.createGroup(group)
 		for _, inlinePolicy := range group.InlinePolicies {
-			idPolicy := nc.createPolicyGroup(idGroup, "", inlinePolicy.PolicyName, "inline")
+			idPolicy := nc.createPolicy(idGroup, "", inlinePolicy.PolicyName, "inline")
 			pol := inlinePolicy
-			nc.createPolicyRelationships(idPolicy, &pol.Statement, *group.GroupName)
+			nc.createPolicyRelationships(idPolicy, &pol.Statement, nil)
 		}
 
 		for _, attachedPolicy := range group.AttachedPolicies {
-			idPolicy := nc.createPolicyGroup(idGroup, *attachedPolicy.PolicyArn, *attachedPolicy.PolicyName, "attached")
-			nc.createPolicyRelationships(idPolicy, &attachedPolicy.Versions[0].Document.Statement, *group.GroupName)
+			idPolicy := nc.createPolicy(idGroup, *attachedPolicy.PolicyArn, *attachedPolicy.PolicyName, "attached")
+			nc.createPolicyRelationships(idPolicy, &attachedPolicy.Versions[0].Document.Statement, nil)
 		}
 	}
 }
@@ -55,15 +139,26 @@ func (nc *Neo4jClient) AddRoles(roles *[]servicesIAM.Role) {
 	for _, role := range *roles {
 		idRole := nc.createRole(role)
 		for _, inlinePolicy := range role.InlinePolicies {
-			idPolicy := nc.createPolicyRole(idRole, "", inlinePolicy.PolicyName, "inline")
+			idPolicy := nc.createPolicy(idRole, "", inlinePolicy.PolicyName, "inline")
 			pol := inlinePolicy
-			nc.createPolicyRelationships(idPolicy, &pol.Statement, *role.RoleName)
+			// aws:username and aws:userid of a role depend on the session
+			nc.createPolicyRelationships(idPolicy, &pol.Statement, servicesIAM.PrincipalVariables("", "", role.Tags))
 		}
 
 		for _, attachedPolicy := range role.AttachedPolicies {
-			idPolicy := nc.createPolicyRole(idRole, *attachedPolicy.PolicyArn, *attachedPolicy.PolicyName, "attached")
-			nc.createPolicyRelationships(idPolicy, &attachedPolicy.Versions[0].Document.Statement, *role.RoleName)
+			idPolicy := nc.createPolicy(idRole, *attachedPolicy.PolicyArn, *attachedPolicy.PolicyName, "attached")
+			nc.createPolicyRelationships(idPolicy, &attachedPolicy.Versions[0].Document.Statement, nil)
 		}
+
+		if boundary := role.BoundaryPolicy; boundary != nil {
+			idPolicy := nc.createPolicy(idRole, *boundary.PolicyArn, *boundary.PolicyName, "boundary")
+			nc.createPolicyRelationships(idPolicy, &boundary.Versions[0].Document.Statement, nil)
+		}
+	}
+
+	// Trust policies can reference other roles of the account: link them once all the roles exist
+	for i := range *roles {
+		nc.createPrincipalRelationships("Role", "CAN_ASSUME", graph.ParseTrustPolicy(&(*roles)[i]))
 	}
 }
 
@@ -74,7 +169,9 @@ func (nc *Neo4jClient) createGroup(group servicesIAM.Group) int64 {
 			nc.logger.Error("failed to close session: %v", err)
 		}
 	}()
-	query := `MERGE (g:IAM:Group {GroupName: $GroupName, CreateDate: $CreateDate, Arn: $Arn, Path: $Path, GroupId: $GroupId}) RETURN id(g)`
+	query := `MERGE (g:IAM:Group {Arn: $Arn})
+		SET g.GroupName = $GroupName, g.CreateDate = $CreateDate, g.Path = $Path, g.GroupId = $GroupId, ` + stamp("g") + `
+		RETURN id(g)`
 
 	idGroup, err := session.ExecuteWrite(context.TODO(), func(tx neo4j.ManagedTransaction) (any, error) {
 		var result, err = tx.Run(context.TODO(), query, map[string]interface{}{
@@ -83,6 +180,7 @@ func (nc *Neo4jClient) createGroup(group servicesIAM.Group) int64 {
 			"Arn":        group.Arn,
 			"Path":       group.Path,
 			"GroupId":    group.GroupId,
+			"snapshot":   nc.snapshot,
 		})
 
 		if err != nil {
@@ -99,58 +197,31 @@ func (nc *Neo4jClient) createGroup(group servicesIAM.Group) int64 {
 	return idGroup.(int64)
 }
 
-func (nc *Neo4jClient) createPolicyGroup(idGroup int64, policyArn string, name string, policyType string) int64 {
-	session := nc.NewSession()
-	defer func() {
-		if err := session.Close(context.TODO()); err != nil {
-			nc.logger.Error("failed to close session: %v", err)
-		}
-	}()
-	query := `%s
-		WITH policy
-		MATCH (g:IAM:Group) WHERE id(g) = $idGroup
-		MERGE (g)-[:HAS_POLICY]->(policy)
-		RETURN id(policy)`
-
-	switch policyType {
-	case "attached":
-		query = fmt.Sprintf(query, `CALL apoc.merge.node(["Policy", "Attached"], {Name: $Name, Type: $Type, Arn: $PolicyArn}) YIELD node AS policy`)
-	case "inline":
-		query = fmt.Sprintf(query, `CALL apoc.create.node(["Policy", "Inline"], {Name: $Name, Type: $Type}) YIELD node AS policy`)
+func (nc *Neo4jClient) createUser(user servicesIAM.User) int64 {
+	groupNames := make([]string, 0)
+	for _, group := range user.Groups {
+		groupNames = append(groupNames, aws.ToString(group.GroupName))
 	}
 
-	idPolicy, err := session.ExecuteWrite(context.TODO(), func(tx neo4j.ManagedTransaction) (any, error) {
-		var result, err = tx.Run(context.TODO(), query, map[string]interface{}{
-			"idGroup":   idGroup,
-			"Name":      name,
-			"Type":      policyType,
-			"PolicyArn": policyArn,
-		})
-
-		if err != nil {
-			return nil, err
-		}
-
-		result.Next(context.TODO())
-		return result.Record().Values[0].(int64), result.Err()
-	})
-
-	if err != nil {
-		nc.logger.Error("Error on executing query", "err", err, "query", query)
+	query := `MERGE (u:IAM:User {Arn: $Arn})
+		SET u.UserName = $UserName,
+			u.UserId = $UserId,
+			u.PasswordEnabled = $PasswordEnabled,
+			u.PasswordLastChanged = $PasswordLastChanged,
+			u.MFAStatus = $MFAStatus,
+			u.Groups = CASE WHEN size($groups) > 0 THEN $groups END,
+			` + stamp("u") + `
+		WITH u
+		OPTIONAL MATCH (g:Group) WHERE g.Arn IN $groupArns
+		FOREACH (group IN CASE WHEN g IS NULL THEN [] ELSE [g] END |
+			MERGE (u)-[m:MEMBER_OF]->(group)
+			SET ` + stamp("m") + `)
+		RETURN DISTINCT id(u)`
+
+	groupArns := make([]string, 0, len(user.Groups))
+	for _, group := range user.Groups {
+		groupArns = append(groupArns, aws.ToString(group.Arn))
 	}
-	return idPolicy.(int64)
-}
-
-func (nc *Neo4jClient) createUser(user servicesIAM.User) int64 {
-	groupNames := make([]string, 0)
-	query := `MERGE (u:IAM:User {
-		UserName: $UserName, 
-		Arn: $Arn,
-		UserId: $UserId,
-		PasswordEnabled: $PasswordEnabled,
-		PasswordLastChanged: $PasswordLastChanged,
-		MFAStatus: $MFAStatus})
-		RETURN id(u)`
 
 	session := nc.NewSession()
 	defer func() {
@@ -166,6 +237,9 @@ func (nc *Neo4jClient) createUser(user servicesIAM.User) int64 {
 			"PasswordEnabled":     user.PasswordEnabled,
 			"PasswordLastChanged": user.PasswordLastChanged,
 			"MFAStatus":           user.MfaActive,
+			"groups":              groupNames,
+			"groupArns":           groupArns,
+			"snapshot":            nc.snapshot,
 		})
 
 		if err != nil {
@@ -179,73 +253,52 @@ func (nc *Neo4jClient) createUser(user servicesIAM.User) int64 {
 	if err != nil {
 		nc.logger.Error("Error on executing query", "err", err, "query", query, "arguments", user)
 	}
-
-	for g := 0; g < len(user.Groups); g++ {
-		groupNames = append(groupNames, aws.ToString(user.Groups[g].GroupName))
-
-		session := nc.NewSession()
-		defer func() {
-			if err := session.Close(context.TODO()); err != nil {
-				nc.logger.Error("failed to close session: %v", err)
-			}
-		}()
-		queryGroup := `MATCH (g:Group {GroupName: $GroupName, CreateDate: $CreateDate, Arn: $Arn, GroupId: $GroupId, Path: $Path}) WITH g
-					   MATCH (u:User {Arn: $uArn})
-					   SET u.Groups = $groups
-					   WITH u, g
-					   MERGE (u)-[:MEMBER_OF]->(g)`
-
-		_, err := session.ExecuteWrite(context.TODO(), func(tx neo4j.ManagedTransaction) (any, error) {
-			var result, err = tx.Run(context.TODO(), queryGroup, map[string]interface{}{
-				"GroupName":  user.Groups[g].GroupName,
-				"CreateDate": fmt.Sprint(user.Groups[g].CreateDate),
-				"Arn":        user.Groups[g].Arn,
-				"GroupId":    user.Groups[g].GroupId,
-				"Path":       user.Groups[g].Path,
-				"uArn":       user.Arn,
-				"groups":     groupNames,
-			})
-
-			if err != nil {
-				return nil, err
-			}
-
-			return result.Consume(context.TODO())
-		})
-		if err != nil {
-			nc.logger.Error("Error on executing query", "err", err, "query", query)
-		}
-	}
-
 	return idUser.(int64)
 }
 
-func (nc *Neo4jClient) createPolicyUser(idUser int64, policyArn string, name string, policyType string) int64 {
+// Attached policies are shared between the principals and merged on the Arn, inline ones are merged on the
+// name for each principal. Policies of users and roles are labeled IAM
+func (nc *Neo4jClient) createPolicy(idPrincipal int64, policyArn string, name string, policyType string) int64 {
 	session := nc.NewSession()
 	defer func() {
 		if err := session.Close(context.TODO()); err != nil {
 			nc.logger.Error("failed to close session: %v", err)
 		}
 	}()
-	query := `%s
-		WITH policy
-		MATCH (u:IAM:User) WHERE id(u) = $idUser
-		MERGE (u)-[r:HAS_POLICY]->(policy)
-		RETURN id(policy)`
 
+	relationship := "HAS_POLICY"
+	if policyType == "boundary" {
+		// A permissions boundary is a managed policy: the node is shared with the principals that have it attached
+		relationship, policyType = "HAS_BOUNDARY", "attached"
+	}
+	// Every policy is an IAM resource, also the ones of groups: the IAM actions on policies reach them with ON
+	labels := ":Policy:" + cases.Title(language.Und).String(policyType) + ":IAM"
+
+	var query string
 	switch policyType {
 	case "attached":
-		query = fmt.Sprintf(query, `CALL apoc.merge.node(["Policy", "Attached", "IAM"], {Name: $Name, Type: $Type, Arn: $PolicyArn}) YIELD node AS policy`)
+		// The same managed policy can be attached to groups and to users or roles
+		query = fmt.Sprintf(`MERGE (policy:Policy:Attached {Arn: $PolicyArn})
+			SET policy%s, policy.Name = $Name, policy.Type = $Type, %s
+			WITH policy
+			MATCH (principal) WHERE id(principal) = $idPrincipal
+			MERGE (principal)-[r:%s]->(policy)
+			SET %s
+			RETURN id(policy)`, labels, stamp("policy"), relationship, stamp("r"))
 	case "inline":
-		query = fmt.Sprintf(query, `CALL apoc.create.node(["Policy", "Inline", "IAM"], {Name: $Name, Type: $Type}) YIELD node AS policy`)
+		query = fmt.Sprintf(`MATCH (principal) WHERE id(principal) = $idPrincipal
+			MERGE (principal)-[r:%s]->(policy%s {Name: $Name, Type: $Type})
+			SET %s
+			RETURN id(policy)`, relationship, labels, stamp("policy", "r"))
 	}
 
 	idPolicy, err := session.ExecuteWrite(context.TODO(), func(tx neo4j.ManagedTransaction) (any, error) {
 		var result, err = tx.Run(context.TODO(), query, map[string]interface{}{
-			"idUser":    idUser,
-			"Name":      name,
-			"Type":      policyType,
-			"PolicyArn": policyArn,
+			"idPrincipal": idPrincipal,
+			"Name":        name,
+			"Type":        policyType,
+			"PolicyArn":   policyArn,
+			"snapshot":    nc.snapshot,
 		})
 
 		if err != nil {
@@ -269,11 +322,17 @@ func (nc *Neo4jClient) createRole(role servicesIAM.Role) int64 {
 			nc.logger.Error("failed to close session: %v", err)
 		}
 	}()
-	query := ""
+	// Properties set to null are removed: the instance profile can be detached between two snapshots
+	query := `MERGE (r:IAM:Role {Arn: $Arn})
+		SET r.RoleName = $RoleName, r.Path = $Path, r.Description = $Description, r.RoleId = $RoleId, r.AssumableBy = $AssumableBy,
+			r.InstanceProfileArn = $InstanceProfileArn, r.IamInstanceProfileId = $IamInstanceProfileId, ` + stamp("r") + `
+		FOREACH (_ IN CASE WHEN $IamInstanceProfileId IS NULL THEN [] ELSE [1] END | SET r:InstanceProfile)
+		FOREACH (_ IN CASE WHEN $IamInstanceProfileId IS NULL THEN [1] ELSE [] END | REMOVE r:InstanceProfile)
+		RETURN id(r)`
+
+	var instanceProfileArn, instanceProfileID interface{}
 	if role.InstanceProfileID != "" {
-		query = `MERGE (r:IAM:Role:InstanceProfile {RoleName: $RoleName, Arn: $Arn, Path: $Path, Description: $Description, RoleId: $RoleId, InstanceProfileArn: $InstanceProfileArn, IamInstanceProfileId: $IamInstanceProfileId, AssumableBy: $AssumableBy}) RETURN id(r)`
-	} else {
-		query = `MERGE (r:IAM:Role {RoleName: $RoleName, Arn: $Arn, Path: $Path, Description: $Description, RoleId: $RoleId, AssumableBy: $AssumableBy}) RETURN id(r)`
+		instanceProfileArn, instanceProfileID = role.InstanceProfileArn, role.InstanceProfileID
 	}
 
 	idRole, err := session.ExecuteWrite(context.TODO(), func(tx neo4j.ManagedTransaction) (any, error) {
@@ -282,10 +341,11 @@ func (nc *Neo4jClient) createRole(role servicesIAM.Role) int64 {
 			"Arn":                  role.Arn,
 			"Path":                 role.Path,
 			"RoleId":               role.RoleId,
-			"InstanceProfileArn":   role.InstanceProfileArn,
-			"IamInstanceProfileId": role.InstanceProfileID,
+			"InstanceProfileArn":   instanceProfileArn,
+			"IamInstanceProfileId": instanceProfileID,
 			"AssumableBy":          role.AssumableBy,
 			"Description":          role.Description,
+			"snapshot":             nc.snapshot,
 		})
 
 		if err != nil {
@@ -302,49 +362,74 @@ func (nc *Neo4jClient) createRole(role servicesIAM.Role) int64 {
 	return idRole.(int64)
 }
 
-func (nc *Neo4jClient) createPolicyRole(idRole int64, policyArn string, name string, policyType string) int64 {
+// Statements with different actions or conditions are kept on different relationships
+const canRelationship = "CAN {Action: grant.properties.Action, Condition: grant.properties.Condition}"
+
+// Link the principals granted by a trust or resource-based policy to the resource with the given label and Arn
+func (nc *Neo4jClient) createPrincipalRelationships(label string, relationship string, grants map[string]interface{}) {
 	session := nc.NewSession()
 	defer func() {
 		if err := session.Close(context.TODO()); err != nil {
 			nc.logger.Error("failed to close session: %v", err)
 		}
 	}()
-	query := `%s
-		WITH policy
-		MATCH (u:Role) WHERE id(u) = $idRole
-		MERGE (u)-[r:HAS_POLICY]->(policy)
-		RETURN id(policy)`
 
-	switch policyType {
-	case "attached":
-		query = fmt.Sprintf(query, `CALL apoc.merge.node(["Policy", "Attached", "IAM"], {Name: $Name, Type: $Type, Arn: $PolicyArn}) YIELD node AS policy`)
-	case "inline":
-		query = fmt.Sprintf(query, `CALL apoc.create.node(["Policy", "Inline", "IAM"], {Name: $Name, Type: $Type}) YIELD node AS policy`)
+	// Known users and roles are linked directly; the root of an account, unknown principals and principals of
+	// accounts not (yet) imported are linked through the Account node, labeled ExternalAccount until imported.
+	// "*" is everyone, also unauthenticated for resource-based policies: a single node shared by all the accounts.
+	// A direct link is no longer derived from the trust of the account (Account), even if a previous import did so
+	queries := []string{
+		`UNWIND $aws AS grant
+		MATCH (target:%[1]s {Arn: grant.target})
+		OPTIONAL MATCH (principal:IAM {Arn: grant.principal}) WHERE principal:User OR principal:Role
+		WITH target, grant, principal
+		FOREACH (_ IN CASE WHEN principal IS NOT NULL THEN [1] ELSE [] END |
+			MERGE (principal)-[r:%[2]s]->(target)
+			SET r += grant.properties, r.Account = null, %[3]s)
+		FOREACH (_ IN CASE WHEN principal IS NULL AND grant.account <> '*' THEN [1] ELSE [] END |
+			MERGE (account:Account {Id: grant.account})
+			ON CREATE SET account:ExternalAccount
+			MERGE (account)-[r:%[2]s]->(target)
+			SET r += grant.properties, r.Principal = grant.principal, %[3]s)
+		FOREACH (_ IN CASE WHEN grant.account = '*' THEN [1] ELSE [] END |
+			MERGE (any:AnyAWSPrincipal:Anonymous {Id: '*'})
+			MERGE (any)-[r:%[2]s]->(target)
+			SET r += grant.properties, %[3]s)`,
+		`UNWIND $services AS grant
+		MATCH (target:%[1]s {Arn: grant.target})
+		MERGE (service:AWSService {Name: grant.principal})
+		MERGE (service)-[r:%[2]s]->(target)
+		SET r += grant.properties, %[4]s`,
+		`UNWIND $federated AS grant
+		MATCH (target:%[1]s {Arn: grant.target})
+		MERGE (provider:FederatedProvider {Name: grant.principal})
+		MERGE (provider)-[r:%[2]s]->(target)
+		SET r += grant.properties, %[5]s`,
 	}
 
-	idPolicy, err := session.ExecuteWrite(context.TODO(), func(tx neo4j.ManagedTransaction) (any, error) {
-		var result, err = tx.Run(context.TODO(), query, map[string]interface{}{
-			"idRole":    idRole,
-			"Name":      name,
-			"Type":      policyType,
-			"PolicyArn": policyArn,
-		})
-
-		if err != nil {
-			return nil, err
+	grants["snapshot"] = nc.snapshot
+	_, err := session.ExecuteWrite(context.TODO(), func(tx neo4j.ManagedTransaction) (any, error) {
+		for _, query := range queries {
+			result, err := tx.Run(context.TODO(), fmt.Sprintf(query, label, relationship, stamp("r"), stamp("service", "r"), stamp("provider", "r")), grants)
+			if err != nil {
+				return nil, err
+			}
+			if _, err = result.Consume(context.TODO()); err != nil {
+				return nil, err
+			}
 		}
-
-		result.Next(context.TODO())
-		return result.Record().Values[0].(int64), result.Err()
+		return nil, nil
 	})
 
 	if err != nil {
-		nc.logger.Error("Error on executing query", "err", err, "query", query)
+		nc.logger.Warn("Error on executing query createPrincipalRelationships", "err", err, "arguments", grants)
 	}
-	return idPolicy.(int64)
 }
 
+// Run the query with the objects and the current snapshot as parameters
 func (nc *Neo4jClient) AddObjects(result map[string]interface{}, query string) {
+	result = maps.Clone(result)
+	result["snapshot"] = nc.snapshot
 	session := nc.NewSession()
 	defer func() {
 		if err := session.Close(context.TODO()); err != nil {
@@ -367,37 +452,40 @@ func (nc *Neo4jClient) AddObjects(result map[string]interface{}, query string) {
 	}
 }
 
-func (nc *Neo4jClient) addLinksToResources(service string, property string) {
+// The Actions allowed on a resource are linked to it with ON, while the resources matched by a Deny are listed on
+// the DENIES relationship: they are subtracted from the permissions of the principals holding the policy
+func linkResource(action, resource string) string {
+	return fmt.Sprintf(`FOREACH (_ IN CASE WHEN type(rel) = 'ALLOWS' THEN [1] ELSE [] END | MERGE (%[1]s)-[on:ON]->(%[2]s) SET %[3]s)
+		FOREACH (_ IN CASE WHEN type(rel) = 'DENIES' AND NOT %[2]s.Arn IN coalesce(rel.DeniedResources, []) THEN [1] ELSE [] END |
+			SET rel.DeniedResources = coalesce(rel.DeniedResources, []) + %[2]s.Arn)`, action, resource, stamp("on"))
+}
+
+// Link the Actions of all the imported policies to the resources of a service matching their Resource element
+func (nc *Neo4jClient) addLinksToResources(service string, arns []string) {
 	session := nc.NewSession()
 	defer func() {
 		if err := session.Close(context.TODO()); err != nil {
 			nc.logger.Error("failed to close session: %v", err)
 		}
 	}()
-	actionResourceRelations = uniqueActionsResources(&actionResourceRelations)
-	// Filter only related service relationships
-	var out []map[string]string
-	for _, v := range actionResourceRelations {
-		if strings.EqualFold(v["service"], service) && !strings.Contains(v["action"], "Create") {
-			out = append(out, v)
-		}
-	}
+
+	links := nc.resources.Match(service, arns, func(ar *graph.ActionResource) bool {
+		return !strings.Contains(ar.Action, "Create")
+	})
 
 	// https://neo4j.com/labs/apoc/4.4/overview/apoc.periodic/apoc.periodic.iterate/
 	query := `CALL apoc.periodic.iterate("
-		UNWIND $actionResourceMap AS armap
-		MATCH (p:Policy)-[:ALLOWS]->(a:Action {Service: armap.service, Action: armap.action})
-		WHERE id(p) = toInteger(armap.policy)
-		MATCH (s:Service:` + cases.Title(language.Und).String(service) + `) WHERE
-			(s.` + property + ` = armap.resource) OR
-			(armap.resource = '') OR
-			(armap.resource CONTAINS '*' AND s.` + property + ` =~ replace(armap.resource, '*', '.*?'))
-		RETURN a, s",
-		"MERGE (a)-[:ON]->(s)",
-		{batchSize:10000, iterateList:true, params: {actionResourceMap: $actionResourceMap}})`
+		UNWIND $links AS link
+		MATCH (p:Policy)-[rel:ALLOWS|DENIES]->(a:Action {Service: link.service, Action: link.action})
+		WHERE id(p) = toInteger(link.policy) AND type(rel) = link.relationship AND rel.Condition = link.condition
+		MATCH (s:Service:` + cases.Title(language.Und).String(service) + ` {Arn: link.arn})
+		RETURN a, s, rel",
+		"` + linkResource("a", "s") + `",
+		{batchSize:10000, iterateList:true, params: {links: $links, snapshot: $snapshot}})`
 	_, err := session.ExecuteWrite(context.TODO(), func(tx neo4j.ManagedTransaction) (any, error) {
 		var result, err = tx.Run(context.TODO(), query, map[string]interface{}{
-			"actionResourceMap": out,
+			"links":    links,
+			"snapshot": nc.snapshot,
 		})
 		if err != nil {
 			return nil, err
@@ -420,40 +508,52 @@ func (nc *Neo4jClient) AddLinksToResourcesIAM() {
 	// #nosec
 	session.Run(context.TODO(), "CALL db.awaitIndexes(3000)", nil) //nolint:all
 
-	// Filter only related service relationships
-	var out []map[string]string
-	actionResourceRelations = uniqueActionsResources(&actionResourceRelations)
-	for _, v := range actionResourceRelations {
-		if strings.EqualFold(v["service"], "iam") {
-			v["resourceType"] = awsconfig.IAMActionResourceMap[v["action"]]
-			out = append(out, v)
+	principals, err := session.ExecuteRead(context.TODO(), func(tx neo4j.ManagedTransaction) (any, error) {
+		result, err := tx.Run(context.TODO(), `MATCH (principal:IAM) WHERE principal.Arn IS NOT NULL
+			RETURN principal.Arn AS arn, coalesce(principal.InstanceProfileArn, '') AS instanceProfile, labels(principal) AS labels`, nil)
+		if err != nil {
+			return nil, err
+		}
+		return result.Collect(context.TODO())
+	})
+	if err != nil {
+		nc.logger.Error("Error on executing query", "err", err)
+	}
+
+	// An IAM or STS Action applies only to the type of resource defined for it; roles are matched also by instance
+	// profile
+	var links []map[string]string
+	for _, record := range principals.([]*neo4j.Record) {
+		principalArn, _ := record.Get("arn")
+		instanceProfileArn, _ := record.Get("instanceProfile")
+		labels, _ := record.Get("labels")
+		arns := []string{principalArn.(string)}
+		if instanceProfileArn.(string) != "" {
+			arns = append(arns, instanceProfileArn.(string))
+		}
+		for _, service := range slices.Sorted(maps.Keys(awsconfig.IdentityActionResourceMaps)) {
+			for _, link := range nc.resources.Match(service, arns, func(ar *graph.ActionResource) bool {
+				resourceType := awsconfig.IdentityActionResourceMaps[service][ar.Action]
+				return resourceType == "*" || (resourceType != "" && slices.Contains(labels.([]interface{}), interface{}(resourceType)))
+			}) {
+				link["arn"] = principalArn.(string)
+				links = append(links, link)
+			}
 		}
 	}
 
 	query := `CALL apoc.periodic.iterate("
-		UNWIND $actionResourceMap AS armap
-		MATCH (p:Policy)-[:ALLOWS]->(act:Action {Service: 'iam', Action: armap.action})
-		WHERE id(p) = toInteger(armap.policy)
-		MATCH (principal:IAM) WHERE
-			(
-				(armap.resourceType = '*') OR
-				(armap.resourceType <> '' AND armap.resourceType IN LABELS(principal))
-			)
-			AND
-			(
-				(principal.Arn = armap.arn) OR
-				(armap.arn = '') OR
-				(armap.arn CONTAINS '*' AND 
-					principal.Arn =~ replace(armap.arn, '*', '.*?') OR 
-					principal.InstanceProfileArn =~ replace(armap.arn, '*', '.*?')
-				)
-			)
-		RETURN act, principal",
-		"MERGE (act)-[:ON]->(principal)",
-		{batchSize:5000, iterateList:true, params: {actionResourceMap: $actionResourceMap}})`
-	_, err := session.ExecuteWrite(context.TODO(), func(tx neo4j.ManagedTransaction) (any, error) {
+		UNWIND $links AS link
+		MATCH (p:Policy)-[rel:ALLOWS|DENIES]->(act:Action {Service: link.service, Action: link.action})
+		WHERE id(p) = toInteger(link.policy) AND type(rel) = link.relationship AND rel.Condition = link.condition
+		MATCH (principal:IAM {Arn: link.arn})
+		RETURN act, principal, rel",
+		"` + linkResource("act", "principal") + `",
+		{batchSize:5000, iterateList:true, params: {links: $links, snapshot: $snapshot}})`
+	_, err = session.ExecuteWrite(context.TODO(), func(tx neo4j.ManagedTransaction) (any, error) {
 		var result, err = tx.Run(context.TODO(), query, map[string]interface{}{
-			"actionResourceMap": out,
+			"links":    links,
+			"snapshot": nc.snapshot,
 		})
 		if err != nil {
 			return nil, err
@@ -466,19 +566,194 @@ func (nc *Neo4jClient) AddLinksToResourcesIAM() {
 	}
 }
 
+// Resolve Allow and Deny statements of all the inline, attached and group policies of each principal:
+// an allowed Action is linked with HAS_PERMISSION only if it is not explicitly denied on every resource
+// and, when the principal has a permissions boundary or SCPs apply to its account, only if they allow it too.
+// The resources denied to the principal by the others are listed in DeniedResources: ON never reaches them
+func (nc *Neo4jClient) AddEffectivePermissions() {
+	session := nc.NewSession()
+	defer func() {
+		if err := session.Close(context.TODO()); err != nil {
+			nc.logger.Error("failed to close session: %v", err)
+		}
+	}()
+
+	// The account ID is the 5th field of the ARN
+	linkAccounts := `MATCH (principal:IAM) WHERE principal:User OR principal:Role
+		MATCH (account:Account {Id: split(principal.Arn, ':')[4]})
+		MERGE (principal)-[b:BELONGS_TO]->(account)
+		SET ` + stamp("b")
+	_, err := session.Run(context.TODO(), linkAccounts, map[string]interface{}{"snapshot": nc.snapshot})
+	if err != nil {
+		nc.logger.Error("Error on executing query", "err", err, "query", linkAccounts)
+	}
+
+	// Permissions are derived from the whole graph: they are rebuilt from scratch, also for the other accounts
+	clearPermissions := `CALL apoc.periodic.iterate("MATCH ()-[p:HAS_PERMISSION]->() RETURN p", "DELETE p", {batchSize:10000, iterateList:true})`
+	_, err = session.Run(context.TODO(), clearPermissions, nil)
+	if err != nil {
+		nc.logger.Error("Error on executing query", "err", err, "query", clearPermissions)
+	}
+
+	// SCPs are evaluated on every level from the account up to the Root: each level must allow the action.
+	// Service-linked roles and the management account are not affected by SCPs.
+	// Allows whose conditions can never match are ignored, while Denies are applied only when their conditions
+	// always match: a conditional permission is kept and marked as such on HAS_PERMISSION.
+	// The conditions are the ones of the Allow, of the context-dependent Denies and of the boundary and the levels of
+	// SCPs allowing the action only under conditions, with all their keys
+	query := `CALL apoc.periodic.iterate("
+		MATCH (principal:IAM) WHERE principal:User OR principal:Role
+		MATCH (principal)-[:MEMBER_OF*0..1]->()-[:HAS_POLICY]->(:Policy)-[allow:ALLOWS]->(action:Action)
+		WHERE allow.Condition <> 'always-false' AND NOT EXISTS {
+			MATCH (principal)-[:MEMBER_OF*0..1]->()-[:HAS_POLICY|HAS_BOUNDARY]->(:Policy)-[deny:DENIES]->(:Action {Service: action.Service, Action: action.Action})
+			WHERE '*' IN deny.Resources AND deny.Condition = 'always-true'
+		} AND (
+			NOT EXISTS { MATCH (principal)-[:HAS_BOUNDARY]->(:Policy) } OR
+			EXISTS {
+				MATCH (principal)-[:HAS_BOUNDARY]->(:Policy)-[boundary:ALLOWS]->(:Action {Service: action.Service, Action: action.Action})
+				WHERE boundary.Condition <> 'always-false'
+			}
+		) AND (
+			coalesce(principal.Path, '') STARTS WITH '/aws-service-role/' OR
+			ALL(level IN [(principal)-[:BELONGS_TO]->(account:Account)-[:CHILD_OF*0..]->(level) WHERE account.Management IS NULL | level] WHERE
+				NOT EXISTS { MATCH (level)-[:HAS_SCP]->(:SCP) } OR
+				(
+					EXISTS {
+						MATCH (level)-[:HAS_SCP]->(:SCP)-[scp:ALLOWS]->(:Action {Service: action.Service, Action: action.Action})
+						WHERE scp.Condition <> 'always-false'
+					} AND
+					NOT EXISTS {
+						MATCH (level)-[:HAS_SCP]->(:SCP)-[deny:DENIES]->(:Action {Service: action.Service, Action: action.Action})
+						WHERE '*' IN deny.Resources AND deny.Condition = 'always-true'
+					}
+				)
+			)
+		)
+		WITH principal, action, allow, CASE WHEN coalesce(principal.Path, '') STARTS WITH '/aws-service-role/' THEN [] ELSE
+			[(principal)-[:BELONGS_TO]->(account:Account)-[:CHILD_OF*0..]->(level) WHERE account.Management IS NULL | level] END AS levels
+		WITH principal, action, allow,
+			[(principal)-[:MEMBER_OF*0..1]->()-[:HAS_POLICY|HAS_BOUNDARY]->(:Policy)-[deny:DENIES {Condition: 'context-dependent'}]->(:Action {Service: action.Service, Action: action.Action}) | deny.ConditionKeys] +
+			CASE WHEN size([(principal)-[:HAS_BOUNDARY]->(boundary:Policy) | boundary]) > 0 AND
+				size([(principal)-[:HAS_BOUNDARY]->(:Policy)-[boundary:ALLOWS {Condition: 'always-true'}]->(:Action {Service: action.Service, Action: action.Action}) | boundary]) = 0
+			THEN [apoc.coll.flatten([(principal)-[:HAS_BOUNDARY]->(:Policy)-[boundary:ALLOWS {Condition: 'context-dependent'}]->(:Action {Service: action.Service, Action: action.Action}) | boundary.ConditionKeys])]
+			ELSE [] END +
+			apoc.coll.flatten([level IN levels |
+				[(level)-[:HAS_SCP]->(:SCP)-[deny:DENIES {Condition: 'context-dependent'}]->(:Action {Service: action.Service, Action: action.Action}) | deny.ConditionKeys] +
+				CASE WHEN size([(level)-[:HAS_SCP]->(scp:SCP) | scp]) > 0 AND
+					size([(level)-[:HAS_SCP]->(:SCP)-[scp:ALLOWS {Condition: 'always-true'}]->(:Action {Service: action.Service, Action: action.Action}) | scp]) = 0
+				THEN [apoc.coll.flatten([(level)-[:HAS_SCP]->(:SCP)-[scp:ALLOWS {Condition: 'context-dependent'}]->(:Action {Service: action.Service, Action: action.Action}) | scp.ConditionKeys])]
+				ELSE [] END
+			]) AS conditions
+		RETURN principal, action, allow, conditions",
+		"MERGE (principal)-[permission:HAS_PERMISSION]->(action)
+		SET permission.Conditional = allow.Conditional OR size(conditions) > 0,
+			permission.ConditionKeys = apoc.coll.toSet(coalesce(allow.ConditionKeys, []) + apoc.coll.flatten(conditions)),
+			permission.DeniedResources = apoc.coll.toSet(apoc.coll.flatten(
+				[(principal)-[:MEMBER_OF*0..1]->()-[:HAS_POLICY|HAS_BOUNDARY]->(:Policy)-[deny:DENIES]->(:Action {Service: action.Service, Action: action.Action})
+					WHERE deny.Condition = 'always-true' | coalesce(deny.DeniedResources, [])] +
+				[(principal)-[:BELONGS_TO]->(account:Account)-[:CHILD_OF*0..]->()-[:HAS_SCP]->(:SCP)-[deny:DENIES]->(:Action {Service: action.Service, Action: action.Action})
+					WHERE account.Management IS NULL AND NOT coalesce(principal.Path, '') STARTS WITH '/aws-service-role/' AND deny.Condition = 'always-true' | coalesce(deny.DeniedResources, [])]
+			)), ` + stamp("permission") + `",
+		{batchSize:10000, iterateList:true, params: {snapshot: $snapshot}})`
+	_, err = session.Run(context.TODO(), query, map[string]interface{}{"snapshot": nc.snapshot})
+	if err != nil {
+		nc.logger.Error("Error on executing query", "err", err, "query", query)
+	}
+
+	// A role trusting an account can be assumed only by the principals of the account allowed to by their own
+	// permissions: they are linked to the role with a CAN_ASSUME relationship holding the Account, rebuilt as well.
+	// It is conditional only if all the ways are conditional
+	clearAssumes := `CALL apoc.periodic.iterate("MATCH ()-[r:CAN_ASSUME]->() WHERE r.Account IS NOT NULL RETURN r", "DELETE r", {batchSize:10000, iterateList:true})`
+	_, err = session.Run(context.TODO(), clearAssumes, nil)
+	if err != nil {
+		nc.logger.Error("Error on executing query", "err", err, "query", clearAssumes)
+	}
+
+	assumes := `CALL apoc.periodic.iterate("
+		MATCH (principal:IAM)-[:BELONGS_TO]->(account:Account)-[trust:CAN_ASSUME]->(role:Role)
+		WHERE (principal:User OR principal:Role) AND coalesce(trust.Principal, '') IN ['', account.Id] AND trust.Condition <> 'always-false'
+		MATCH (principal)-[permission:HAS_PERMISSION]->(:Action {Service: 'sts', Action: 'AssumeRole'})-[:ON]->(role)
+		WHERE NOT role.Arn IN coalesce(permission.DeniedResources, [])
+		WITH principal, account, role, collect(coalesce(trust.Conditional, false) OR coalesce(permission.Conditional, false)) AS ways,
+			apoc.coll.toSet(apoc.coll.flatten(collect(coalesce(trust.ConditionKeys, []) + coalesce(permission.ConditionKeys, [])))) AS keys
+		RETURN principal, account, role, ALL(c IN ways WHERE c) AS conditional, keys",
+		"MERGE (principal)-[r:CAN_ASSUME {Account: account.Id}]->(role)
+		SET r.Conditional = conditional, r.ConditionKeys = keys, ` + stamp("r") + `",
+		{batchSize:10000, iterateList:true, params: {snapshot: $snapshot}})`
+	_, err = session.Run(context.TODO(), assumes, map[string]interface{}{"snapshot": nc.snapshot})
+	if err != nil {
+		nc.logger.Error("Error on executing query", "err", err, "query", assumes)
+	}
+}
+
+// The account being imported is no longer external, even if other accounts referenced it before.
+// Partition and account are used to build the ARN of the resources imported next
+func (nc *Neo4jClient) AddAccount(partition string, accountID string) {
+	nc.partition, nc.accountID = partition, accountID
+	nc.AddObjects(map[string]interface{}{"id": accountID}, `MERGE (a:Account {Id: $id}) REMOVE a:ExternalAccount SET `+stamp("a"))
+}
+
+// Record on the account the catalog that expanded the actions of its policies
+func (nc *Neo4jClient) SetCatalog(accountID string, info catalog.Info) {
+	nc.AddObjects(map[string]interface{}{"id": accountID, "version": info.Version, "updated": info.Updated, "source": info.Source},
+		`MATCH (a:Account {Id: $id}) SET a.CatalogVersion = $version, a.CatalogUpdated = $updated, a.CatalogSource = $source`)
+}
+
+// Set the AccountId property on every node still without one: AWS managed policies, services and
+// public principals belong to "aws", Account nodes and external VPCs to their own account
+func (nc *Neo4jClient) SetAccountID(accountID string) {
+	session := nc.NewSession()
+	defer func() {
+		if err := session.Close(context.TODO()); err != nil {
+			nc.logger.Error("failed to close session: %v", err)
+		}
+	}()
+
+	query := `CALL apoc.periodic.iterate(
+		"MATCH (n) WHERE n.AccountId IS NULL RETURN n",
+		"SET n.AccountId = CASE
+			WHEN n:Account THEN n.Id
+			WHEN n.Arn STARTS WITH 'arn:aws:iam::aws:' OR n:AWSService OR n:AnyAWSPrincipal THEN 'aws'
+			WHEN n:Vpc AND n.OwnerId IS NOT NULL THEN n.OwnerId
+			ELSE $accountId END",
+		{batchSize:10000, iterateList:true, params: {accountId: $accountId}})`
+	_, err := session.Run(context.TODO(), query, map[string]interface{}{"accountId": accountID})
+	if err != nil {
+		nc.logger.Error("Error on executing query", "err", err, "query", query)
+	}
+}
+
 func (nc *Neo4jClient) AddBuckets(buckets *[]servicesS3.Bucket) {
 	query := `UNWIND $objects AS bucket			
-		CREATE (s:S3:Service)
-		SET s = bucket`
-	nc.AddObjects(flatObjects(*buckets), query)
-	nc.addLinksToResources("s3", "Name")
+		MERGE (s:S3:Service {Arn: bucket.Arn})
+		SET s += bucket, ` + stamp("s")
+	arns := make([]string, len(*buckets))
+	for i, bucket := range *buckets {
+		arns[i] = nc.resourceARN("s3", "", "", aws.ToString(bucket.Name))
+	}
+	nc.AddObjects(graph.WithARNs(graph.FlatObjects(*buckets), arns), query)
+	for i, bucket := range *buckets {
+		nc.createPrincipalRelationships("S3", canRelationship, graph.ParseResourcePolicy(arns[i], bucket.Policy))
+	}
+	nc.addLinksToResources("s3", arns)
 }
 
 func (nc *Neo4jClient) AddEC2(instances *[]servicesEC2.Instance) {
 	query := `UNWIND $objects AS instance
-		CREATE (e:Ec2:Service)
-		SET e = instance`
-	nc.AddObjects(flatObjects(*instances), query)
+		MERGE (e:Ec2:Service {Arn: instance.Arn})
+		SET e += instance, ` + stamp("e")
+	arns := make([]string, len(*instances))
+	for i, instance := range *instances {
+		var region, owner = "", nc.accountID
+		if instance.Placement != nil {
+			region = strings.TrimRight(aws.ToString(instance.Placement.AvailabilityZone), "abcdefghijklmnopqrstuvwxyz")
+		}
+		if len(instance.NetworkInterfaces) > 0 && instance.NetworkInterfaces[0].OwnerId != nil {
+			owner = aws.ToString(instance.NetworkInterfaces[0].OwnerId)
+		}
+		arns[i] = nc.resourceARN("ec2", region, owner, "instance/"+aws.ToString(instance.InstanceId))
+	}
+	nc.AddObjects(graph.WithARNs(graph.FlatObjects(*instances), arns), query)
 
 	session := nc.NewSession()
 	defer func() {
@@ -491,22 +766,22 @@ func (nc *Neo4jClient) AddEC2(instances *[]servicesEC2.Instance) {
 		MATCH (role:Role) WHERE role.InstanceProfileArn <> '' RETURN role",
 		"MATCH (n:Ec2:Service) WHERE
 			n.IamInstanceProfile_Arn = role.InstanceProfileArn
-		MERGE (n)-[:USES]->(role)", {batchSize:10000, parallel:true, iterateList:true})`
-	_, err := session.Run(context.TODO(), linkInstanceProfiles, nil)
+		MERGE (n)-[u:USES]->(role) SET ` + stamp("u") + `", {batchSize:10000, parallel:true, iterateList:true, params: {snapshot: $snapshot}})`
+	_, err := session.Run(context.TODO(), linkInstanceProfiles, map[string]interface{}{"snapshot": nc.snapshot})
 	if err != nil {
 		nc.logger.Error("Error on executing query", "err", err, "query", query, "arguments", linkInstanceProfiles)
 	}
-	nc.addLinksToResources("ec2", "InstanceId")
+	nc.addLinksToResources("ec2", arns)
 }
 
 func (nc *Neo4jClient) AddVPC(vpcs *servicesEC2.VPC) {
 	queryVPC := `UNWIND $objects AS vpcs
-		CREATE (vpc:Vpc:Service)
-		SET vpc = vpcs
-		SET vpc.Type = "Internal"
+		MERGE (vpc:Vpc {VpcId: vpcs.VpcId})
+		SET vpc:Service, vpc += vpcs, vpc.Type = "Internal", ` + stamp("vpc") + `
 		WITH vpc, vpcs.VpcId AS vpcid
 		MATCH (ec2:Ec2 {VpcId: vpcid})
-		MERGE (ec2)-[:NETWORK]->(vpc)`
+		MERGE (ec2)-[n:NETWORK]->(vpc)
+		SET ` + stamp("n")
 
 	queryPeering := `UNWIND $objects AS peerings
 		WITH peerings, peerings.RequesterVpcInfo_VpcId AS vpcid_req, peerings.AccepterVpcInfo_VpcId AS vpcid_acc,
@@ -516,19 +791,27 @@ func (nc *Neo4jClient) AddVPC(vpcs *servicesEC2.VPC) {
 		SET (CASE WHEN req.Type IS NULL THEN req END).Type = 'External'
 		SET (CASE WHEN acc.Type IS NULL THEN acc END).Type = 'External'
 		WITH req, acc, peerings
-		CALL apoc.merge.relationship(req, "PEERING", peerings, {}, acc, {}) YIELD rel
+		CALL apoc.merge.relationship(req, "PEERING", {VpcPeeringConnectionId: peerings.VpcPeeringConnectionId}, peerings, acc, peerings) YIELD rel
+		SET ` + stamp("rel") + `
 		RETURN rel`
 
-	nc.AddObjects(flatObjects(vpcs.VPCs), queryVPC)
-	nc.AddObjects(flatObjects(vpcs.Peerings), queryPeering)
+	nc.AddObjects(graph.FlatObjects(vpcs.VPCs), queryVPC)
+	nc.AddObjects(graph.FlatObjects(vpcs.Peerings), queryPeering)
 }
 
 func (nc *Neo4jClient) AddLambda(lambdas *[]servicesLambda.Lambda) {
 	query := `UNWIND $objects AS lambdas
-		CREATE (lbd:Lambda:Service)
-		SET lbd = lambdas`
+		MERGE (lbd:Lambda:Service {Arn: lambdas.Arn})
+		SET lbd += lambdas, ` + stamp("lbd")
 
-	nc.AddObjects(flatObjects(*lambdas), query)
+	arns := make([]string, len(*lambdas))
+	for i, lambda := range *lambdas {
+		arns[i] = aws.ToString(lambda.FunctionArn)
+	}
+	nc.AddObjects(graph.WithARNs(graph.FlatObjects(*lambdas), arns), query)
+	for i, lambda := range *lambdas {
+		nc.createPrincipalRelationships("Lambda", canRelationship, graph.ParseResourcePolicy(arns[i], lambda.Policy))
+	}
 
 	session := nc.NewSession()
 	defer func() {
@@ -539,50 +822,71 @@ func (nc *Neo4jClient) AddLambda(lambdas *[]servicesLambda.Lambda) {
 
 	linkRoles := `call apoc.periodic.iterate(
 		"MATCH (role:Role) RETURN role",
-		"MATCH (n:Lambda:Service) WHERE n.Role = role.Arn MERGE (n)-[:USES]->(role)",
-		{batchSize:10000, parallel:true, iterateList:true})`
-	_, err := session.Run(context.TODO(), linkRoles, nil)
+		"MATCH (n:Lambda:Service) WHERE n.Role = role.Arn MERGE (n)-[u:USES]->(role) SET ` + stamp("u") + `",
+		{batchSize:10000, parallel:true, iterateList:true, params: {snapshot: $snapshot}})`
+	_, err := session.Run(context.TODO(), linkRoles, map[string]interface{}{"snapshot": nc.snapshot})
 	if err != nil {
 		nc.logger.Error("Error on executing query", "err", err, "query", query, "arguments", linkRoles)
 	}
 
 	linkVpcs := `call apoc.periodic.iterate(
 		"MATCH (l:Lambda) WHERE l.VpcConfig_VpcId <> '' MATCH (v:Vpc) WHERE v.VpcId = l.VpcConfig_VpcId RETURN l, v",
-		"MERGE (l)-[:NETWORK]->(v)",
-		{batchSize:10000, parallel:true, iterateList:true})`
-	_, err = session.Run(context.TODO(), linkVpcs, nil)
+		"MERGE (l)-[n:NETWORK]->(v) SET ` + stamp("n") + `",
+		{batchSize:10000, parallel:true, iterateList:true, params: {snapshot: $snapshot}})`
+	_, err = session.Run(context.TODO(), linkVpcs, map[string]interface{}{"snapshot": nc.snapshot})
 	if err != nil {
 		nc.logger.Error("Error on executing query", "err", err, "query", query, "arguments", linkVpcs)
 	}
-	nc.addLinksToResources("lambda", "FunctionName")
+	nc.addLinksToResources("lambda", arns)
 }
 
 func (nc *Neo4jClient) AddRDS(rdsdbs *servicesDatabase.RDS) {
 	query := `UNWIND $objects AS rds				
-		CREATE (s:Rds:Service)
-		SET s = rds`
+		MERGE (s:Rds:Service {Arn: rds.Arn})
+		SET s += rds, ` + stamp("s")
+
+	clusterArns := make([]string, len(rdsdbs.Clusters))
+	for i, cluster := range rdsdbs.Clusters {
+		clusterArns[i] = aws.ToString(cluster.DBClusterArn)
+	}
+	instanceArns := make([]string, len(rdsdbs.Instances))
+	for i, instance := range rdsdbs.Instances {
+		instanceArns[i] = aws.ToString(instance.DBInstanceArn)
+	}
 
-	nc.AddObjects(flatObjects(rdsdbs.Clusters), query)
-	nc.AddObjects(flatObjects(rdsdbs.Instances), query)
-	nc.addLinksToResources("rds", "DBClusterIdentifier")
-	nc.addLinksToResources("rds", "DBInstanceIdentifier")
+	nc.AddObjects(graph.WithARNs(graph.FlatObjects(rdsdbs.Clusters), clusterArns), query)
+	nc.AddObjects(graph.WithARNs(graph.FlatObjects(rdsdbs.Instances), instanceArns), query)
+	nc.addLinksToResources("rds", append(clusterArns, instanceArns...))
 }
 
 func (nc *Neo4jClient) AddDynamoDB(dynamodbs *[]servicesDatabase.DynamoDB) {
 	query := `UNWIND $objects AS dynamodb				
-		CREATE (s:Dynamodb:Service)
-		SET s = dynamodb`
+		MERGE (s:Dynamodb:Service {Arn: dynamodb.Arn})
+		SET s += dynamodb, ` + stamp("s")
 
-	nc.AddObjects(flatObjects(*dynamodbs), query)
-	nc.addLinksToResources("dynamodb", "Name")
+	arns := make([]string, len(*dynamodbs))
+	for i, table := range *dynamodbs {
+		arns[i] = nc.resourceARN("dynamodb", table.Region, nc.accountID, "table/"+table.Name)
+	}
+	nc.AddObjects(graph.WithARNs(graph.FlatObjects(*dynamodbs), arns), query)
+	nc.addLinksToResources("dynamodb", arns)
 }
 
 func (nc *Neo4jClient) AddRedshift(redshifts *[]servicesDatabase.RedshiftDB) {
 	query := `UNWIND $objects AS redshift				
-		CREATE (s:Redshift:Service)
-		SET s = redshift`
-	nc.AddObjects(flatObjects(*redshifts), query)
-	nc.addLinksToResources("redshift", "DBName")
+		MERGE (s:Redshift:Service {Arn: redshift.Arn})
+		SET s += redshift, ` + stamp("s")
+	arns := make([]string, len(*redshifts))
+	for i, cluster := range *redshifts {
+		// The namespace ARN has the same partition, region and account of the cluster
+		namespace, err := arn.Parse(aws.ToString(cluster.ClusterNamespaceArn))
+		if err != nil {
+			namespace = arn.ARN{Region: strings.TrimRight(aws.ToString(cluster.AvailabilityZone), "abcdefghijklmnopqrstuvwxyz"), AccountID: nc.accountID}
+		}
+		arns[i] = nc.resourceARN("redshift", namespace.Region, namespace.AccountID, "cluster:"+aws.ToString(cluster.ClusterIdentifier))
+	}
+	nc.AddObjects(graph.WithARNs(graph.FlatObjects(*redshifts), arns), query)
+	nc.addLinksToResources("redshift", arns)
 
 	session := nc.NewSession()
 	defer func() {
@@ -592,10 +896,76 @@ func (nc *Neo4jClient) AddRedshift(redshifts *[]servicesDatabase.RedshiftDB) {
 	}()
 	linkVpcs := `call apoc.periodic.iterate(
 		"MATCH (r:Redshift) WHERE r.VpcId <> '' MATCH (v:Vpc) WHERE v.VpcId = r.VpcId RETURN r, v",
-		"MERGE (r)-[:NETWORK]->(v)",
-		{batchSize:10000, parallel:true, iterateList:true})`
-	_, err := session.Run(context.TODO(), linkVpcs, nil)
+		"MERGE (r)-[n:NETWORK]->(v) SET ` + stamp("n") + `",
+		{batchSize:10000, parallel:true, iterateList:true, params: {snapshot: $snapshot}})`
+	_, err := session.Run(context.TODO(), linkVpcs, map[string]interface{}{"snapshot": nc.snapshot})
 	if err != nil {
 		nc.logger.Error("Error on executing query", "err", err, "query", query, "arguments", linkVpcs)
 	}
 }
+
+// Add nodes and relationships as they are, e.g. the graph snippet of a rule fixture: relationships reference
+// the nodes by their ID in the snippet. Labels and types cannot be parameters, so APOC sets them
+func (nc *Neo4jClient) AddGraph(nodes []graph.PathNode, relationships []graph.PathRelationship) error {
+	snippetNodes := make([]map[string]interface{}, 0, len(nodes))
+	byID := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		if len(n.Labels) == 0 {
+			return fmt.Errorf("node %q without labels", n.ID)
+		}
+		if byID[n.ID] {
+			return fmt.Errorf("duplicated node %q", n.ID)
+		}
+		byID[n.ID] = true
+		snippetNodes = append(snippetNodes, map[string]interface{}{"id": n.ID, "labels": n.Labels, "properties": snippetProperties(n.Properties)})
+	}
+	snippetRelationships := make([]map[string]interface{}, 0, len(relationships))
+	for _, r := range relationships {
+		if !byID[r.From] || !byID[r.To] {
+			return fmt.Errorf("relationship %s from %q to %q: unknown node", r.Type, r.From, r.To)
+		}
+		snippetRelationships = append(snippetRelationships, map[string]interface{}{"type": r.Type, "from": r.From, "to": r.To, "properties": snippetProperties(r.Properties)})
+	}
+
+	session := nc.NewSession()
+	defer func() {
+		if err := session.Close(context.TODO()); err != nil {
+			nc.logger.Error("failed to close session: %v", err)
+		}
+	}()
+	// Relationships are merged by their type and properties, as the in-memory store does
+	query := `UNWIND $nodes AS snippet
+		CALL apoc.create.node(snippet.labels, snippet.properties) YIELD node
+		SET ` + stamp("node") + `
+		WITH apoc.map.fromPairs(collect([snippet.id, node])) AS byId
+		UNWIND $relationships AS r
+		WITH byId[r.from] AS from, byId[r.to] AS to, r
+		CALL apoc.merge.relationship(from, r.type, r.properties, {}, to, {}) YIELD rel
+		SET ` + stamp("rel")
+	_, err := session.ExecuteWrite(context.TODO(), func(tx neo4j.ManagedTransaction) (any, error) {
+		result, err := tx.Run(context.TODO(), query, map[string]interface{}{
+			"nodes":         snippetNodes,
+			"relationships": snippetRelationships,
+			"snapshot":      nc.snapshot,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return result.Consume(context.TODO())
+	})
+	if err != nil {
+		return fmt.Errorf("adding graph: %w", err)
+	}
+	return nil
+}
+
+// Neo4j does not store null properties
+func snippetProperties(properties map[string]interface{}) map[string]interface{} {
+	stored := make(map[string]interface{}, len(properties))
+	for key, value := range properties {
+		if value != nil {
+			stored[key] = value
+		}
+	}
+	return stored
+}
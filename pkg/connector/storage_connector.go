@@ -4,14 +4,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+
+	awscatalog "github.com/primait/nuvola/pkg/connector/services/aws/catalog"
 	"github.com/primait/nuvola/pkg/connector/services/aws/database"
 	"github.com/primait/nuvola/pkg/connector/services/aws/ec2"
 	"github.com/primait/nuvola/pkg/connector/services/aws/iam"
 	"github.com/primait/nuvola/pkg/connector/services/aws/lambda"
+	"github.com/primait/nuvola/pkg/connector/services/aws/organizations"
 	"github.com/primait/nuvola/pkg/connector/services/aws/s3"
+	"github.com/primait/nuvola/pkg/connector/services/graph"
+	memory "github.com/primait/nuvola/pkg/connector/services/memory"
 	neo4j "github.com/primait/nuvola/pkg/connector/services/neo4j"
 	"github.com/primait/nuvola/pkg/io/logging"
+	"github.com/primait/nuvola/tools/yamler"
 	"github.com/spf13/viper"
 )
 
@@ -24,21 +32,51 @@ func NewStorageConnector() *StorageConnector {
 		logger.Error("Error connecting to database", "err", err)
 	}
 	connector := &StorageConnector{
-		Client: *client,
+		Client: client,
 		logger: logger,
 	}
+	connector.newSnapshot()
+	return connector
+}
+
+// Store the graph in process, without Neo4j: the data must be imported on every run
+func NewMemoryStorageConnector() *StorageConnector {
+	connector := &StorageConnector{
+		Client: memory.NewMemoryClient(),
+		logger: logging.GetLogManager(),
+	}
+	connector.newSnapshot()
 	return connector
 }
 
+// All the accounts imported by the connector belong to the same snapshot
+func (sc *StorageConnector) newSnapshot() {
+	now := time.Now()
+	sc.snapshotID = graph.NewSnapshotID(now)
+	sc.Client.SetSnapshot(sc.snapshotID, now)
+}
+
 func (sc *StorageConnector) FlushAll() *StorageConnector {
 	sc.logger.Info("Flushing the database")
 	sc.Client.DeleteAll()
 	return sc
 }
 
+// Prepare the database for an import: the data is updated in place, keeping the other accounts, unless flush is set
+func (sc *StorageConnector) BeginImport(flush bool) *StorageConnector {
+	if flush {
+		return sc.FlushAll()
+	}
+	sc.logger.Info("Importing snapshot", "snapshot", sc.snapshotID)
+	sc.Client.CreateSchema()
+	return sc
+}
+
 func (sc *StorageConnector) ImportResults(what string, content []byte) {
 	var whoami = regexp.MustCompile(`^Whoami`)
+	var catalog = regexp.MustCompile(`^Catalog`)
 	var credentialReport = regexp.MustCompile(`^CredentialReport`)
+	var organization = regexp.MustCompile(`^Organization`)
 	var users = regexp.MustCompile(`^Users`)
 	var groups = regexp.MustCompile(`^Groups`)
 	var roles = regexp.MustCompile(`^Roles`)
@@ -53,7 +91,26 @@ func (sc *StorageConnector) ImportResults(what string, content []byte) {
 	sc.logger.Debug(fmt.Sprintf("Importing: %s", what))
 	switch {
 	case whoami.MatchString(what):
+		contentStruct := struct{ Account, Arn string }{}
+		_ = json.Unmarshal(content, &contentStruct)
+		sc.accountID = contentStruct.Account
+		if sc.accountID != "" {
+			partition := "aws"
+			if identity, err := arn.Parse(contentStruct.Arn); err == nil {
+				partition = identity.Partition
+			}
+			sc.Client.AddAccount(partition, sc.accountID)
+		}
+	case catalog.MatchString(what):
+		contentStruct := awscatalog.Info{}
+		_ = json.Unmarshal(content, &contentStruct)
+		sc.logger.Info("Actions expanded with catalog", "version", contentStruct.Version, "updated", contentStruct.Updated)
+		sc.catalog = &contentStruct
 	case credentialReport.MatchString(what):
+	case organization.MatchString(what):
+		contentStruct := organizations.Organization{}
+		_ = json.Unmarshal(content, &contentStruct)
+		sc.Client.AddOrganization(&contentStruct)
 	case users.MatchString(what):
 		contentStruct := []iam.User{}
 		_ = json.Unmarshal(content, &contentStruct)
@@ -101,6 +158,46 @@ func (sc *StorageConnector) ImportResults(what string, content []byte) {
 	sc.logger.Info(fmt.Sprintf("Imported: %s", what))
 }
 
+// Mark all the nodes imported since the last call with the account ID of the dump (from Whoami) and remove
+// what disappeared from the account since the previous import
+func (sc *StorageConnector) TagAccount() {
+	if sc.accountID == "" {
+		return
+	}
+	sc.logger.Debug(fmt.Sprintf("Tagging nodes of account: %s", sc.accountID))
+	sc.Client.SetAccountID(sc.accountID)
+	// The files of a dump are not imported in order: the catalog is recorded once the account is known
+	if sc.catalog != nil {
+		sc.Client.SetCatalog(sc.accountID, *sc.catalog)
+	}
+	if removed := sc.Client.RemoveStale(sc.accountID); removed > 0 {
+		sc.logger.Info("Removed stale nodes and relationships", "account", sc.accountID, "count", removed)
+	}
+	sc.accountID, sc.catalog = "", nil
+}
+
+// Effective permissions are computed once all the accounts and resources are imported: they are rebuilt from the
+// whole graph, so computing them for each account would rebuild them once per account
+func (sc *StorageConnector) AddEffectivePermissions() {
+	sc.logger.Debug("Computing effective permissions")
+	sc.Client.AddEffectivePermissions()
+}
+
+// Privilege escalations are computed once all the accounts are imported: techniques can cross accounts
+func (sc *StorageConnector) AddPrivilegeEscalations() {
+	sc.logger.Debug("Computing privilege escalation techniques")
+	sc.Client.AddPrivescEdges()
+}
+
+// Load the nodes and relationships of a graph snippet as they are: only the in-memory store supports it
+func (sc *StorageConnector) ImportGraph(nodes []graph.PathNode, relationships []graph.PathRelationship) error {
+	importer, ok := sc.Client.(GraphImporter)
+	if !ok {
+		return fmt.Errorf("the store does not support graph snippets")
+	}
+	return importer.AddGraph(nodes, relationships)
+}
+
 func (sc *StorageConnector) ImportBulkResults(content map[string]interface{}) {
 	for k, v := range content {
 		value, err := json.Marshal(v)
@@ -111,6 +208,33 @@ func (sc *StorageConnector) ImportBulkResults(content map[string]interface{}) {
 	}
 }
 
-func (sc *StorageConnector) Query(query string, arguments map[string]interface{}) []map[string]interface{} {
+func (sc *StorageConnector) Query(query string, arguments map[string]interface{}) ([]map[string]interface{}, error) {
 	return sc.Client.Query(query, arguments)
 }
+
+// Run the query of a rule, or evaluate the rule directly when the store does not support Cypher. The results of
+// cypher, services and privilege escalation rules are their records by column (see yamler.RecordValues and
+// yamler.PathColumn), the others are nodes. The rules the store cannot evaluate, as the cypher ones in memory, are
+// errors: they are not clean
+func (sc *StorageConnector) QueryRule(rule *yamler.Conf, query string, arguments map[string]interface{}) ([]map[string]interface{}, error) {
+	if evaluator, ok := sc.Client.(RuleEvaluator); ok {
+		return evaluator.EvaluateRule(rule)
+	}
+	if rule.Cypher != "" || len(rule.Services) > 0 || len(rule.Find.Target) > 0 {
+		return sc.Client.QueryRecords(query, arguments)
+	}
+	return sc.Client.Query(query, arguments)
+}
+
+// The paths of a privilege escalation rule, or the ones returned by a cypher rule, to draw them: other rules
+// return nodes only
+func (sc *StorageConnector) QueryRulePaths(rule *yamler.Conf) ([]graph.Path, error) {
+	if evaluator, ok := sc.Client.(RuleEvaluator); ok && rule.Cypher == "" {
+		return evaluator.EvaluateRulePaths(rule), nil
+	}
+	query, arguments := yamler.PreparePathsQuery(rule)
+	if query == "" {
+		return nil, nil
+	}
+	return sc.Client.QueryPaths(query, arguments)
+}
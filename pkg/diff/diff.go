@@ -0,0 +1,115 @@
+package diff
+
+import (
+	"encoding/json"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+type Change struct {
+	Kind    string
+	ID      string
+	Account string   `json:",omitempty"`
+	Fields  []string `json:",omitempty"`
+}
+
+// A result of an assessment rule, identified by the matched node (its Arn, or its Name without one) and the values
+// of the rule Return keys
+type Finding struct {
+	Rule   string
+	ID     string `json:",omitempty"`
+	Values map[string]interface{}
+}
+
+type Report struct {
+	Added               []Change
+	Removed             []Change
+	Modified            []Change
+	FindingsAppeared    []Finding
+	FindingsDisappeared []Finding
+}
+
+// Compare the entities of two dumps: modified entities report the top level fields that changed
+func Compare(previous, current Snapshot) *Report {
+	report := &Report{Added: []Change{}, Removed: []Change{}, Modified: []Change{}}
+	for _, kind := range Kinds {
+		for _, id := range slices.Sorted(maps.Keys(current[kind])) {
+			after := current[kind][id]
+			before, ok := previous[kind][id]
+			if !ok {
+				report.Added = append(report.Added, Change{Kind: kind, ID: id, Account: after.account})
+				continue
+			}
+			if fields := changedFields(before.value, after.value); len(fields) > 0 {
+				report.Modified = append(report.Modified, Change{Kind: kind, ID: id, Account: after.account, Fields: fields})
+			}
+		}
+		for _, id := range slices.Sorted(maps.Keys(previous[kind])) {
+			if _, ok := current[kind][id]; !ok {
+				report.Removed = append(report.Removed, Change{Kind: kind, ID: id, Account: previous[kind][id].account})
+			}
+		}
+	}
+	return report
+}
+
+func changedFields(before, after map[string]interface{}) (fields []string) {
+	keys := slices.Collect(maps.Keys(before))
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if !reflect.DeepEqual(before[key], after[key]) {
+			fields = append(fields, key)
+		}
+	}
+	return
+}
+
+// Add the rule findings that appeared and disappeared between the two dumps
+func (r *Report) CompareFindings(previous, current []Finding) {
+	r.FindingsAppeared = subtractFindings(current, previous)
+	r.FindingsDisappeared = subtractFindings(previous, current)
+}
+
+func subtractFindings(findings, others []Finding) []Finding {
+	seen := make(map[string]bool)
+	for _, finding := range others {
+		seen[finding.key()] = true
+	}
+	result := []Finding{}
+	for _, finding := range findings {
+		if !seen[finding.key()] {
+			seen[finding.key()] = true
+			result = append(result, finding)
+		}
+	}
+	return result
+}
+
+func (f *Finding) key() string {
+	values, _ := json.Marshal(f.Values) // map keys are sorted
+	return f.Rule + "\x00" + f.ID + "\x00" + string(values)
+}
+
+// The matched node followed by the values of the finding as key=value pairs sorted by key
+func (f *Finding) String() string {
+	pairs := []string{}
+	for _, key := range slices.Sorted(maps.Keys(f.Values)) {
+		content, _ := json.Marshal(f.Values[key])
+		pairs = append(pairs, key+"="+strings.Trim(string(content), `"`))
+	}
+	values := strings.Join(pairs, ", ")
+	switch {
+	case f.ID == "":
+		return values
+	case values == "":
+		return f.ID
+	}
+	return f.ID + " (" + values + ")"
+}
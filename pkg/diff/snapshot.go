@@ -0,0 +1,213 @@
+package diff
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/primait/nuvola/pkg/connector/services/aws/ec2"
+	"github.com/primait/nuvola/pkg/connector/services/aws/iam"
+	"github.com/primait/nuvola/pkg/connector/services/aws/s3"
+	"github.com/primait/nuvola/pkg/io/logging"
+	unzip "github.com/primait/nuvola/tools/filesystem/zip"
+)
+
+// Kinds of the entities compared between two dumps, in the order they are reported
+const (
+	KindUser          = "User"
+	KindGroup         = "Group"
+	KindRole          = "Role"
+	KindPolicy        = "Policy"
+	KindStatement     = "Statement"
+	KindBucket        = "Bucket"
+	KindSecurityGroup = "SecurityGroup"
+	KindInstance      = "Instance"
+)
+
+var Kinds = []string{KindUser, KindGroup, KindRole, KindPolicy, KindStatement, KindBucket, KindSecurityGroup, KindInstance}
+
+// Fields changing on every dump without a change of configuration
+var volatileFields = []string{"RoleLastUsed", "PasswordLastUsed"}
+
+type entity struct {
+	account string
+	value   map[string]interface{}
+}
+
+// The entities of a dump archive by kind and identifier
+type Snapshot map[string]map[string]entity
+
+// Load the entities of an archive produced by zip.Zip or zip.ZipAccounts
+func Load(zipfile string) Snapshot {
+	logger := logging.GetLogManager()
+	r := unzip.UnzipInMemory(zipfile)
+	defer func() {
+		if err := r.Close(); err != nil {
+			logger.Error("failed to close r: %v", err)
+		}
+	}()
+
+	// Archives created with --org-role have a folder for each account
+	accounts := make(map[string]map[string][]byte)
+	for _, f := range r.File {
+		content, err := readZipFile(f)
+		if err != nil {
+			logger.Error("Reading ZIP file", "file", f.Name, "err", err)
+		}
+		key, _, _ := strings.Cut(path.Base(f.Name), "_")
+		if accounts[path.Dir(f.Name)] == nil {
+			accounts[path.Dir(f.Name)] = make(map[string][]byte)
+		}
+		accounts[path.Dir(f.Name)][key] = content
+	}
+
+	snapshot := make(Snapshot)
+	for _, files := range accounts {
+		whoami := struct{ Account string }{}
+		_ = json.Unmarshal(files["Whoami"], &whoami)
+		snapshot.addIAM(whoami.Account, files)
+		snapshot.addResources(whoami.Account, files)
+	}
+	return snapshot
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("opening content of ZIP: %w", err)
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+	return io.ReadAll(rc) // #nosecG110
+}
+
+func (s Snapshot) add(kind, id, account string, value interface{}, overrides map[string]interface{}) {
+	if id == "" {
+		return
+	}
+	properties, err := toProperties(value)
+	var replaced map[string]interface{}
+	if err == nil {
+		replaced, err = toProperties(overrides)
+	}
+	if err != nil {
+		logging.GetLogManager().Warn("Error on reading entity", "kind", kind, "id", id, "err", err)
+		return
+	}
+	for _, field := range volatileFields {
+		delete(properties, field)
+	}
+	for k, v := range replaced {
+		properties[k] = v
+	}
+	if s[kind] == nil {
+		s[kind] = make(map[string]entity)
+	}
+	s[kind][id] = entity{account: account, value: properties}
+}
+
+// The JSON representation of the dump, to compare values regardless of their Go types
+func toProperties(value interface{}) (properties map[string]interface{}, err error) {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, &properties)
+	return
+}
+
+func (s Snapshot) addIAM(account string, files map[string][]byte) {
+	users := []iam.User{}
+	_ = json.Unmarshal(files["Users"], &users)
+	for i := range users {
+		arn := aws.ToString(users[i].Arn)
+		s.add(KindUser, arn, account, users[i], s.addPolicies(arn, account, users[i].AttachedPolicies, users[i].InlinePolicies, users[i].BoundaryPolicy))
+	}
+
+	groups := []iam.Group{}
+	_ = json.Unmarshal(files["Groups"], &groups)
+	for i := range groups {
+		arn := aws.ToString(groups[i].Arn)
+		s.add(KindGroup, arn, account, groups[i], s.addPolicies(arn, account, groups[i].AttachedPolicies, groups[i].InlinePolicies, nil))
+	}
+
+	roles := []iam.Role{}
+	_ = json.Unmarshal(files["Roles"], &roles)
+	for i := range roles {
+		arn := aws.ToString(roles[i].Arn)
+		s.add(KindRole, arn, account, roles[i], s.addPolicies(arn, account, roles[i].AttachedPolicies, roles[i].InlinePolicies, roles[i].BoundaryPolicy))
+	}
+}
+
+// Policies and their statements are compared on their own: principals only keep the references to them
+func (s Snapshot) addPolicies(principal, account string, attached []iam.AttachedPolicies, inline []iam.PolicyDocument, boundary *iam.AttachedPolicies) map[string]interface{} {
+	attachedArns := []string{}
+	for i := range attached {
+		attachedArns = append(attachedArns, s.addAttachedPolicy(account, &attached[i]))
+	}
+	inlineNames := []string{}
+	for i := range inline {
+		s.addPolicy(fmt.Sprintf("%s/%s", principal, inline[i].PolicyName), account, &inline[i])
+		inlineNames = append(inlineNames, inline[i].PolicyName)
+	}
+	references := map[string]interface{}{"AttachedPolicies": attachedArns, "InlinePolicies": inlineNames}
+	if boundary != nil {
+		references["BoundaryPolicy"] = s.addAttachedPolicy(account, boundary)
+	}
+	return references
+}
+
+func (s Snapshot) addAttachedPolicy(account string, policy *iam.AttachedPolicies) string {
+	arn := aws.ToString(policy.PolicyArn)
+	if len(policy.Versions) > 0 {
+		s.addPolicy(arn, account, &policy.Versions[0].Document)
+	}
+	return arn
+}
+
+// Statements without a Sid are identified by their content: a change shows as a removal and an addition
+func (s Snapshot) addPolicy(id, account string, document *iam.PolicyDocument) {
+	statements := []string{}
+	for _, statement := range document.Statement {
+		statementID := statement.Sid
+		if statementID == "" {
+			content, _ := json.Marshal(statement)
+			hash := sha256.Sum256(content)
+			statementID = hex.EncodeToString(hash[:])[:12]
+		}
+		statementID = fmt.Sprintf("%s#%s", id, statementID)
+		s.add(KindStatement, statementID, account, statement, nil)
+		statements = append(statements, statementID)
+	}
+	s.add(KindPolicy, id, account, document, map[string]interface{}{"Statement": statements})
+}
+
+func (s Snapshot) addResources(account string, files map[string][]byte) {
+	buckets := []s3.Bucket{}
+	_ = json.Unmarshal(files["Buckets"], &buckets)
+	for i := range buckets {
+		s.add(KindBucket, aws.ToString(buckets[i].Name), account, buckets[i], nil)
+	}
+
+	instances := []ec2.Instance{}
+	_ = json.Unmarshal(files["EC2s"], &instances)
+	for i := range instances {
+		// Security groups are only dumped with the network interfaces using them
+		networkInterfaces := []types.InstanceNetworkInterface{}
+		for _, networkInterface := range instances[i].NetworkInterfaces {
+			for _, securityGroup := range networkInterface.SecurityGroup {
+				s.add(KindSecurityGroup, aws.ToString(securityGroup.GroupId), account, securityGroup, nil)
+			}
+			networkInterfaces = append(networkInterfaces, networkInterface.InstanceNetworkInterface)
+		}
+		s.add(KindInstance, aws.ToString(instances[i].InstanceId), account, instances[i], map[string]interface{}{"NetworkInterfaces": networkInterfaces})
+	}
+}
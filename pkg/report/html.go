@@ -0,0 +1,150 @@
+package report
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/primait/nuvola/pkg/connector/services/graph"
+)
+
+// A single page without external resources: styles and scripts are inline
+//
+//go:embed report.html.tmpl
+var htmlTemplate string
+
+// The paths of a principal merged in a single graph
+type htmlGraph struct {
+	Title string     `json:"title"`
+	Nodes []htmlNode `json:"nodes"`
+	Links []htmlLink `json:"links"`
+	index map[string]int
+	links map[string]bool
+}
+
+type htmlNode struct {
+	ID      string `json:"id"`
+	Label   string `json:"label"`
+	Kind    string `json:"kind"`
+	Details string `json:"details"`
+}
+
+type htmlLink struct {
+	Source      string `json:"source"`
+	Target      string `json:"target"`
+	Type        string `json:"type"`
+	Conditional bool   `json:"conditional"`
+}
+
+type htmlRule struct {
+	*Result
+	Anchor string
+	Graphs []*htmlGraph
+}
+
+// Labels shown as the kind of a node, by priority: the others are only in its details
+var nodeKinds = []string{"User", "Role", "Group", "Policy", "Action", "Account", "AWSService", "Service"}
+
+// Write a self-contained HTML page: a summary of the rules, the findings of each rule and the graphs of their
+// paths, with the suppressed matches apart and the expired suppressions flagged
+func WriteHTML(w io.Writer, results []Result, expired []Suppression) error {
+	page, err := template.New("report").Parse(htmlTemplate)
+	if err != nil {
+		return err
+	}
+
+	rules := make([]htmlRule, 0, len(results))
+	// The graphs drawn by the script, by the id of their svg element
+	byElement := make(map[string]*htmlGraph)
+	findings := 0
+	for i := range results {
+		rule := htmlRule{Result: &results[i], Anchor: fmt.Sprintf("rule-%d", i), Graphs: graphs(results[i].Paths)}
+		for j, g := range rule.Graphs {
+			byElement[fmt.Sprintf("%s-graph-%d", rule.Anchor, j)] = g
+		}
+		rules = append(rules, rule)
+		findings += len(results[i].Matches)
+	}
+
+	return page.Execute(w, map[string]interface{}{
+		"Generated": time.Now().UTC().Format(time.RFC1123),
+		"Rules":     rules,
+		"Findings":  findings,
+		"Graphs":    byElement,
+		"Expired":   expired,
+	})
+}
+
+// One graph for each principal: the first node of its paths
+func graphs(paths []graph.Path) (graphs []*htmlGraph) {
+	byPrincipal := make(map[string]*htmlGraph)
+	for _, path := range paths {
+		if len(path.Nodes) == 0 {
+			continue
+		}
+		principal := path.Nodes[0]
+		g, ok := byPrincipal[principal.ID]
+		if !ok {
+			g = &htmlGraph{Title: nodeLabel(&principal), Nodes: []htmlNode{}, Links: []htmlLink{}, index: make(map[string]int), links: make(map[string]bool)}
+			byPrincipal[principal.ID] = g
+			graphs = append(graphs, g)
+		}
+		for j := range path.Nodes {
+			g.addNode(&path.Nodes[j])
+		}
+		for _, r := range path.Relationships {
+			key := r.From + r.Type + r.To
+			if !g.links[key] {
+				g.links[key] = true
+				conditional, _ := r.Properties["Conditional"].(bool)
+				g.Links = append(g.Links, htmlLink{Source: r.From, Target: r.To, Type: r.Type, Conditional: conditional})
+			}
+		}
+	}
+	return
+}
+
+func (g *htmlGraph) addNode(n *graph.PathNode) {
+	if _, ok := g.index[n.ID]; ok {
+		return
+	}
+	details := fmt.Sprintf("%v", n.Labels)
+	for _, key := range []string{"Arn", "AccountId", "Type", "Service", "Action"} {
+		if value, ok := n.Properties[key]; ok {
+			details += fmt.Sprintf("\n%s: %v", key, value)
+		}
+	}
+	g.index[n.ID] = len(g.Nodes)
+	g.Nodes = append(g.Nodes, htmlNode{ID: n.ID, Label: nodeLabel(n), Kind: nodeKind(n), Details: details})
+}
+
+// The first label of nodeKinds, or the first label of the node
+func nodeKind(n *graph.PathNode) string {
+	for _, label := range nodeKinds {
+		if slices.Contains(n.Labels, label) {
+			return label
+		}
+	}
+	if len(n.Labels) == 0 {
+		return ""
+	}
+	return n.Labels[0]
+}
+
+func nodeLabel(n *graph.PathNode) string {
+	for _, key := range []string{"UserName", "RoleName", "GroupName", "Name"} {
+		if name, ok := n.Properties[key].(string); ok && name != "" {
+			return name
+		}
+	}
+	if service, ok := n.Properties["Service"].(string); ok {
+		return fmt.Sprintf("%s:%v", service, n.Properties["Action"])
+	}
+	if arn, ok := n.Properties["Arn"].(string); ok {
+		return arn
+	}
+	return n.ID
+}
@@ -0,0 +1,131 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+	// The expired suppressions
+	SystemErr string `xml:"system-err,omitempty"`
+}
+
+type junitTestCase struct {
+	Name       string          `xml:"name,attr"`
+	ClassName  string          `xml:"classname,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Failure    *junitFailure   `xml:"failure,omitempty"`
+	// Why the rule was not evaluated
+	Error *junitFailure `xml:"error,omitempty"`
+	// The suppressed matches
+	SystemOut string `xml:"system-out,omitempty"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// Each enabled rule is a test case, failed by its findings: the suppressed ones are only in its output. A rule not
+// evaluated is an error
+func writeJUnit(w io.Writer, results []Result, expired []Suppression) error {
+	suite := junitTestSuite{Name: "nuvola", Tests: len(results)}
+	for i := range results {
+		result := &results[i]
+		testCase := junitTestCase{Name: result.Rule, ClassName: result.File, Properties: junitProperties(result)}
+		if result.HasFindings() {
+			var text strings.Builder
+			text.WriteString(result.Description + "\n")
+			for _, match := range result.Matches {
+				text.WriteString(match.String() + "\n")
+				if steps := match.Steps(); steps != "" {
+					text.WriteString("    " + steps + "\n")
+				}
+			}
+			if result.Remediation != "" {
+				text.WriteString(fmt.Sprintf("\nRemediation:\n%s\n", strings.TrimSpace(result.Remediation)))
+			}
+			text.WriteString(fmt.Sprintf("\nQuery:\n%s\nArguments: %s\n", result.Query, compactJSON(result.Arguments)))
+			testCase.Failure = &junitFailure{
+				Message: fmt.Sprintf("%d findings", len(result.Matches)),
+				Type:    result.Severity,
+				Text:    text.String(),
+			}
+			suite.Failures++
+		}
+		if !result.Evaluated() {
+			testCase.Error = &junitFailure{
+				Message: "not evaluated",
+				Type:    "not-evaluated",
+				Text:    strings.Join(result.NotEvaluated, "\n") + "\n",
+			}
+			suite.Errors++
+		}
+		if len(result.Suppressed) > 0 {
+			var text strings.Builder
+			for _, suppressed := range result.Suppressed {
+				text.WriteString(fmt.Sprintf("Suppressed %s: %s\n", suppressed.Match.String(), suppressed.Suppression.String()))
+			}
+			testCase.SystemOut = text.String()
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	for i := range expired {
+		suite.SystemErr += "Expired suppression: " + expired[i].String() + "\n"
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "    ")
+	if err := encoder.Encode(junitTestSuites{Name: "nuvola", Tests: suite.Tests, Failures: suite.Failures, Errors: suite.Errors, Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// The metadata of the rule: multiple values of the same property are repeated
+func junitProperties(result *Result) []junitProperty {
+	properties := []junitProperty{{Name: "severity", Value: result.Severity}}
+	if result.Category != "" {
+		properties = append(properties, junitProperty{Name: "category", Value: result.Category})
+	}
+	for _, tag := range result.Tags {
+		properties = append(properties, junitProperty{Name: "tag", Value: tag})
+	}
+	for _, reference := range result.References {
+		properties = append(properties, junitProperty{Name: "reference", Value: reference})
+	}
+	for _, framework := range slices.Sorted(maps.Keys(result.Compliance)) {
+		for _, control := range result.Compliance[framework] {
+			properties = append(properties, junitProperty{Name: framework, Value: control})
+		}
+	}
+	return properties
+}
@@ -0,0 +1,192 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/primait/nuvola/pkg/connector/services/graph"
+)
+
+// The outcome of an enabled rule: it has findings if any node matched
+type Result struct {
+	Rule        string
+	File        string
+	Description string
+	Severity    string
+	Category    string              `json:",omitempty"`
+	Tags        []string            `json:",omitempty"`
+	References  []string            `json:",omitempty"`
+	Remediation string              `json:",omitempty"`
+	Compliance  map[string][]string `json:",omitempty"`
+	Query       string
+	Arguments   map[string]interface{}
+	Matches     []Match
+	// Matches accepted by a suppression: they are not findings
+	Suppressed []SuppressedMatch `json:",omitempty"`
+	// Only for privilege escalation rules, when requested
+	Paths []graph.Path `json:",omitempty"`
+	// Why the rule was not evaluated (the problems of a malformed rule, the error of its query, or a cypher rule in
+	// the in-memory store): it has no matches, but it is not clean
+	NotEvaluated []string `json:",omitempty"`
+}
+
+// A node matched by a rule, with the values selected by the Return keys of the rule. The matches of privilege
+// escalation rules are principals with one of their paths to a target
+type Match struct {
+	Arn        string                 `json:",omitempty"`
+	Name       string                 `json:",omitempty"`
+	Values     map[string]interface{} `json:",omitempty"`
+	Path       *graph.Path            `json:",omitempty"`
+	Properties map[string]interface{} `json:"-"`
+}
+
+const (
+	FormatText  = "text"
+	FormatJSON  = "json"
+	FormatSARIF = "sarif"
+	FormatJUnit = "junit"
+)
+
+var Formats = []string{FormatText, FormatJSON, FormatSARIF, FormatJUnit}
+
+// The Arn identifies the matched node when it has one, otherwise its Name: inline policies have no Arn and
+// actions are named service:action
+func NewMatch(properties map[string]interface{}, values map[string]interface{}) Match {
+	match := Match{Values: values, Properties: properties}
+	match.Arn, _ = properties["Arn"].(string)
+	if match.Arn == "" {
+		match.Name, _ = properties["Name"].(string)
+		if service, ok := properties["Service"].(string); ok && match.Name == "" {
+			match.Name = fmt.Sprintf("%s:%v", service, properties["Action"])
+		}
+	}
+	return match
+}
+
+// A record of a cypher rule, identified by the values of its identifier columns: nodes by their Arn or Name.
+// A single ARN is the Arn of the match
+func NewRecordMatch(record map[string]interface{}, identifiers []string, values map[string]interface{}) Match {
+	match := Match{Values: values, Properties: record}
+	names := make([]string, 0, len(identifiers))
+	for _, column := range identifiers {
+		switch value := record[column].(type) {
+		case nil:
+		case map[string]interface{}:
+			node := NewMatch(value, value)
+			names = append(names, node.String())
+		default:
+			names = append(names, fmt.Sprintf("%v", value))
+		}
+	}
+	if len(names) == 1 && strings.HasPrefix(names[0], "arn:") {
+		match.Arn = names[0]
+	} else {
+		match.Name = strings.Join(names, " ")
+	}
+	return match
+}
+
+// A principal of a privilege escalation rule with its path to a target
+func NewPathMatch(principal map[string]interface{}, values map[string]interface{}, path graph.Path) Match {
+	match := NewMatch(principal, values)
+	match.Path = &path
+	return match
+}
+
+func (r *Result) HasFindings() bool {
+	return len(r.Matches) > 0
+}
+
+func (r *Result) Evaluated() bool {
+	return len(r.NotEvaluated) == 0
+}
+
+// The name of the match in messages
+func (m *Match) String() string {
+	switch {
+	case m.Arn != "":
+		return m.Arn
+	case m.Name != "":
+		return m.Name
+	}
+	return string(compactJSON(m.Values))
+}
+
+// The steps of the path of the match, e.g. "alice (User) -[CAN_ASSUME]-> admin (Role)": "" without a path
+func (m *Match) Steps() string {
+	if m.Path == nil || len(m.Path.Nodes) == 0 {
+		return ""
+	}
+	var steps strings.Builder
+	steps.WriteString(nodeStep(&m.Path.Nodes[0]))
+	for i, r := range m.Path.Relationships {
+		steps.WriteString(fmt.Sprintf(" -[%s]-> %s", r.Type, nodeStep(&m.Path.Nodes[i+1])))
+	}
+	return steps.String()
+}
+
+// A resource is shown with its service label (e.g. "prod-data (S3)") rather than Service
+func nodeStep(n *graph.PathNode) string {
+	kind := nodeKind(n)
+	if i := slices.IndexFunc(graph.ServiceLabels, func(label string) bool { return slices.Contains(n.Labels, label) }); i >= 0 {
+		kind = graph.ServiceLabels[i]
+	}
+	return fmt.Sprintf("%s (%s)", nodeLabel(n), kind)
+}
+
+func HasFindings(results []Result) bool {
+	for i := range results {
+		if results[i].HasFindings() {
+			return true
+		}
+	}
+	return false
+}
+
+// The rules that were not evaluated
+func NotEvaluated(results []Result) (rules []string) {
+	for i := range results {
+		if !results[i].Evaluated() {
+			rules = append(rules, results[i].Rule)
+		}
+	}
+	return rules
+}
+
+// Write the results in a machine-readable format: JSON has only the rules with findings, suppressed matches or not
+// evaluated. The expired suppressions and the rules not evaluated are flagged in every format
+func Write(w io.Writer, format string, results []Result, expired []Suppression) error {
+	switch format {
+	case FormatSARIF:
+		return writeSARIF(w, results, expired)
+	case FormatJUnit:
+		return writeJUnit(w, results, expired)
+	default:
+		findings := []Result{}
+		for i := range results {
+			if results[i].HasFindings() || len(results[i].Suppressed) > 0 || !results[i].Evaluated() {
+				findings = append(findings, results[i])
+			}
+		}
+		output := map[string]interface{}{"Findings": findings}
+		if len(expired) > 0 {
+			output["ExpiredSuppressions"] = expired
+		}
+		return writeJSON(w, output)
+	}
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(v)
+}
+
+func compactJSON(v interface{}) []byte {
+	content, _ := json.Marshal(v)
+	return content
+}
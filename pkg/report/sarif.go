@@ -0,0 +1,269 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/primait/nuvola/pkg/connector/services/graph"
+)
+
+// The subset of SARIF 2.1.0 used by the findings: a single run with a reporting descriptor for each rule
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+)
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool        sarifTool         `json:"tool"`
+	Invocations []sarifInvocation `json:"invocations,omitempty"`
+	Results     []sarifResult     `json:"results"`
+}
+
+type sarifInvocation struct {
+	ExecutionSuccessful            bool                `json:"executionSuccessful"`
+	ToolConfigurationNotifications []sarifNotification `json:"toolConfigurationNotifications,omitempty"`
+	ToolExecutionNotifications     []sarifNotification `json:"toolExecutionNotifications,omitempty"`
+}
+
+type sarifNotification struct {
+	Level          string              `json:"level"`
+	Message        sarifMessage        `json:"message"`
+	Locations      []sarifLocation     `json:"locations,omitempty"`
+	AssociatedRule *sarifRuleReference `json:"associatedRule,omitempty"`
+}
+
+type sarifRuleReference struct {
+	ID    string `json:"id"`
+	Index int    `json:"index"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string                 `json:"id"`
+	Name                 string                 `json:"name"`
+	ShortDescription     sarifMessage           `json:"shortDescription"`
+	Help                 *sarifMessage          `json:"help,omitempty"`
+	HelpURI              string                 `json:"helpUri,omitempty"`
+	DefaultConfiguration sarifConfiguration     `json:"defaultConfiguration"`
+	Properties           map[string]interface{} `json:"properties,omitempty"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID       string                 `json:"ruleId"`
+	RuleIndex    int                    `json:"ruleIndex"`
+	Level        string                 `json:"level"`
+	Message      sarifMessage           `json:"message"`
+	Locations    []sarifLocation        `json:"locations"`
+	CodeFlows    []sarifCodeFlow        `json:"codeFlows,omitempty"`
+	Suppressions []sarifSuppression     `json:"suppressions,omitempty"`
+	Properties   map[string]interface{} `json:"properties,omitempty"`
+}
+
+// The path of a privilege escalation finding: a location for each node, with the relationship leaving it
+type sarifCodeFlow struct {
+	ThreadFlows []sarifThreadFlow `json:"threadFlows"`
+}
+
+type sarifThreadFlow struct {
+	Locations []sarifThreadFlowLocation `json:"locations"`
+}
+
+type sarifThreadFlowLocation struct {
+	Location sarifFlowLocation `json:"location"`
+}
+
+type sarifFlowLocation struct {
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+	Message          *sarifMessage          `json:"message,omitempty"`
+}
+
+type sarifSuppression struct {
+	Kind          string                 `json:"kind"`
+	Status        string                 `json:"status"`
+	Justification string                 `json:"justification"`
+	Properties    map[string]interface{} `json:"properties,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation,omitempty"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifLogicalLocation struct {
+	Name               string `json:"name,omitempty"`
+	FullyQualifiedName string `json:"fullyQualifiedName,omitempty"`
+	Kind               string `json:"kind"`
+}
+
+// SARIF levels and the security-severity scores used by GitHub code scanning for each severity of the rules
+var sarifSeverities = map[string]struct {
+	level string
+	score string
+}{
+	"info":     {"note", "0.0"},
+	"low":      {"note", "3.0"},
+	"medium":   {"warning", "5.0"},
+	"high":     {"error", "8.0"},
+	"critical": {"error", "9.5"},
+}
+
+func newSARIFRule(result *Result) sarifRule {
+	severity, ok := sarifSeverities[result.Severity]
+	if !ok {
+		severity = sarifSeverities["medium"]
+	}
+	rule := sarifRule{
+		ID:                   result.Rule,
+		Name:                 result.Rule,
+		ShortDescription:     sarifMessage{Text: result.Description},
+		DefaultConfiguration: sarifConfiguration{Level: severity.level},
+		Properties: map[string]interface{}{
+			"tags":              append([]string{"security"}, result.Tags...),
+			"security-severity": severity.score,
+			"severity":          result.Severity,
+			"query":             result.Query,
+			"arguments":         result.Arguments,
+		},
+	}
+	if result.Remediation != "" {
+		rule.Help = &sarifMessage{Text: result.Remediation}
+	}
+	if len(result.References) > 0 {
+		rule.HelpURI = result.References[0]
+		rule.Properties["references"] = result.References
+	}
+	if result.Category != "" {
+		rule.Properties["category"] = result.Category
+	}
+	if len(result.Compliance) > 0 {
+		rule.Properties["compliance"] = result.Compliance
+	}
+	return rule
+}
+
+// Each matched node is a result located in the rule file, and in the AWS resource when it has an Arn. Suppressed
+// matches are accepted results, expired suppressions are warnings of the invocation, and the rules not evaluated are
+// errors failing it
+func writeSARIF(w io.Writer, results []Result, expired []Suppression) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "nuvola",
+			InformationURI: "https://github.com/primait/nuvola",
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+
+	for i := range results {
+		result := &results[i]
+		rule := newSARIFRule(result)
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, rule)
+
+		for _, match := range result.Matches {
+			run.Results = append(run.Results, newSARIFResult(result, i, rule.DefaultConfiguration.Level, &match))
+		}
+		for _, suppressed := range result.Suppressed {
+			sarifResult := newSARIFResult(result, i, rule.DefaultConfiguration.Level, &suppressed.Match)
+			s := suppressed.Suppression
+			suppression := sarifSuppression{Kind: "external", Status: "accepted", Justification: strings.TrimSpace(s.Justification), Properties: map[string]interface{}{"owner": s.Owner}}
+			if s.Expires != "" {
+				suppression.Properties["expires"] = s.Expires
+			}
+			sarifResult.Suppressions = []sarifSuppression{suppression}
+			run.Results = append(run.Results, sarifResult)
+		}
+	}
+
+	invocation := sarifInvocation{ExecutionSuccessful: true}
+	for i := range results {
+		for _, reason := range results[i].NotEvaluated {
+			invocation.ExecutionSuccessful = false
+			invocation.ToolExecutionNotifications = append(invocation.ToolExecutionNotifications, sarifNotification{
+				Level:          "error",
+				Message:        sarifMessage{Text: "Rule not evaluated: " + reason},
+				Locations:      []sarifLocation{{PhysicalLocation: &sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(results[i].File)}}}},
+				AssociatedRule: &sarifRuleReference{ID: results[i].Rule, Index: i},
+			})
+		}
+	}
+	for i := range expired {
+		invocation.ToolConfigurationNotifications = append(invocation.ToolConfigurationNotifications, sarifNotification{
+			Level:     "warning",
+			Message:   sarifMessage{Text: "Expired suppression: " + expired[i].String()},
+			Locations: []sarifLocation{{PhysicalLocation: &sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(expired[i].File)}}}},
+		})
+	}
+	if len(invocation.ToolConfigurationNotifications) > 0 || len(invocation.ToolExecutionNotifications) > 0 {
+		run.Invocations = []sarifInvocation{invocation}
+	}
+
+	return writeJSON(w, sarifLog{Schema: sarifSchema, Version: sarifVersion, Runs: []sarifRun{run}})
+}
+
+func newSARIFResult(result *Result, ruleIndex int, level string, match *Match) sarifResult {
+	location := sarifLocation{
+		PhysicalLocation: &sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(result.File)}},
+	}
+	if match.Arn != "" {
+		location.LogicalLocations = []sarifLogicalLocation{{FullyQualifiedName: match.Arn, Kind: "resource"}}
+	}
+	return sarifResult{
+		RuleID:     result.Rule,
+		RuleIndex:  ruleIndex,
+		Level:      level,
+		Message:    sarifMessage{Text: fmt.Sprintf("%s: %s", result.Description, match.String())},
+		Locations:  []sarifLocation{location},
+		CodeFlows:  sarifCodeFlows(match.Path),
+		Properties: map[string]interface{}{"values": match.Values},
+	}
+}
+
+func sarifCodeFlows(path *graph.Path) []sarifCodeFlow {
+	if path == nil || len(path.Nodes) == 0 {
+		return nil
+	}
+	flow := sarifThreadFlow{}
+	for i := range path.Nodes {
+		n := &path.Nodes[i]
+		name, _ := n.Properties["Arn"].(string)
+		location := sarifFlowLocation{LogicalLocations: []sarifLogicalLocation{{Name: nodeStep(n), FullyQualifiedName: name, Kind: "resource"}}}
+		if i < len(path.Relationships) {
+			location.Message = &sarifMessage{Text: path.Relationships[i].Type}
+		}
+		flow.Locations = append(flow.Locations, sarifThreadFlowLocation{Location: location})
+	}
+	return []sarifCodeFlow{{ThreadFlows: []sarifThreadFlow{flow}}}
+}
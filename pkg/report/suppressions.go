@@ -0,0 +1,142 @@
+package report
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"go.yaml.in/yaml/v3"
+)
+
+// An accepted finding: the matches of Rule identified by Resource (an ARN, or the ID or name of the resource) are
+// reported as suppressed until the end of the Expires day (YYYY-MM-DD), if set
+type Suppression struct {
+	Rule          string `yaml:"rule"`
+	Resource      string `yaml:"resource"`
+	Justification string `yaml:"justification"`
+	Owner         string `yaml:"owner"`
+	Expires       string `yaml:"expires,omitempty" json:",omitempty"`
+	File          string `yaml:"-" json:"-"`
+	Line          int    `yaml:"-" json:"-"`
+	expiry        time.Time
+}
+
+// A match accepted by a suppression
+type SuppressedMatch struct {
+	Match
+	Suppression *Suppression
+}
+
+// Load a YAML list of suppressions: every problem is reported with its line
+func LoadSuppressions(file string) ([]Suppression, error) {
+	content, err := os.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+
+	var suppressions []Suppression
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&suppressions); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	var problems []error
+	for i := range suppressions {
+		s := &suppressions[i]
+		s.File, s.Line = file, document.Content[0].Content[i].Line
+		for _, field := range []struct{ name, value string }{{"rule", s.Rule}, {"resource", s.Resource}, {"justification", s.Justification}, {"owner", s.Owner}} {
+			if strings.TrimSpace(field.value) == "" {
+				problems = append(problems, fmt.Errorf("%s:%d: %s is required", file, s.Line, field.name))
+			}
+		}
+		if s.Expires != "" {
+			if s.expiry, err = time.Parse(time.DateOnly, s.Expires); err != nil {
+				problems = append(problems, fmt.Errorf("%s:%d: expires %q is not a YYYY-MM-DD date", file, s.Line, s.Expires))
+			}
+		}
+	}
+	return suppressions, errors.Join(problems...)
+}
+
+func (s *Suppression) Expired(now time.Time) bool {
+	return s.Expires != "" && !now.Before(s.expiry.AddDate(0, 0, 1))
+}
+
+func (s *Suppression) String() string {
+	text := fmt.Sprintf("%s %s (owner: %s", s.Rule, s.Resource, s.Owner)
+	if s.Expires != "" {
+		text += ", expires: " + s.Expires
+	}
+	return text + "): " + strings.TrimSpace(s.Justification)
+}
+
+// Move the matches accepted by a suppression to Suppressed: expired suppressions are not applied, and are returned
+// to flag them
+func Suppress(results []Result, suppressions []Suppression, now time.Time) (expired []Suppression) {
+	var active []*Suppression
+	for i := range suppressions {
+		if suppressions[i].Expired(now) {
+			expired = append(expired, suppressions[i])
+		} else {
+			active = append(active, &suppressions[i])
+		}
+	}
+
+	for i := range results {
+		result := &results[i]
+		matches := make([]Match, 0, len(result.Matches))
+		for _, match := range result.Matches {
+			if s := suppressionOf(active, result.Rule, &match); s != nil {
+				result.Suppressed = append(result.Suppressed, SuppressedMatch{Match: match, Suppression: s})
+			} else {
+				matches = append(matches, match)
+			}
+		}
+		result.Matches = matches
+	}
+	return expired
+}
+
+func suppressionOf(suppressions []*Suppression, rule string, match *Match) *Suppression {
+	for _, s := range suppressions {
+		if strings.EqualFold(s.Rule, rule) && match.identifiedBy(s.Resource) {
+			return s
+		}
+	}
+	return nil
+}
+
+// Whether resource is the Arn or the Name of the match, or the identifier its Arn ends with (the ID of an instance,
+// the name of a role or a bucket). The other IDs and names of its properties, as AccountId or VpcId of an instance,
+// identify other resources: only the nodes without an Arn are identified by their own key
+func (m *Match) identifiedBy(resource string) bool {
+	if resource == "" {
+		return false
+	}
+	if resource == m.Arn || resource == m.Name {
+		return true
+	}
+	if parsed, err := arn.Parse(m.Arn); err == nil {
+		return parsed.Resource[strings.LastIndexAny(parsed.Resource, ":/")+1:] == resource
+	}
+	for _, key := range identityKeys {
+		if value, ok := m.Properties[key]; ok && fmt.Sprintf("%v", value) == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// The identifiers of the resources without an Arn
+var identityKeys = []string{"VpcId"}
@@ -12,6 +12,11 @@ import (
 )
 
 func Zip(path string, profile string, values map[string]interface{}) {
+	ZipAccounts(path, profile, map[string]map[string]interface{}{"": values})
+}
+
+// Store the dumps of multiple accounts in a single archive: each account has its own folder
+func ZipAccounts(path string, profile string, accounts map[string]map[string]interface{}) {
 	logger := logging.GetLogManager()
 	today := time.Now().Format("20060102")
 	profile = filepath.Clean(strings.ReplaceAll(profile, string(filepath.Separator), "-"))
@@ -36,15 +41,21 @@ func Zip(path string, profile string, values map[string]interface{}) {
 		}
 	}()
 
-	for key, value := range values {
-		writer, err := zipWriter.Create(fmt.Sprintf("%s_%s.json", key, today))
-		if err != nil {
-			logger.Error("Error on creating file", "err", err)
-		}
+	for account, values := range accounts {
+		for key, value := range values {
+			name := fmt.Sprintf("%s_%s.json", key, today)
+			if account != "" {
+				name = account + "/" + name
+			}
+			writer, err := zipWriter.Create(name)
+			if err != nil {
+				logger.Error("Error on creating file", "err", err)
+			}
 
-		data := logger.PrettyJSON(value)
-		if _, err := writer.Write(data); err != nil {
-			logger.Error("Error writing file content", "err", err)
+			data := logger.PrettyJSON(value)
+			if _, err := writer.Write(data); err != nil {
+				logger.Error("Error writing file content", "err", err)
+			}
 		}
 	}
 }
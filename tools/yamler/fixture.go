@@ -0,0 +1,41 @@
+package yamler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/primait/nuvola/pkg/connector/services/graph"
+)
+
+// Extension of the fixture of a rule: rule.yaml is tested with rule.test.json, in the same folder
+const FixtureExtension = ".test.json"
+
+// The data imported in a scratch store to test a rule and the matches expected from it, identified by their Arn
+// (or by Name when they have no Arn)
+type Fixture struct {
+	// Files of a dump by name (e.g. Whoami, Roles, Buckets), with the same content saved by dump
+	Dump map[string]json.RawMessage
+	// Nodes and relationships added as they are, after the dump
+	Graph struct {
+		Nodes         []graph.PathNode
+		Relationships []graph.PathRelationship
+	}
+	Expected []string
+}
+
+func FixturePath(rule string) string {
+	return strings.TrimSuffix(rule, filepath.Ext(rule)) + FixtureExtension
+}
+
+func ParseFixture(path string, content []byte) (*Fixture, error) {
+	fixture := &Fixture{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(fixture); err != nil {
+		return nil, fmt.Errorf("decoding fixture %s: %w", path, err)
+	}
+	return fixture, nil
+}
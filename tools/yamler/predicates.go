@@ -0,0 +1,241 @@
+package yamler
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Groups of predicates, as keys of the properties of a services rule
+const (
+	AllOf = "all_of"
+	AnyOf = "any_of"
+	Not   = "not"
+)
+
+// Operators comparing the properties under their key (e.g. LaunchTime: {older_than: 90d}): a plain value is
+// compared with eq
+const (
+	OpEq        = "eq"
+	OpIn        = "in"
+	OpRegex     = "regex"
+	OpExists    = "exists"
+	OpMissing   = "missing"
+	OpGt        = "gt"
+	OpGte       = "gte"
+	OpLt        = "lt"
+	OpLte       = "lte"
+	OpOlderThan = "older_than"
+	OpNewerThan = "newer_than"
+)
+
+var Operators = []string{OpEq, OpIn, OpRegex, OpExists, OpMissing, OpGt, OpGte, OpLt, OpLte, OpOlderThan, OpNewerThan}
+
+var cypherComparisons = map[string]string{OpEq: "=", OpIn: "IN", OpRegex: "=~", OpGt: ">", OpGte: ">=", OpLt: "<", OpLte: "<="}
+
+// A predicate of a services rule: a group (all_of, any_of, not) of Predicates, or an Operator comparing the
+// property Key, or its nested properties Key_..., case insensitive, with Value. The Value of older_than and newer_than is a
+// time.Duration, of exists a bool, of regex a full match pattern
+type Predicate struct {
+	Operator   string
+	Key        string
+	Value      interface{}
+	Predicates []Predicate
+}
+
+// The predicate of the properties of a services rule: all the items must match. Nested keys are joined with "_",
+// as the properties are flattened (e.g. MetadataOptions: {HttpTokens: optional} is MetadataOptions_HttpTokens)
+func ParsePredicate(props []map[string]interface{}) (Predicate, error) {
+	predicate := Predicate{Operator: AllOf}
+	for _, prop := range props {
+		predicates, err := parsePredicates("", prop)
+		if err != nil {
+			return predicate, err
+		}
+		predicate.Predicates = append(predicate.Predicates, predicates...)
+	}
+	return predicate, nil
+}
+
+func parsePredicates(prefix string, value interface{}) ([]Predicate, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		var predicates []Predicate
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			predicate, err := parseKey(prefix, key, v[key])
+			if err != nil {
+				return nil, err
+			}
+			predicates = append(predicates, predicate...)
+		}
+		return predicates, nil
+	case []interface{}:
+		var predicates []Predicate
+		for _, item := range v {
+			predicate, err := parsePredicates(prefix, item)
+			if err != nil {
+				return nil, err
+			}
+			predicates = append(predicates, predicate...)
+		}
+		return predicates, nil
+	}
+	if prefix == "" {
+		return nil, fmt.Errorf("expected a property, found %v", value)
+	}
+	return []Predicate{{Operator: OpEq, Key: prefix, Value: plainValue(value)}}, nil
+}
+
+func parseKey(prefix, key string, value interface{}) ([]Predicate, error) {
+	switch {
+	case key == AllOf || key == Not:
+		predicates, err := parsePredicates(prefix, value)
+		if err != nil {
+			return nil, err
+		}
+		return []Predicate{{Operator: key, Predicates: predicates}}, nil
+	case key == AnyOf:
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s expects a list of alternatives", AnyOf)
+		}
+		group := Predicate{Operator: AnyOf}
+		for _, item := range items {
+			predicates, err := parsePredicates(prefix, item)
+			if err != nil {
+				return nil, err
+			}
+			group.Predicates = append(group.Predicates, Predicate{Operator: AllOf, Predicates: predicates})
+		}
+		return []Predicate{group}, nil
+	case prefix != "" && slices.Contains(Operators, key):
+		predicate, err := parseOperator(prefix, key, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", prefix, err)
+		}
+		return []Predicate{predicate}, nil
+	case prefix != "":
+		key = prefix + "_" + key
+	}
+	return parsePredicates(key, value)
+}
+
+func parseOperator(key, operator string, value interface{}) (Predicate, error) {
+	predicate := Predicate{Operator: operator, Key: key, Value: value}
+	switch operator {
+	case OpEq:
+		predicate.Value = plainValue(value)
+	case OpIn:
+		if _, ok := value.([]interface{}); !ok {
+			return predicate, fmt.Errorf("%s expects a list of values", operator)
+		}
+	case OpRegex:
+		pattern, ok := value.(string)
+		if !ok {
+			return predicate, fmt.Errorf("%s expects a pattern", operator)
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return predicate, fmt.Errorf("malformed %s: %w", operator, err)
+		}
+	case OpExists, OpMissing:
+		exists, ok := value.(bool)
+		if !ok {
+			return predicate, fmt.Errorf("%s expects true or false", operator)
+		}
+		// missing: true is exists: false
+		predicate.Operator, predicate.Value = OpExists, exists == (operator == OpExists)
+	case OpGt, OpGte, OpLt, OpLte:
+		switch value.(type) {
+		case int, int64, float64:
+		default:
+			return predicate, fmt.Errorf("%s expects a number", operator)
+		}
+	case OpOlderThan, OpNewerThan:
+		age, err := parseAge(value)
+		if err != nil {
+			return predicate, fmt.Errorf("%s: %w", operator, err)
+		}
+		predicate.Value = age
+	}
+	return predicate, nil
+}
+
+// An age in days (90), or with a unit: 90d, 2w or a duration such as 36h
+func parseAge(value interface{}) (time.Duration, error) {
+	day := 24 * time.Hour
+	switch v := value.(type) {
+	case int:
+		return time.Duration(v) * day, nil
+	case string:
+		for suffix, unit := range map[string]time.Duration{"d": day, "w": 7 * day} {
+			if n, err := strconv.Atoi(strings.TrimSuffix(v, suffix)); err == nil && strings.HasSuffix(v, suffix) {
+				return time.Duration(n) * unit, nil
+			}
+		}
+		if age, err := time.ParseDuration(v); err == nil {
+			return age, nil
+		}
+	}
+	return 0, fmt.Errorf("expected an age such as 90, 90d, 2w or 36h, found %v", value)
+}
+
+// Compared values are booleans when they look like them, as the properties of the dumps are
+func plainValue(value interface{}) interface{} {
+	if s, ok := value.(string); ok {
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// The cutoff of older_than and newer_than: the dates before it are older than the age
+func Cutoff(age time.Duration, now time.Time) time.Time {
+	return now.Add(-age).UTC()
+}
+
+// The Cypher condition of the predicate on the node variable: the keys and values are added to the arguments
+func (p *Predicate) cypher(node string, arguments map[string]interface{}, count *int) string {
+	switch p.Operator {
+	case AllOf, AnyOf, Not:
+		conditions := make([]string, len(p.Predicates))
+		for i := range p.Predicates {
+			conditions[i] = p.Predicates[i].cypher(node, arguments, count)
+		}
+		separator := " AND "
+		if p.Operator == AnyOf {
+			separator = " OR "
+		}
+		condition := "(" + strings.Join(conditions, separator) + ")"
+		if p.Operator == Not {
+			return "NOT " + condition
+		}
+		return condition
+	}
+
+	i := *count
+	*count++
+	arguments[fmt.Sprintf("key%d", i)] = p.Key
+	anyProperty := fmt.Sprintf(`any(prop in keys(%s) where (toLower(prop) = toLower($key%d) OR toLower(prop) STARTS WITH toLower($key%d) + '_')`,
+		node, i, i)
+	switch p.Operator {
+	case OpExists:
+		if p.Value == false {
+			return "NOT " + anyProperty + ")"
+		}
+		return anyProperty + ")"
+	case OpOlderThan, OpNewerThan:
+		arguments[fmt.Sprintf("value%d", i)] = Cutoff(p.Value.(time.Duration), time.Now()).Format(time.RFC3339)
+		comparison := "<"
+		if p.Operator == OpNewerThan {
+			comparison = ">"
+		}
+		return fmt.Sprintf(`%s AND datetime(%s[prop]) %s datetime($value%d))`, anyProperty, node, comparison, i)
+	}
+	arguments[fmt.Sprintf("value%d", i)] = p.Value
+	return fmt.Sprintf(`%s AND %s[prop] %s $value%d)`, anyProperty, node, cypherComparisons[p.Operator], i)
+}
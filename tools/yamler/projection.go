@@ -0,0 +1,118 @@
+package yamler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Columns of the records of a services rule: the Arn of the node, and the values selected by return as
+// [name, value] pairs, ordered by Arn
+const (
+	IdentifierColumn = "Arn"
+	ValuesColumn     = "Values"
+)
+
+var returnAlias = regexp.MustCompile(`(?i)^\s*(\S+)\s+as\s+(\S+)\s*$`)
+
+// A key of return: the properties starting with it, as IamInstanceProfile returns IamInstanceProfile_Arn too, or
+// a pattern of the flattened keys with * (NetworkInterfaces_*_GroupId). Renamed with "AS alias", a key returns only
+// its property and its nested properties (Tag AS Name does not return Tags). Dots can be used for the nested keys
+// (MetadataOptions.HttpTokens)
+type Projection struct {
+	Key     string
+	Alias   string
+	pattern *regexp.Regexp
+}
+
+func ParseReturn(keys []string) ([]Projection, error) {
+	projections := make([]Projection, 0, len(keys))
+	for _, key := range keys {
+		projection := Projection{Key: strings.TrimSpace(key)}
+		if m := returnAlias.FindStringSubmatch(key); m != nil {
+			projection.Key, projection.Alias = m[1], m[2]
+		}
+		projection.Key = strings.ReplaceAll(projection.Key, ".", "_")
+		switch {
+		case projection.Key == "" || strings.ContainsAny(projection.Key, " \t"):
+			return nil, fmt.Errorf("malformed return %q: expected a key, optionally followed by AS alias", key)
+		case projection.Alias == "":
+			// A trailing * is the prefix before it, as NetworkInterfaces_* returns NetworkInterfaces too
+			prefix := projection.Key
+			if strings.HasSuffix(prefix, "*") {
+				prefix = strings.TrimRight(strings.TrimRight(prefix, "*"), "_")
+			}
+			parts := strings.Split(prefix, "*")
+			for i := range parts {
+				parts[i] = regexp.QuoteMeta(parts[i])
+			}
+			projection.pattern = regexp.MustCompile("^" + strings.Join(parts, ".*") + ".*$")
+		case strings.Contains(projection.Key, "*"):
+			return nil, fmt.Errorf("malformed return %q: the keys matched by a pattern cannot be renamed", key)
+		default:
+			projection.pattern = regexp.MustCompile("^" + regexp.QuoteMeta(projection.Key) + "(_.*)?$")
+		}
+		projections = append(projections, projection)
+	}
+	return projections, nil
+}
+
+// The returned name of a property, if the projection selects it
+func (p *Projection) Name(key string) (string, bool) {
+	if !p.pattern.MatchString(key) {
+		return "", false
+	}
+	if p.Alias == "" {
+		return key, true
+	}
+	return p.Alias + key[len(p.Key):], true
+}
+
+// The properties selected by the projections, by their returned names
+func Project(projections []Projection, properties map[string]interface{}) map[string]interface{} {
+	values := make(map[string]interface{})
+	for key, value := range properties {
+		for i := range projections {
+			if name, ok := projections[i].Name(key); ok {
+				values[name] = value
+			}
+		}
+	}
+	return values
+}
+
+// The values of a record of a services rule: the [name, value] pairs returned by Neo4j, or the map of the
+// in-memory store
+func RecordValues(record map[string]interface{}) map[string]interface{} {
+	switch v := record[ValuesColumn].(type) {
+	case map[string]interface{}:
+		return v
+	case []interface{}:
+		values := make(map[string]interface{}, len(v))
+		for _, item := range v {
+			if pair, ok := item.([]interface{}); ok && len(pair) == 2 {
+				values[fmt.Sprintf("%v", pair[0])] = pair[1]
+			}
+		}
+		return values
+	}
+	return map[string]interface{}{}
+}
+
+// The Arn and the projection of each node matching a services rule, ordered by Arn
+func prepareResults(projections []Projection, arguments map[string]interface{}) string {
+	values := make([]string, len(projections))
+	for i := range projections {
+		arguments[fmt.Sprintf("return%d", i)] = projections[i].pattern.String()
+		if projections[i].Alias == "" {
+			values[i] = fmt.Sprintf("[k IN keys(s) WHERE k =~ $return%d | [k, s[k]]]", i)
+		} else {
+			arguments[fmt.Sprintf("alias%d", i)] = projections[i].Alias
+			values[i] = fmt.Sprintf("[k IN keys(s) WHERE k =~ $return%d | [$alias%d + substring(k, %d), s[k]]]", i, i, len(projections[i].Key))
+		}
+	}
+	if len(values) == 0 {
+		values = []string{"[]"}
+	}
+	return fmt.Sprintf("RETURN s.%s AS %s, %s AS %s\nORDER BY %s", IdentifierColumn, IdentifierColumn, strings.Join(values, " + "), ValuesColumn, IdentifierColumn)
+}
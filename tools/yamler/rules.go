@@ -0,0 +1,93 @@
+package yamler
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+
+	"github.com/primait/nuvola/tools/filesystem/files"
+)
+
+var ruleExtension = regexp.MustCompile(`^\.ya?ml$`)
+
+// A rule in a folder or in the embedded ruleset: Path is the file reported with its results
+type RuleFile struct {
+	Path string
+	fsys fs.FS
+	name string
+}
+
+func (r RuleFile) Read() ([]byte, error) {
+	return fs.ReadFile(r.fsys, r.name)
+}
+
+// The rule in the file: see ParseConf
+func (r RuleFile) Conf() (*Conf, error) {
+	content, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	return ParseConf(r.Path, content)
+}
+
+// The fixture next to the rule: nil if the rule has none
+func (r RuleFile) Fixture() (*Fixture, error) {
+	content, err := fs.ReadFile(r.fsys, FixturePath(r.name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseFixture(FixturePath(r.Path), content)
+}
+
+// The rules of the paths: files are taken as they are, folders are walked for .yaml and .yml files.
+// The same file is returned once
+func RuleFiles(paths []string) (rules []RuleFile, err error) {
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		p = files.NormalizePath(p)
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+
+		var found []RuleFile
+		if info.IsDir() {
+			if found, err = walkRules(os.DirFS(p), p); err != nil {
+				return nil, err
+			}
+		} else {
+			found = []RuleFile{{Path: p, fsys: os.DirFS(filepath.Dir(p)), name: filepath.Base(p)}}
+		}
+		for _, rule := range found {
+			if !seen[rule.Path] {
+				seen[rule.Path] = true
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// The rules of a ruleset embedded in the binary, reported under root
+func EmbeddedRuleFiles(fsys fs.FS, root string) ([]RuleFile, error) {
+	return walkRules(fsys, root)
+}
+
+func walkRules(fsys fs.FS, root string) (rules []RuleFile, err error) {
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && ruleExtension.MatchString(path.Ext(name)) {
+			rules = append(rules, RuleFile{Path: filepath.Join(root, filepath.FromSlash(name)), fsys: fsys, name: name})
+		}
+		return nil
+	})
+	return rules, err
+}
@@ -0,0 +1,140 @@
+package yamler
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// Properties naming each kind of target of a privilege escalation rule: RDS clusters and instances have different
+// identifiers
+var TargetProperties = map[string][]string{
+	"Policy":   {"Name"},
+	"Role":     {"RoleName"},
+	"Action":   {"Action"},
+	"Group":    {"GroupName"},
+	"User":     {"UserName"},
+	"S3":       {"Name"},
+	"Ec2":      {"InstanceId"},
+	"Vpc":      {"VpcId"},
+	"Lambda":   {"FunctionName"},
+	"Rds":      {"DBInstanceIdentifier", "DBClusterIdentifier"},
+	"Dynamodb": {"Name"},
+	"Redshift": {"ClusterIdentifier"},
+}
+
+// The flattened keys of the tags of the resources (e.g. Tags_0_Key, with its Tags_0_Value)
+const TagKeyPattern = "(Tags|TagList|TagSet)_[0-9]+_Key"
+
+var targetKeys = []string{"kind", "name", "arn", "tags", "properties"}
+
+// A target of a privilege escalation rule: the nodes with the Label matching all the criteria set. A target is
+// "kind: name", or a mapping with the kind and any of name, arn, tags and properties (predicates as the ones of
+// services rules)
+type Target struct {
+	Label     string
+	Name      string
+	Arn       string
+	Tags      map[string]string
+	Predicate *Predicate
+}
+
+func (f *Find) Targets() ([]Target, error) {
+	targets := make([]Target, 0, len(f.Target))
+	for _, item := range f.Target {
+		target, err := ParseTarget(item)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+func ParseTarget(item map[string]interface{}) (Target, error) {
+	kind, ok := item["kind"]
+	if !ok {
+		if len(item) != 1 {
+			return Target{}, fmt.Errorf("a target must be a single kind: name, or a mapping with the kind")
+		}
+		for key, name := range item {
+			kind, item = key, map[string]interface{}{"name": name}
+		}
+	}
+
+	target := Target{Label: cases.Title(language.Und).String(fmt.Sprintf("%v", kind))}
+	if _, ok := TargetProperties[target.Label]; !ok {
+		kinds := slices.Sorted(maps.Keys(TargetProperties))
+		return target, fmt.Errorf("unsupported target kind %q: expected one of %s", kind, strings.Join(kinds, ", "))
+	}
+	for _, key := range slices.Sorted(maps.Keys(item)) {
+		value := item[key]
+		switch key {
+		case "kind":
+		case "name":
+			target.Name = fmt.Sprintf("%v", value)
+		case "arn":
+			target.Arn = fmt.Sprintf("%v", value)
+		case "tags":
+			tags, ok := value.(map[string]interface{})
+			if !ok {
+				return target, fmt.Errorf("tags of a target expect a mapping of keys and values")
+			}
+			target.Tags = make(map[string]string, len(tags))
+			for k, v := range tags {
+				target.Tags[k] = fmt.Sprintf("%v", v)
+			}
+		case "properties":
+			predicates, err := parsePredicates("", value)
+			if err != nil {
+				return target, fmt.Errorf("properties of a target: %w", err)
+			}
+			target.Predicate = &Predicate{Operator: AllOf, Predicates: predicates}
+		default:
+			return target, fmt.Errorf("unknown key %q in target: expected one of %s", key, strings.Join(targetKeys, ", "))
+		}
+	}
+	return target, nil
+}
+
+// The condition on target of the targets of a privilege escalation rule: a node is a target if it matches one of them
+func prepareTargets(rule *Conf, arguments map[string]interface{}) string {
+	targets, err := rule.Find.Targets()
+	if err != nil {
+		rule.logger.Error("Malformed target: check the rule with `nuvola rules validate`", "rule", rule.Name, "err", err)
+	}
+
+	var count int
+	conditions := make([]string, len(targets))
+	for i, target := range targets {
+		arguments[fmt.Sprintf("targetType%d", i)] = target.Label
+		criteria := []string{fmt.Sprintf("$targetType%d IN LABELS(target)", i)}
+		if target.Name != "" {
+			arguments[fmt.Sprintf("target%d", i)] = target.Name
+			names := make([]string, 0, len(TargetProperties[target.Label]))
+			for _, property := range TargetProperties[target.Label] {
+				names = append(names, fmt.Sprintf("target.%s = $target%d", property, i))
+			}
+			criteria = append(criteria, "("+strings.Join(names, " OR ")+")")
+		}
+		if target.Arn != "" {
+			arguments[fmt.Sprintf("targetArn%d", i)] = target.Arn
+			criteria = append(criteria, fmt.Sprintf("target.Arn = $targetArn%d", i))
+		}
+		for j, key := range slices.Sorted(maps.Keys(target.Tags)) {
+			arguments["tagKeyPattern"] = TagKeyPattern
+			arguments[fmt.Sprintf("targetTagKey%d_%d", i, j)] = key
+			arguments[fmt.Sprintf("targetTagValue%d_%d", i, j)] = target.Tags[key]
+			criteria = append(criteria, fmt.Sprintf("any(k IN keys(target) WHERE k =~ $tagKeyPattern AND target[k] = $targetTagKey%d_%d AND target[left(k, size(k) - 3) + 'Value'] = $targetTagValue%d_%d)", i, j, i, j))
+		}
+		if target.Predicate != nil && len(target.Predicate.Predicates) > 0 {
+			criteria = append(criteria, target.Predicate.cypher("target", arguments, &count))
+		}
+		conditions[i] = "(" + strings.Join(criteria, " AND ") + ")"
+	}
+	return strings.Join(conditions, " OR ")
+}
@@ -0,0 +1,302 @@
+package yamler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/primait/nuvola/pkg/connector/services/graph"
+
+	"go.yaml.in/yaml/v3"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// A problem of a rule, at the line of the YAML node causing it
+type Problem struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+}
+
+// Principals with effective permissions (HAS_PERMISSION): the only ones a find rule can match as who
+var principalLabels = []string{"User", "Role"}
+
+var (
+	// The errors of the YAML decoder are prefixed by their line
+	lineError    = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	unknownField = regexp.MustCompile(`^field (\S+) not found in type \S+$`)
+	// Parameters of a Cypher query: $name
+	cypherParameter = regexp.MustCompile(`\$([A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+// Check a rule strictly: unknown and mistyped keys, unknown labels in services, malformed predicates in properties
+// and keys in return, malformed or unknown permissions in find.with (isAction looks them up in the catalog),
+// target kinds not supported by the queries and the parameters of cypher
+func Validate(file string, content []byte, isAction func(string) bool) (problems []Problem) {
+	report := func(line int, format string, args ...interface{}) {
+		problems = append(problems, Problem{File: file, Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+	reportError := func(message string) {
+		if m := lineError.FindStringSubmatch(message); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			if field := unknownField.FindStringSubmatch(m[2]); field != nil {
+				report(line, "unknown key %q", field[1])
+			} else {
+				report(line, "%s", m[2])
+			}
+		} else {
+			report(1, "%s", strings.TrimPrefix(message, "yaml: "))
+		}
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		reportError(err.Error())
+		return
+	}
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		report(document.Line, "the rule must be a mapping")
+		return
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	var conf Conf
+	if err := decoder.Decode(&conf); err != nil && !errors.Is(err, io.EOF) {
+		var typeError *yaml.TypeError
+		if errors.As(err, &typeError) {
+			for _, e := range typeError.Errors {
+				reportError(e)
+			}
+		} else {
+			reportError(err.Error())
+		}
+	}
+
+	rule := document.Content[0]
+	if key, value := mappingValue(rule, "name"); key == nil {
+		report(rule.Line, "name is required")
+	} else if value.Value == "" {
+		report(key.Line, "name is empty")
+	}
+
+	if key, value := mappingValue(rule, "severity"); key != nil && value.Kind == yaml.ScalarNode && SeverityLevel(value.Value) < 0 {
+		report(value.Line, "unknown severity %q: expected one of %s", value.Value, strings.Join(Severities, ", "))
+	}
+
+	_, returned := mappingValue(rule, "return")
+	for _, key := range scalars(returned) {
+		if _, err := ParseReturn([]string{key.Value}); err != nil {
+			report(key.Line, "%v", err)
+		}
+	}
+
+	servicesKey, services := mappingValue(rule, "services")
+	findKey, find := mappingValue(rule, "find")
+	cypherKey, cypher := mappingValue(rule, "cypher")
+	switch {
+	case cypherKey != nil:
+		if servicesKey != nil {
+			report(servicesKey.Line, "services is ignored by a rule with cypher")
+		}
+		if findKey != nil {
+			report(findKey.Line, "find is ignored by a rule with cypher")
+		}
+		problems = append(problems, validateCypher(file, rule, cypherKey, cypher)...)
+	case servicesKey == nil && findKey == nil:
+		report(rule.Line, "one of services, find or cypher is required")
+	case servicesKey != nil:
+		if findKey != nil {
+			report(findKey.Line, "find is ignored by a rule with services")
+		}
+		for _, service := range scalars(services) {
+			if label := cases.Title(language.Und).String(service.Value); !slices.Contains(graph.NodeLabels, label) {
+				report(service.Line, "unknown label %q in services: expected one of %s", service.Value, strings.Join(graph.ServiceLabels, ", "))
+			}
+		}
+		if _, properties := mappingValue(rule, "properties"); properties != nil && properties.Kind == yaml.SequenceNode {
+			for _, item := range properties.Content {
+				// The items that are not mappings are reported by the decoder
+				var value interface{}
+				if item.Kind != yaml.MappingNode || item.Decode(&value) != nil {
+					continue
+				}
+				if _, err := parsePredicates("", value); err != nil {
+					report(item.Line, "malformed properties: %v", err)
+				}
+			}
+		}
+	default:
+		if key, _ := mappingValue(rule, "properties"); key != nil {
+			report(key.Line, "properties are ignored by a rule with find")
+		}
+		problems = append(problems, validateFind(file, findKey, find, isAction)...)
+	}
+	if cypherKey == nil {
+		for _, name := range []string{"parameters", "identifiers"} {
+			if key, _ := mappingValue(rule, name); key != nil {
+				report(key.Line, "%s is only used by a rule with cypher", name)
+			}
+		}
+	}
+	slices.SortStableFunc(problems, func(a, b Problem) int { return a.Line - b.Line })
+	return
+}
+
+func validateFind(file string, findKey, find *yaml.Node, isAction func(string) bool) (problems []Problem) {
+	report := func(line int, format string, args ...interface{}) {
+		problems = append(problems, Problem{File: file, Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+	if find.Kind != yaml.MappingNode {
+		// Already reported by the decoder
+		return
+	}
+
+	if key, _ := mappingValue(find, "to"); key != nil {
+		report(key.Line, "to is ignored: the permissions are set by with and the destinations by target")
+	}
+
+	_, who := mappingValue(find, "who")
+	for _, principal := range scalars(who) {
+		if label := cases.Title(language.Und).String(principal.Value); !slices.Contains(principalLabels, label) {
+			report(principal.Line, "unsupported principal %q in who: expected one of %s", principal.Value, strings.Join(principalLabels, ", "))
+		}
+	}
+
+	withKey, with := mappingValue(find, "with")
+	if withKey == nil || len(with.Content) == 0 {
+		report(findKey.Line, "find requires at least one permission in with")
+	}
+	for _, permission := range scalars(with) {
+		service, action, ok := strings.Cut(permission.Value, ":")
+		switch {
+		case !ok || service == "" || action == "":
+			report(permission.Line, "malformed permission %q in with: expected service:Action", permission.Value)
+		case strings.ContainsAny(permission.Value, "*?"):
+			report(permission.Line, "wildcards are not supported in with: %q", permission.Value)
+		case !isAction(permission.Value):
+			report(permission.Line, "unknown action %q in with: it is not in the catalog", permission.Value)
+		}
+	}
+
+	targetKey, targets := mappingValue(find, "target")
+	for _, name := range []string{"max_depth", "relationships", "exclude_relationships", "paths"} {
+		if key, _ := mappingValue(find, name); key != nil && targetKey == nil {
+			report(key.Line, "%s is only used by a rule with target", name)
+		}
+	}
+	if key, depth := mappingValue(find, "max_depth"); key != nil {
+		if n, err := strconv.Atoi(depth.Value); err == nil && n < 1 {
+			report(depth.Line, "max_depth must be at least 1")
+		}
+	}
+	if key, paths := mappingValue(find, "paths"); key != nil && paths.Value != PathsShortest && paths.Value != PathsAll {
+		report(paths.Line, "unknown paths %q: expected %s or %s", paths.Value, PathsShortest, PathsAll)
+	}
+	for _, name := range []string{"relationships", "exclude_relationships"} {
+		_, types := mappingValue(find, name)
+		for _, t := range scalars(types) {
+			switch upper := strings.ToUpper(t.Value); {
+			case !slices.Contains(graph.RelationshipTypes, upper):
+				report(t.Line, "unknown relationship type %q in %s: expected one of %s", t.Value, name, strings.Join(graph.RelationshipTypes, ", "))
+			case name == "relationships" && slices.Contains(PolicyRelationships, upper):
+				report(t.Line, "%s is never walked: the permissions are walked through HAS_PERMISSION", t.Value)
+			case name == "relationships" && upper == AccountRelationship:
+				report(t.Line, "%s is never walked: the roles trusting the account are walked through CAN_ASSUME", t.Value)
+			}
+		}
+	}
+
+	if targets == nil || targets.Kind != yaml.SequenceNode {
+		return
+	}
+	for _, target := range targets.Content {
+		if target.Kind != yaml.MappingNode {
+			continue
+		}
+		var item map[string]interface{}
+		if err := target.Decode(&item); err != nil {
+			continue
+		}
+		if _, err := ParseTarget(item); err != nil {
+			report(target.Line, "%s", err)
+		}
+	}
+	return
+}
+
+// Every parameter of the query must be defined and every parameter defined must be used
+func validateCypher(file string, rule, cypherKey, cypher *yaml.Node) (problems []Problem) {
+	report := func(line int, format string, args ...interface{}) {
+		problems = append(problems, Problem{File: file, Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+	if cypher.Kind != yaml.ScalarNode || strings.TrimSpace(cypher.Value) == "" {
+		report(cypherKey.Line, "cypher is empty")
+		return
+	}
+	if key, identifiers := mappingValue(rule, "identifiers"); key == nil || len(identifiers.Content) == 0 {
+		report(cypherKey.Line, "identifiers is required by a rule with cypher: the returned columns identifying a finding")
+	}
+
+	_, parameters := mappingValue(rule, "parameters")
+	used := make(map[string]bool)
+	for _, m := range cypherParameter.FindAllStringSubmatchIndex(cypher.Value, -1) {
+		name := cypher.Value[m[2]:m[3]]
+		if used[name] {
+			continue
+		}
+		used[name] = true
+		if key, _ := mappingValue(parameters, name); key == nil {
+			// The lines of a literal block are the lines of the query
+			line := cypher.Line
+			if cypher.Style == yaml.LiteralStyle {
+				line += 1 + strings.Count(cypher.Value[:m[0]], "\n")
+			}
+			report(line, "parameter $%s is not defined in parameters", name)
+		}
+	}
+	if parameters != nil && parameters.Kind == yaml.MappingNode {
+		for i := 0; i < len(parameters.Content); i += 2 {
+			if key := parameters.Content[i]; !used[key.Value] {
+				report(key.Line, "parameter %q is not used by cypher", key.Value)
+			}
+		}
+	}
+	return
+}
+
+// The key and value nodes of a mapping: nil if the key is missing
+func mappingValue(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// The scalar items of a sequence: the others are reported by the decoder
+func scalars(node *yaml.Node) (items []*yaml.Node) {
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return
+	}
+	for _, item := range node.Content {
+		if item.Kind == yaml.ScalarNode {
+			items = append(items, item)
+		}
+	}
+	return
+}
@@ -1,18 +1,23 @@
 package yamler
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"os"
-	"reflect"
-	"strconv"
+	"slices"
 	"strings"
 
+	"github.com/primait/nuvola/pkg/connector/services/graph"
 	"github.com/primait/nuvola/pkg/io/logging"
 	"github.com/primait/nuvola/tools/filesystem/files"
 
+	"go.yaml.in/yaml/v3"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
-	"gopkg.in/yaml.v2"
 )
 
 type Conf struct {
@@ -23,39 +28,181 @@ type Conf struct {
 	Return      []string                 `yaml:"return"`
 	Enabled     bool                     `yaml:"enabled"`
 	Find        Find                     `yaml:"find,omitempty"`
+	Severity    string                   `yaml:"severity,omitempty"`
+	Category    string                   `yaml:"category,omitempty"`
+	Tags        []string                 `yaml:"tags,omitempty"`
+	References  []string                 `yaml:"references,omitempty"`
+	Remediation string                   `yaml:"remediation,omitempty"`
+	// Controls of compliance frameworks covered by the rule, by framework (e.g. cis-aws-foundations-3.0.0: ["5.6"])
+	Compliance map[string][]string `yaml:"compliance,omitempty"`
+	// A query for what services and find cannot express, with its named parameters ($name in the query) and the
+	// returned columns identifying each finding
+	Cypher      string                 `yaml:"cypher,omitempty"`
+	Parameters  map[string]interface{} `yaml:"parameters,omitempty"`
+	Identifiers []string               `yaml:"identifiers,omitempty"`
 	logger      logging.LogManager
 }
 
 type Find struct {
 	Who    []string
 	To     []string
-	With   []string            `yaml:"with,omitempty"`
-	Target []map[string]string `yaml:"target,omitempty"`
+	With   []string                 `yaml:"with,omitempty"`
+	Target []map[string]interface{} `yaml:"target,omitempty"`
+	// Permissions and trusts guarded by conditions are included unless set to false
+	Conditional *bool `yaml:"conditional,omitempty"`
+	// Length of the paths to the targets: DefaultMaxDepth if not set
+	MaxDepth int `yaml:"max_depth,omitempty"`
+	// Types of the relationships walked to the targets (all by default) and the ones never walked: the raw policy
+	// relationships and BELONGS_TO are never walked
+	Relationships        []string `yaml:"relationships,omitempty"`
+	ExcludeRelationships []string `yaml:"exclude_relationships,omitempty"`
+	// The paths to each target: PathsShortest (default) or PathsAll, every simple path within MaxDepth
+	Paths string `yaml:"paths,omitempty"`
 }
 
-func GetConf(file string) (c *Conf) {
-	logger := logging.GetLogManager()
-	c = &Conf{Enabled: true, logger: logger}
+func (f *Find) ExcludeConditional() bool {
+	return f.Conditional != nil && !*f.Conditional
+}
+
+func (f *Find) Depth() int {
+	if f.MaxDepth > 0 {
+		return f.MaxDepth
+	}
+	return DefaultMaxDepth
+}
+
+func (f *Find) AllPaths() bool {
+	return strings.EqualFold(f.Paths, PathsAll)
+}
+
+// The relationships not walked to the targets: the raw policy relationships, BELONGS_TO and the excluded ones
+func (f *Find) ExcludedRelationships() []string {
+	excluded := append(slices.Clone(PolicyRelationships), AccountRelationship)
+	for _, t := range f.ExcludeRelationships {
+		excluded = append(excluded, strings.ToUpper(t))
+	}
+	return excluded
+}
+
+// Whether a relationship is walked to the targets
+func (f *Find) Walks(relationshipType string) bool {
+	if slices.Contains(f.ExcludedRelationships(), relationshipType) {
+		return false
+	}
+	return len(f.Relationships) == 0 || slices.ContainsFunc(f.Relationships, func(t string) bool { return strings.EqualFold(t, relationshipType) })
+}
+
+// Permissions are walked through HAS_PERMISSION: raw ALLOWS, DENIES, HAS_BOUNDARY and HAS_SCP relationships do not
+// grant anything
+var PolicyRelationships = []string{"ALLOWS", "DENIES", "HAS_BOUNDARY", "HAS_SCP"}
+
+// A principal does not assume the roles trusting its account through BELONGS_TO: the ones its permissions allow it
+// to assume are linked with CAN_ASSUME
+const AccountRelationship = "BELONGS_TO"
+
+const DefaultMaxDepth = 10
+
+// The paths of a privilege escalation rule to its targets
+const (
+	PathsShortest = "shortest"
+	PathsAll      = "all"
+)
+
+// Columns of the records of a privilege escalation rule: a principal and one of its paths to a target
+const (
+	PrincipalColumn = "Principal"
+	PathColumn      = "Path"
+)
+
+// Severities of the rules, from the lowest
+var Severities = []string{"info", "low", "medium", "high", "critical"}
+
+const DefaultSeverity = "medium"
+
+func GetConf(file string) (*Conf, error) {
 	yamlFile, err := os.ReadFile(files.NormalizePath(file))
 	if err != nil {
-		logger.Error("Error on reading rule file", "err", err)
+		return nil, err
 	}
+	return ParseConf(file, yamlFile)
+}
+
+// The rule in the content of file. A malformed rule is returned with its error, as far as it was decoded (its name
+// may be empty): `nuvola rules validate` reports its problems at their lines
+func ParseConf(file string, yamlFile []byte) (*Conf, error) {
+	c := &Conf{Enabled: true, logger: logging.GetLogManager()}
 	c.Enabled = true // Default value is: Enabled
-	err = yaml.Unmarshal(yamlFile, &c)
-	if err != nil {
-		logger.Error("Error unmarshalling yamlFile", "err", err)
+	c.Severity = DefaultSeverity
+	// Unknown keys are errors, as for `nuvola rules validate`
+	decoder := yaml.NewDecoder(bytes.NewReader(yamlFile))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return c, fmt.Errorf("malformed rule %s: %w", file, err)
+	}
+	c.Severity = strings.ToLower(c.Severity)
+	if err := c.check(); err != nil {
+		return c, fmt.Errorf("malformed rule %s: %w", file, err)
 	}
+	return c, nil
+}
 
-	return c
+// The errors that would stop PrepareQuery
+func (c *Conf) check() error {
+	if SeverityLevel(c.Severity) < 0 {
+		return fmt.Errorf("unknown severity %q: expected one of %s", c.Severity, strings.Join(Severities, ", "))
+	}
+	if c.Cypher == "" && len(c.Services) == 0 && len(c.Find.To) == 0 && len(c.Find.Who) == 0 && len(c.Find.With) == 0 {
+		return errors.New("expected one of cypher, services or find")
+	}
+	if _, err := ParseReturn(c.Return); err != nil {
+		return err
+	}
+	if _, err := ParsePredicate(c.Properties); err != nil {
+		return fmt.Errorf("properties: %w", err)
+	}
+	for _, permission := range c.Find.With {
+		if !strings.Contains(permission, ":") {
+			return fmt.Errorf("malformed permission %q in find.with: expected service:Action", permission)
+		}
+	}
+	for _, t := range c.Find.Relationships {
+		if !slices.Contains(graph.RelationshipTypes, strings.ToUpper(t)) {
+			return fmt.Errorf("unknown relationship type %q in find.relationships", t)
+		}
+	}
+	if _, err := c.Find.Targets(); err != nil {
+		return fmt.Errorf("find.target: %w", err)
+	}
+	return nil
+}
+
+// The position of the severity in Severities: -1 if it is unknown
+func SeverityLevel(severity string) int {
+	return slices.Index(Severities, strings.ToLower(severity))
+}
+
+func (c *Conf) HasTag(tags ...string) bool {
+	for _, tag := range c.Tags {
+		if slices.ContainsFunc(tags, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			return true
+		}
+	}
+	return false
 }
 
 func PrepareQuery(config *Conf) (query string, arguments map[string]interface{}) {
 	arguments = make(map[string]interface{}, 0)
-	if len(config.Services) > 0 {
+	projections, err := ParseReturn(config.Return)
+	if err != nil {
+		config.logger.Error("Malformed rule: check it with `nuvola rules validate`", "rule", config.Name, "err", err)
+	}
+	if config.Cypher != "" {
+		query = prepareCypher(config, arguments)
+	} else if len(config.Services) > 0 {
 		// Direct access to properties
 		query = prepareService(config.Services, arguments) +
-			prepareProperties(config.Properties, arguments) +
-			prepareResults(config.Return, arguments)
+			prepareProperties(config, arguments) +
+			prepareResults(projections, arguments)
 	} else if len(config.Find.To) > 0 || len(config.Find.Who) > 0 || len(config.Find.With) > 0 {
 		if len(config.Find.Target) > 0 {
 			query = prepareQueryPrivEsc(config, arguments)
@@ -63,100 +210,156 @@ func PrepareQuery(config *Conf) (query string, arguments map[string]interface{})
 			query = preparePathQuery(config, arguments)
 		}
 	} else {
-		config.logger.Error("Malformed rule")
+		config.logger.Error("Malformed rule: check it with `nuvola rules validate`", "rule", config.Name)
 	}
 	return query, arguments
 }
 
+// The query of a cypher rule is used as it is, with its named parameters
+func prepareCypher(rule *Conf, arguments map[string]interface{}) string {
+	maps.Copy(arguments, rule.Parameters)
+	return rule.Cypher
+}
+
 func preparePathQuery(rule *Conf, arguments map[string]interface{}) string {
-	template := "MATCH m%d = (who)-[:HAS_POLICY]->(:Policy)-[:ALLOWS]->(:Action {Service: $service%d, Action: $action%d}) \n"
-	var matchQueries, whereFilters, returnValues strings.Builder
+	template := "MATCH m%d = (who)-[:MEMBER_OF*0..1]->()-[:HAS_POLICY]->(:Policy)-[:ALLOWS]->(a%d:Action {Service: $service%d, Action: $action%d}) \n"
+	var matchQueries, whereFilters, effectiveFilters, returnValues strings.Builder
 
 	for i, perm := range rule.Find.With {
-		withSplit := strings.Split(perm, ":")
-		service, action := withSplit[0], withSplit[1]
+		service, action := splitPermission(rule, perm)
 		arguments[fmt.Sprintf("action%d", i)] = action
 		arguments[fmt.Sprintf("service%d", i)] = service
 
-		matchQueries.WriteString(fmt.Sprintf(template, i, i, i))
+		matchQueries.WriteString(fmt.Sprintf(template, i, i, i, i))
+		effectiveFilters.WriteString(fmt.Sprintf("(who)-[:HAS_PERMISSION%s]->(a%d) AND ", permissionFilter(rule), i))
 		returnValues.WriteString(fmt.Sprintf("NODES(m%d) + ", i))
 	}
 	query := matchQueries.String()
 	returnValuesStr := strings.TrimSuffix(returnValues.String(), " + ")
 
-	if len(rule.Find.Who) > 0 {
-		whereFilters.WriteString("WHERE (")
-		for i, who := range rule.Find.Who {
-			arguments[fmt.Sprintf("who%d", i)] = cases.Title(language.Und).String(who)
-			whereFilters.WriteString(fmt.Sprintf(`$who%d IN LABELS(who) OR `, i))
-		}
-		whereFiltersStr := strings.TrimSuffix(whereFilters.String(), " OR ")
-		query += whereFiltersStr + ") "
-	}
+	query += prepareWhoFilters(rule, &whereFilters, &effectiveFilters, arguments)
 	query += fmt.Sprintf("\nWITH %s AS nds UNWIND nds as nd RETURN DISTINCT nd", returnValuesStr)
 	return query
 }
 
+// Each principal with one of its paths to a target, ordered by principal and length of the path
 func prepareQueryPrivEsc(rule *Conf, arguments map[string]interface{}) string {
-	template := "MATCH m%d = (who)-[:HAS_POLICY]->(:Policy)-[:ALLOWS]->(:Action {Service: $service%d, Action: $action%d}) \n"
-	var matchQueries, whereFilters, returnValues, shortestPath strings.Builder
+	query, _ := matchPrivEsc(rule, arguments)
+	query += fmt.Sprintf("\nRETURN DISTINCT who AS %s, p0 AS %s\nORDER BY %s.Arn, length(%s)", PrincipalColumn, PathColumn, PrincipalColumn, PathColumn)
+	rule.logger.Debug("Privilege escalation query", "query", query)
+	return query
+}
+
+// The query of a privilege escalation rule returning all its paths, to draw them: the paths granting the
+// permissions (m0, m1, ...) and the paths to the targets (p0). Cypher rules are run as they are
+func PreparePathsQuery(config *Conf) (query string, arguments map[string]interface{}) {
+	arguments = make(map[string]interface{}, 0)
+	if config.Cypher != "" {
+		return prepareCypher(config, arguments), arguments
+	}
+	if len(config.Find.Target) == 0 {
+		return "", arguments
+	}
+	query, paths := matchPrivEsc(config, arguments)
+	query += "\nRETURN " + strings.Join(paths, ", ")
+	return query, arguments
+}
+
+// The MATCH clauses of a privilege escalation rule and the names of its paths
+func matchPrivEsc(rule *Conf, arguments map[string]interface{}) (query string, paths []string) {
+	template := "MATCH m%d = (who)-[:MEMBER_OF*0..1]->()-[:HAS_POLICY]->(:Policy)-[:ALLOWS]->(a%d:Action {Service: $service%d, Action: $action%d}) \n"
+	var matchQueries, whereFilters, effectiveFilters, targetPath strings.Builder
 
 	for i, perm := range rule.Find.With {
-		withSplit := strings.Split(perm, ":")
-		service, action := withSplit[0], withSplit[1]
+		service, action := splitPermission(rule, perm)
 		arguments[fmt.Sprintf("action%d", i)] = action
 		arguments[fmt.Sprintf("service%d", i)] = service
 
-		matchQueries.WriteString(fmt.Sprintf(template, i, i, i))
-		returnValues.WriteString(fmt.Sprintf("NODES(m%d) + ", i))
+		matchQueries.WriteString(fmt.Sprintf(template, i, i, i, i))
+		effectiveFilters.WriteString(fmt.Sprintf("(who)-[:HAS_PERMISSION%s]->(a%d) AND ", permissionFilter(rule), i))
+		paths = append(paths, fmt.Sprintf("m%d", i))
+	}
+	query = matchQueries.String()
+
+	query += prepareWhoFilters(rule, &whereFilters, &effectiveFilters, arguments)
+
+	if len(rule.Find.Target) > 0 {
+		// Role chaining is walked through CAN_ASSUME, also to the roles trusting the account of the principal
+		arguments["excludedRelationships"] = rule.Find.ExcludedRelationships()
+		pattern := fmt.Sprintf("(who)-[%s*1..%d]->(target)", relationshipTypes(rule), rule.Find.Depth())
+		if rule.Find.AllPaths() {
+			targetPath.WriteString("\nMATCH p0 = " + pattern)
+		} else {
+			targetPath.WriteString("\nMATCH p0 = allShortestPaths(" + pattern + ")")
+		}
+		targetPath.WriteString(fmt.Sprintf("\nWHERE (%s) AND NONE(r IN relationships(p0) WHERE type(r) IN $excludedRelationships)", prepareTargets(rule, arguments)))
+		// A permission does not reach the resources denied to the principal by a resource scoped Deny
+		targetPath.WriteString(" AND NONE(i IN range(0, length(p0) - 2) WHERE type(relationships(p0)[i]) = 'HAS_PERMISSION' AND type(relationships(p0)[i + 1]) = 'ON' AND coalesce(nodes(p0)[i + 2].Arn, '') IN coalesce(relationships(p0)[i].DeniedResources, []))")
+		if rule.Find.ExcludeConditional() {
+			targetPath.WriteString(" AND NONE(r IN relationships(p0) WHERE coalesce(r.Conditional, false))")
+		}
+		if rule.Find.AllPaths() {
+			// Simple paths: no node is visited twice
+			targetPath.WriteString(" AND ALL(n IN nodes(p0) WHERE single(m IN nodes(p0) WHERE m = n))")
+		}
+		paths = append(paths, "p0")
+	}
+	query += targetPath.String()
+	return
+}
+
+// The relationship types of find.relationships for a pattern (e.g. ":CAN_ASSUME|HAS_PERMISSION"): they cannot be
+// parameters, so only the known ones are used
+func relationshipTypes(rule *Conf) string {
+	types := make([]string, 0, len(rule.Find.Relationships))
+	for _, t := range rule.Find.Relationships {
+		t = strings.ToUpper(t)
+		if !slices.Contains(graph.RelationshipTypes, t) {
+			rule.logger.Error("Unknown relationship type: check the rule with `nuvola rules validate`", "rule", rule.Name, "type", t)
+		}
+		types = append(types, t)
+	}
+	if len(types) == 0 {
+		return ""
+	}
+	return ":" + strings.Join(types, "|")
+}
+
+// A permission of find.with in the "service:Action" form
+func splitPermission(rule *Conf, permission string) (service, action string) {
+	service, action, ok := strings.Cut(permission, ":")
+	if !ok {
+		rule.logger.Error("Malformed permission, expected service:Action", "rule", rule.Name, "with", permission)
+	}
+	return service, action
+}
+
+func permissionFilter(rule *Conf) string {
+	if rule.Find.ExcludeConditional() {
+		return " {Conditional: false}"
+	}
+	return ""
+}
+
+// Only the permissions resolved as effective (not explicitly denied) are considered
+func prepareWhoFilters(rule *Conf, whereFilters, effectiveFilters *strings.Builder, arguments map[string]interface{}) string {
+	var filters []string
+	if effectiveFilters.Len() > 0 {
+		filters = append(filters, strings.TrimSuffix(effectiveFilters.String(), " AND "))
 	}
-	returnValuesStr := strings.TrimSuffix(returnValues.String(), " + ")
-	query := matchQueries.String()
 
 	if len(rule.Find.Who) > 0 {
-		whereFilters.WriteString("WHERE (")
 		for i, who := range rule.Find.Who {
 			arguments[fmt.Sprintf("who%d", i)] = cases.Title(language.Und).String(who)
 			whereFilters.WriteString(fmt.Sprintf(`$who%d IN LABELS(who) OR `, i))
 		}
-		whereFiltersStr := strings.TrimSuffix(whereFilters.String(), " OR ")
-		query += whereFiltersStr + ") "
+		filters = append(filters, "("+strings.TrimSuffix(whereFilters.String(), " OR ")+")")
 	}
 
-	if len(rule.Find.Target) > 0 {
-		var targetWhereLabelFilters, targetWherePropertyFilters strings.Builder
-		for i, target := range rule.Find.Target {
-			for what, id := range target {
-				what = cases.Title(language.Und).String(what)
-				arguments[fmt.Sprintf("targetType%d", i)] = what
-				targetWhereLabelFilters.WriteString(fmt.Sprintf(`$targetType%d IN LABELS(target) OR `, i))
-
-				var blockProperty string
-				switch what {
-				case "Policy":
-					blockProperty = fmt.Sprintf(`target.Name = $target%d`, i)
-				case "Role":
-					blockProperty = fmt.Sprintf(`target.RoleName = $target%d`, i)
-				case "Action":
-					blockProperty = fmt.Sprintf(`target.Action = $target%d`, i)
-				case "Group":
-					blockProperty = fmt.Sprintf(`target.GroupName = $target%d`, i)
-				case "User":
-					blockProperty = fmt.Sprintf(`target.UserName = $target%d`, i)
-				}
-				arguments[fmt.Sprintf("target%d", i)] = id
-				targetWherePropertyFilters.WriteString(fmt.Sprintf("%s OR ", blockProperty))
-			}
-		}
-		targetWhereLabelFiltersStr := strings.TrimSuffix(targetWhereLabelFilters.String(), " OR ")
-		targetWherePropertyFiltersStr := strings.TrimSuffix(targetWherePropertyFilters.String(), " OR ")
-		shortestPath.WriteString(fmt.Sprintf("\nMATCH p0 = allShortestPaths((who)-[*1..10]->(target))\nWHERE (%s) AND (%s)", targetWhereLabelFiltersStr, targetWherePropertyFiltersStr))
-		returnValues.WriteString(" + NODES(p0)")
+	if len(filters) == 0 {
+		return ""
 	}
-	query += shortestPath.String()
-	query += fmt.Sprintf("\nWITH %s AS nds UNWIND nds as nd RETURN DISTINCT nd", returnValuesStr)
-	fmt.Println(query)
-	return query
+	return "WHERE " + strings.Join(filters, " AND ") + " "
 }
 
 func prepareService(services []string, arguments map[string]interface{}) string {
@@ -174,74 +377,22 @@ func prepareService(services []string, arguments map[string]interface{}) string
 	return query.String()
 }
 
-func prepareProperties(props []map[string]interface{}, arguments map[string]interface{}) string {
-	if len(props) == 0 {
+// The properties of a services rule as a condition on s: see ParsePredicate
+func prepareProperties(rule *Conf, arguments map[string]interface{}) string {
+	if len(rule.Properties) == 0 {
 		return "MATCH (s)\n"
 	}
 
-	var query strings.Builder
-	separator := "_"
-	query.WriteString("MATCH (s)\nWHERE")
-	var count int
-	for _, prop := range props {
-		subprops := walk(reflect.ValueOf(prop), separator)
-		for _, p := range subprops {
-			key, value := strings.Split(p, "__")[0], strings.Split(p, "__")[1]
-			arguments[fmt.Sprintf("key%d", count)] = key
-			if b, err := strconv.ParseBool(value); err == nil {
-				arguments[fmt.Sprintf("value%d", count)] = b
-			} else {
-				arguments[fmt.Sprintf("value%d", count)] = value
-			}
-			query.WriteString(fmt.Sprintf(` any(prop in keys(s) where toLower(prop) STARTS WITH toLower($key%d) AND s[prop] = $value%d) AND`, count, count))
-			count++
-		}
-	}
-	queryStr := strings.TrimSuffix(query.String(), " AND") + "\n"
-	return queryStr
-}
-
-//nolint:unused
-func prepareExactResults(results []string, arguments map[string]interface{}) string {
-	var query = "RETURN "
-
-	for i, result := range results {
-		if i < len(results)-1 {
-			query = fmt.Sprintf("%s s[$result%d], ", query, i)
-		} else {
-			query = fmt.Sprintf("%s s[$result%d]", query, i)
-		}
-		arguments["result"+strconv.Itoa(i)] = result
-	}
-	return query
-}
-
-func prepareResults(results []string, arguments map[string]interface{}) string {
-	var query = "RETURN s"
-	return query
-}
-
-func walk(v reflect.Value, separator string) []string {
-	var output []string
-	// Indirect through pointers and interfaces
-	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
-		v = v.Elem()
+	predicate, err := ParsePredicate(rule.Properties)
+	if err != nil {
+		rule.logger.Error("Malformed properties: check the rule with `nuvola rules validate`", "rule", rule.Name, "err", err)
 	}
-	switch v.Kind() {
-	case reflect.Array, reflect.Slice:
-		for i := 0; i < v.Len(); i++ {
-			output = append(output, walk(v.Index(i), separator)...)
-		}
-	case reflect.Map:
-		for _, k := range v.MapKeys() {
-			for _, e := range walk(v.MapIndex(k), separator) {
-				output = append(output, fmt.Sprintf("%s%s%s", k, separator, e))
-			}
-		}
-	default:
-		output = append(output, fmt.Sprintf("%s%v", separator, v))
+	var count int
+	conditions := make([]string, len(predicate.Predicates))
+	for i := range predicate.Predicates {
+		conditions[i] = predicate.Predicates[i].cypher("s", arguments, &count)
 	}
-	return output
+	return "MATCH (s)\nWHERE " + strings.Join(conditions, " AND ") + "\n"
 }
 
 func ArgsToQueryNeo4jBrowser(args map[string]interface{}) (argsOutput string) {
@@ -249,8 +400,14 @@ func ArgsToQueryNeo4jBrowser(args map[string]interface{}) (argsOutput string) {
 		switch val := v.(type) {
 		case bool:
 			argsOutput += fmt.Sprintf(`:param %s => %t; `, k, val)
-		default:
+		case int, int64, float64:
+			argsOutput += fmt.Sprintf(`:param %s => %v; `, k, val)
+		case string:
 			argsOutput += fmt.Sprintf(`:param %s => "%s"; `, k, val)
+		default:
+			// Lists and maps of the parameters of cypher rules
+			value, _ := json.Marshal(val)
+			argsOutput += fmt.Sprintf(`:param %s => %s; `, k, value)
 		}
 	}
 	argsOutput = strings.Trim(argsOutput, " ")